@@ -0,0 +1,33 @@
+// Command server serves the admin and the user APIs of the datastore
+// described by a config file
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/hanzezhenalex/auth/src"
+	"github.com/hanzezhenalex/auth/src/server"
+)
+
+func main() {
+	path := flag.String("config", "dev/config.json", "path of the config file")
+	flag.Parse()
+
+	cfg, err := src.NewConfigFromFile(*path)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Printf("admin api listening on %s, user api on %s", cfg.Server.Addr, cfg.Server.UserAddr)
+	if err := server.ListenAndServe(ctx, cfg); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,25 @@
+#!/bin/bash
+
+set -exo pipefail
+
+DOCKER_POSTGRES_NAME='postgres-for-auth'
+
+POSTGRES_DEFAULT_DATABASE='alex-auth'
+POSTGRES_BUSINESS_USER="alex"
+POSTGRES_BUSINESS_PASSWORD="alex"
+
+docker run -d -p 5432:5432 --name "${DOCKER_POSTGRES_NAME}" \
+    -e POSTGRES_DB="${POSTGRES_DEFAULT_DATABASE}" \
+    -e POSTGRES_USER="${POSTGRES_BUSINESS_USER}" \
+    -e POSTGRES_PASSWORD="${POSTGRES_BUSINESS_PASSWORD}" \
+    postgres:15
+
+sleep 10 # waiting for postgres
+
+DOCKER_POSTGRES_CONTAINER_ID=$(docker ps | grep "${DOCKER_POSTGRES_NAME}" | awk '{print $1}')
+if [ -z "${DOCKER_POSTGRES_CONTAINER_ID}" ]; then
+  echo "postgres is not working"
+  exit 1
+fi
+
+echo "postgres container id: ${DOCKER_POSTGRES_CONTAINER_ID}"
@@ -0,0 +1,115 @@
+package authz
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hanzezhenalex/auth/src/datastore"
+)
+
+/*
+	Scope matching
+
+	Scopes are made of segments separated by ":", e.g. "orders:read".
+	A "*" segment in a granted scope matches exactly one segment, and
+	a trailing "*" matches one or more segments, so "orders:*" grants
+	"orders:read" as well as "orders:items:write", and "*" grants all.
+*/
+
+const (
+	segmentSeparator = ":"
+	wildcard         = "*"
+)
+
+// Decision is the result of an authorization check. For an allowed check,
+// Role and Scope tell which role and which of its scopes granted it.
+type Decision struct {
+	Allowed bool   `json:"allowed"`
+	Role    string `json:"role,omitempty"`
+	Scope   string `json:"scope,omitempty"`
+	Reason  string `json:"reason"`
+}
+
+type Authorizer struct {
+	store datastore.Datastore
+}
+
+func NewAuthorizer(store datastore.Datastore) *Authorizer {
+	return &Authorizer{store: store}
+}
+
+// Can answers whether the user may perform scope
+func (a *Authorizer) Can(ctx context.Context, userID int64, scope string) (Decision, error) {
+	perm, err := a.store.GetUserPermissions(ctx, userID)
+	if err != nil {
+		return Decision{}, err
+	}
+	return Decide(perm, scope), nil
+}
+
+// Decide checks scope against the roles of a resolved permission.
+// An exact grant is preferred to a wildcard one.
+func Decide(perm *datastore.Permission, scope string) Decision {
+	if scope == "" {
+		return Decision{Reason: "empty scope"}
+	}
+
+	var wildcardGrant *Decision
+	for _, role := range perm.Roles {
+		for _, granted := range role.Scopes {
+			if granted == scope {
+				return allowed(role.RoleName, granted)
+			}
+			if wildcardGrant == nil && Match(granted, scope) {
+				decision := allowed(role.RoleName, granted)
+				wildcardGrant = &decision
+			}
+		}
+	}
+
+	if wildcardGrant != nil {
+		return *wildcardGrant
+	}
+	return Decision{Reason: fmt.Sprintf("no role grants scope %q", scope)}
+}
+
+func allowed(role string, scope string) Decision {
+	return Decision{
+		Allowed: true,
+		Role:    role,
+		Scope:   scope,
+		Reason:  fmt.Sprintf("granted by scope %q of role %q", scope, role),
+	}
+}
+
+// Match reports whether the granted scope, which may contain wildcards, covers scope
+func Match(granted string, scope string) bool {
+	if granted == "" || scope == "" {
+		return false
+	}
+	if granted == scope {
+		return true
+	}
+
+	patterns := strings.Split(granted, segmentSeparator)
+	segments := strings.Split(scope, segmentSeparator)
+
+	for i, pattern := range patterns {
+		if i >= len(segments) {
+			return false
+		}
+
+		if pattern == wildcard {
+			if i == len(patterns)-1 {
+				return true
+			}
+			continue
+		}
+
+		if pattern != segments[i] {
+			return false
+		}
+	}
+	return len(patterns) == len(segments)
+}
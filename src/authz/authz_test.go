@@ -0,0 +1,100 @@
+package authz
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hanzezhenalex/auth/src/datastore"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeStore struct {
+	datastore.Datastore
+	perms map[int64]*datastore.Permission
+}
+
+func (store fakeStore) GetUserPermissions(_ context.Context, userID int64) (*datastore.Permission, error) {
+	perm, ok := store.perms[userID]
+	if !ok {
+		return nil, datastore.ErrorUserNotExist
+	}
+	return perm, nil
+}
+
+func TestMatch(t *testing.T) {
+	rq := require.New(t)
+
+	cases := []struct {
+		granted string
+		scope   string
+		match   bool
+	}{
+		{"orders:read", "orders:read", true},
+		{"orders:read", "orders:write", false},
+		{"orders:read", "orders", false},
+		{"orders", "orders:read", false},
+		{"orders:*", "orders:read", true},
+		{"orders:*", "orders:items:write", true},
+		{"orders:*", "orders", false},
+		{"orders:*", "payments:read", false},
+		{"orders:*:read", "orders:items:read", true},
+		{"orders:*:read", "orders:items:write", false},
+		{"orders:*:read", "orders:items:x:read", false},
+		{"*", "orders:read", true},
+		{"*", "orders", true},
+		{"", "orders", false},
+		{"*", "", false},
+	}
+
+	for _, c := range cases {
+		rq.Equal(c.match, Match(c.granted, c.scope), "%s vs %s", c.granted, c.scope)
+	}
+}
+
+func TestAuthorizer_Can(t *testing.T) {
+	rq := require.New(t)
+	ctx := context.Background()
+
+	authorizer := NewAuthorizer(fakeStore{perms: map[int64]*datastore.Permission{
+		1: {
+			Roles: []*datastore.Role{
+				{RoleName: "admin", Scopes: datastore.Scopes{"orders:*"}},
+				{RoleName: "viewer", Scopes: datastore.Scopes{"orders:read", "payments:read"}},
+			},
+		},
+		2: {},
+	}})
+
+	t.Run("exact scope preferred", func(t *testing.T) {
+		decision, err := authorizer.Can(ctx, 1, "orders:read")
+		rq.NoError(err)
+		rq.True(decision.Allowed)
+		rq.Equal("viewer", decision.Role)
+		rq.Equal("orders:read", decision.Scope)
+	})
+
+	t.Run("wildcard scope", func(t *testing.T) {
+		decision, err := authorizer.Can(ctx, 1, "orders:write")
+		rq.NoError(err)
+		rq.True(decision.Allowed)
+		rq.Equal("admin", decision.Role)
+		rq.Equal("orders:*", decision.Scope)
+	})
+
+	t.Run("denied", func(t *testing.T) {
+		decision, err := authorizer.Can(ctx, 1, "payments:write")
+		rq.NoError(err)
+		rq.False(decision.Allowed)
+		rq.NotEmpty(decision.Reason)
+
+		decision, err = authorizer.Can(ctx, 2, "orders:read")
+		rq.NoError(err)
+		rq.False(decision.Allowed)
+	})
+
+	t.Run("non-existed user", func(t *testing.T) {
+		_, err := authorizer.Can(ctx, 3, "orders:read")
+		rq.Equal(datastore.ErrorUserNotExist, err)
+	})
+}
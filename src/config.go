@@ -10,9 +10,38 @@ import (
 const (
 	defaultMaxIdleConns = 5
 	defaultMaxOpenConns = 10
+	defaultSSLMode      = "require"
+
+	defaultPasswordIterations = 600000
+	defaultPasswordSaltLength = 16
+	defaultPasswordKeyLength  = 32
+
+	defaultServerAddr     = ":8080"
+	defaultServerUserAddr = ":8081"
+
+	defaultSessionTTL         = 24 * 60 * 60
+	defaultSessionIdleTimeout = 30 * 60
+
+	defaultTokenTTL        = 15 * 60
+	defaultTokenLeeway     = 60
+	defaultRefreshTokenTTL = 30 * 24 * 60 * 60
+
+	defaultKeyAlgorithm      = "ES256"
+	defaultKeyRotationPeriod = 30 * 24 * 60 * 60
+	defaultKeyGracePeriod    = 24 * 60 * 60
+)
+
+// drivers known by datastore.Open, a driver is available once its package
+// is imported
+const (
+	DriverMysql    = "mysql"
+	DriverPostgres = "postgres"
+	DriverSqlite   = "sqlite"
+	DriverMemory   = "memory"
 )
 
 type DbConfig struct {
+	Driver       string `json:"driver,omitempty"`
 	Username     string `json:"username"`
 	Password     string `json:"password"`
 	Database     string `json:"database"`
@@ -20,12 +49,19 @@ type DbConfig struct {
 	Port         int    `json:"port"`
 	MaxIdleConns int    `json:"max_idle_conns,omitempty"`
 	MaxOpenConns int    `json:"max_open_conns,omitempty"`
+	// SSLMode is the sslmode of a postgres connection, "require" by default
+	SSLMode string `json:"sslmode,omitempty"`
+	// RequireActor makes every mutation fail unless its context carries
+	// the actor, set by datastore.WithActor
+	RequireActor bool `json:"require_actor,omitempty"`
 }
 
 func NewDbConfig() DbConfig {
 	return DbConfig{
+		Driver:       DriverMysql,
 		MaxIdleConns: defaultMaxIdleConns,
 		MaxOpenConns: defaultMaxOpenConns,
+		SSLMode:      defaultSSLMode,
 	}
 }
 
@@ -36,13 +72,108 @@ func (dbCfg DbConfig) Dns() string {
 	return dns
 }
 
+// PasswordConfig holds the cost parameters used when hashing passwords.
+// Raising Iterations makes existing hashes be upgraded on next login. The
+// minimums are those of password.NewHasher, a weaker config is refused.
+type PasswordConfig struct {
+	Iterations int `json:"iterations,omitempty"`
+	SaltLength int `json:"salt_length,omitempty"`
+	KeyLength  int `json:"key_length,omitempty"`
+}
+
+func NewPasswordConfig() PasswordConfig {
+	return PasswordConfig{
+		Iterations: defaultPasswordIterations,
+		SaltLength: defaultPasswordSaltLength,
+		KeyLength:  defaultPasswordKeyLength,
+	}
+}
+
+// ServerConfig holds where the admin API listens, and where the user API
+// does. Only the user API should be reachable by the users.
+type ServerConfig struct {
+	Addr     string `json:"addr,omitempty"`
+	UserAddr string `json:"user_addr,omitempty"`
+}
+
+func NewServerConfig() ServerConfig {
+	return ServerConfig{
+		Addr:     defaultServerAddr,
+		UserAddr: defaultServerUserAddr,
+	}
+}
+
+// SessionConfig bounds the life of a login session, in seconds. A session
+// ends TTL after the login, or once idle for IdleTimeout, whichever first.
+type SessionConfig struct {
+	TTL         int `json:"ttl,omitempty"`
+	IdleTimeout int `json:"idle_timeout,omitempty"`
+}
+
+func NewSessionConfig() SessionConfig {
+	return SessionConfig{
+		TTL:         defaultSessionTTL,
+		IdleTimeout: defaultSessionIdleTimeout,
+	}
+}
+
+// TokenConfig describes the access tokens, times in seconds. Audience is
+// what a token is minted for, and what a verifier accepts. Leeway is the
+// clock skew tolerated between the issuer and the verifiers. RefreshTTL
+// is the lifetime of a refresh token, each use gives a new one.
+type TokenConfig struct {
+	Issuer     string   `json:"issuer,omitempty"`
+	Audience   []string `json:"audience,omitempty"`
+	TTL        int      `json:"ttl,omitempty"`
+	Leeway     int      `json:"leeway,omitempty"`
+	RefreshTTL int      `json:"refresh_ttl,omitempty"`
+}
+
+func NewTokenConfig() TokenConfig {
+	return TokenConfig{
+		TTL:        defaultTokenTTL,
+		Leeway:     defaultTokenLeeway,
+		RefreshTTL: defaultRefreshTokenTTL,
+	}
+}
+
+// KeyConfig describes the keys signing the access tokens, periods in
+// seconds. MasterKey is the base64 of 32 random bytes, the private keys
+// are encrypted with it at rest. A key signs for RotationPeriod, then only
+// verifies for GracePeriod, which must outlive the tokens it signed: it is
+// refused shorter than TokenConfig.TTL plus Leeway.
+type KeyConfig struct {
+	MasterKey      string `json:"master_key,omitempty"`
+	Algorithm      string `json:"algorithm,omitempty"`
+	RotationPeriod int    `json:"rotation_period,omitempty"`
+	GracePeriod    int    `json:"grace_period,omitempty"`
+}
+
+func NewKeyConfig() KeyConfig {
+	return KeyConfig{
+		Algorithm:      defaultKeyAlgorithm,
+		RotationPeriod: defaultKeyRotationPeriod,
+		GracePeriod:    defaultKeyGracePeriod,
+	}
+}
+
 type Config struct {
 	DbConfig
+	PasswordHashing PasswordConfig `json:"password_hashing"`
+	Server          ServerConfig   `json:"server"`
+	Session         SessionConfig  `json:"session"`
+	Token           TokenConfig    `json:"token"`
+	SigningKeys     KeyConfig      `json:"signing_keys"`
 }
 
 func NewConfigFromFile(path string) (Config, error) {
 	var cfg = Config{
-		NewDbConfig(),
+		DbConfig:        NewDbConfig(),
+		PasswordHashing: NewPasswordConfig(),
+		Server:          NewServerConfig(),
+		Session:         NewSessionConfig(),
+		Token:           NewTokenConfig(),
+		SigningKeys:     NewKeyConfig(),
 	}
 	raw, err := ioutil.ReadFile(path)
 	if err != nil {
@@ -0,0 +1,26 @@
+package datastore
+
+import "context"
+
+type actorKey struct{}
+
+// WithActor returns a copy of ctx carrying the id of whoever makes the calls
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor set by WithActor, empty if there is none
+func ActorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// CheckActor returns the actor of ctx. Without one it fails with
+// ErrorActorRequired if required, or returns an empty actor.
+func CheckActor(ctx context.Context, required bool) (string, error) {
+	actor := ActorFromContext(ctx)
+	if actor == "" && required {
+		return "", ErrorActorRequired
+	}
+	return actor, nil
+}
@@ -0,0 +1,10 @@
+// Package all registers every datastore driver, import it for its side
+// effects to let datastore.Open pick the backend from the config.
+package all
+
+import (
+	_ "github.com/hanzezhenalex/auth/src/datastore/memory"
+	_ "github.com/hanzezhenalex/auth/src/datastore/mysql"
+	_ "github.com/hanzezhenalex/auth/src/datastore/postgres"
+	_ "github.com/hanzezhenalex/auth/src/datastore/sqlite"
+)
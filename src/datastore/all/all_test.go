@@ -0,0 +1,86 @@
+package all
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/hanzezhenalex/auth/src"
+	"github.com/hanzezhenalex/auth/src/datastore"
+	"github.com/hanzezhenalex/auth/src/datastore/sqlite"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpen(t *testing.T) {
+	t.Run("drivers", func(t *testing.T) {
+		rq := require.New(t)
+		rq.Equal([]string{
+			src.DriverMemory,
+			src.DriverMysql,
+			src.DriverPostgres,
+			src.DriverSqlite,
+		}, datastore.Drivers())
+	})
+
+	for _, driver := range []string{src.DriverMemory, src.DriverSqlite} {
+		driver := driver
+		t.Run(driver, func(t *testing.T) {
+			rq := require.New(t)
+
+			cfg := src.Config{DbConfig: src.NewDbConfig()}
+			cfg.Driver = driver
+			cfg.Database = sqlite.MemoryDatabase
+
+			store, err := datastore.Open(cfg)
+			rq.NoError(err)
+
+			auth := &datastore.Authority{AuthName: "open"}
+			rq.NoError(store.CreateAuthority(context.Background(), auth))
+			rq.NotZero(auth.ID)
+		})
+	}
+
+	t.Run("unknown driver", func(t *testing.T) {
+		rq := require.New(t)
+
+		cfg := src.Config{DbConfig: src.NewDbConfig()}
+		cfg.Driver = "oracle"
+
+		_, err := datastore.Open(cfg)
+		rq.True(errors.Is(err, datastore.ErrorUnknownDriver))
+	})
+}
+
+func TestRequireActor(t *testing.T) {
+	for _, driver := range []string{src.DriverMemory, src.DriverSqlite} {
+		driver := driver
+		t.Run(driver, func(t *testing.T) {
+			rq := require.New(t)
+
+			cfg := src.Config{DbConfig: src.NewDbConfig()}
+			cfg.Driver = driver
+			cfg.Database = sqlite.MemoryDatabase
+			cfg.RequireActor = true
+
+			store, err := datastore.Open(cfg)
+			rq.NoError(err)
+
+			ctx := context.Background()
+			auth := &datastore.Authority{AuthName: "require_actor"}
+			rq.Equal(datastore.ErrorActorRequired, store.CreateAuthority(ctx, auth))
+			rq.Equal(datastore.ErrorActorRequired, store.CreateUser(ctx, &datastore.User{Username: "require_actor"}))
+			rq.Equal(datastore.ErrorActorRequired, store.CreateSession(ctx, &datastore.Session{UserID: 1}))
+			_, err = store.RevokeUserRefreshTokens(ctx, 1)
+			rq.Equal(datastore.ErrorActorRequired, err)
+			_, err = store.Purge(ctx, 0)
+			rq.Equal(datastore.ErrorActorRequired, err)
+
+			ctx = datastore.WithActor(ctx, "admin")
+			rq.NoError(store.CreateAuthority(ctx, auth))
+			actual, err := store.GetAuthorityByID(context.Background(), auth.ID)
+			rq.NoError(err)
+			rq.Equal("admin", actual.CreatedBy)
+		})
+	}
+}
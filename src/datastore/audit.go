@@ -0,0 +1,156 @@
+package datastore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hanzezhenalex/auth/src"
+)
+
+// Actions recorded in AuditEvent.Action
+const (
+	AuditCreate        = "create"
+	AuditUpdate        = "update"
+	AuditDelete        = "delete"
+	AuditForceDelete   = "force_delete"
+	AuditRestore       = "restore"
+	AuditAssignRoles   = "assign_roles"
+	AuditUnassignRoles = "unassign_roles"
+	AuditUpdateScopes  = "update_scopes"
+	AuditUpdateAuths   = "update_auths"
+	AuditPurge         = "purge"
+	AuditRetire        = "retire"
+	AuditRotate        = "rotate"
+	AuditRevoke        = "revoke"
+)
+
+// Targets recorded in AuditEvent.TargetType
+const (
+	AuditTargetUser         = "user"
+	AuditTargetAuthority    = "authority"
+	AuditTargetRole         = "role"
+	AuditTargetSession      = "session"
+	AuditTargetSigningKey   = "signing_key"
+	AuditTargetRefreshToken = "refresh_token"
+	AuditTargetDatastore    = "datastore"
+)
+
+// redacted stands for a secret in an audit diff
+const redacted = "[redacted]"
+
+// AuditEvent records a mutation, written in the same transaction. Before
+// holds the json of the values removed or replaced, After the ones added,
+// only the fields the mutation touched are there. A mutation changing
+// nothing is not recorded.
+type AuditEvent struct {
+	ID         int64  `xorm:"'id' pk autoincr"`
+	Actor      string `xorm:"'actor' index"`
+	Action     string `xorm:"'action' not null"`
+	TargetType string `xorm:"'target_type' not null"`
+	TargetID   int64  `xorm:"'target_id'"`
+	Before     string `xorm:"'before_value' text"`
+	After      string `xorm:"'after_value' text"`
+	// OccurredAt is in nanoseconds like deleted_at, so the time range of
+	// ListAuditEvents is exact on every database
+	OccurredAt int64 `xorm:"'occurred_at' not null index"`
+}
+
+func (event AuditEvent) TableName() string {
+	return src.WithDebugSuffix("audit_event")
+}
+
+// Time returns when the mutation happened
+func (event AuditEvent) Time() time.Time {
+	return time.Unix(0, event.OccurredAt)
+}
+
+// AuditDiff is one side of an audit event, keyed by field name
+type AuditDiff map[string]interface{}
+
+// NewAuditEvent returns the event of a mutation done by the actor of ctx.
+// Empty diffs are left out.
+func NewAuditEvent(ctx context.Context, action, targetType string, targetID int64, before, after AuditDiff) (*AuditEvent, error) {
+	event := &AuditEvent{
+		Actor:      ActorFromContext(ctx),
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		OccurredAt: time.Now().UnixNano(),
+	}
+
+	var err error
+	if event.Before, err = encodeAuditDiff(before); err != nil {
+		return nil, err
+	}
+	if event.After, err = encodeAuditDiff(after); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+func encodeAuditDiff(diff AuditDiff) (string, error) {
+	if len(diff) == 0 {
+		return "", nil
+	}
+	raw, err := json.Marshal(diff)
+	if err != nil {
+		return "", fmt.Errorf("fail to encode audit diff, %w", err)
+	}
+	return string(raw), nil
+}
+
+// UserAuditDiff returns the fields changed from origin to user, the
+// password only appears redacted
+func UserAuditDiff(origin, user *User) (before AuditDiff, after AuditDiff) {
+	before, after = AuditDiff{}, AuditDiff{}
+	if origin.Username != user.Username {
+		before["user_name"], after["user_name"] = origin.Username, user.Username
+	}
+	if origin.Password != user.Password {
+		before["password"], after["password"] = redacted, redacted
+	}
+	if origin.Reserve != user.Reserve {
+		before["reserve"], after["reserve"] = origin.Reserve, user.Reserve
+	}
+	return before, after
+}
+
+// WithList sets key to the sorted values, unless there is none
+func (diff AuditDiff) WithList(key string, values []string) AuditDiff {
+	if len(values) > 0 {
+		sorted := append([]string(nil), values...)
+		src.SortSliceAsc(sorted)
+		diff[key] = sorted
+	}
+	return diff
+}
+
+// AuditListOption filters and paginates ListAuditEvents. Events are listed
+// in the order they happened, Since is inclusive and Until exclusive, a
+// zero time leaves its end open.
+type AuditListOption struct {
+	PageToken string    `json:"page_token,omitempty"`
+	PageSize  int       `json:"page_size,omitempty"`
+	Actor     string    `json:"actor,omitempty"`
+	Since     time.Time `json:"since,omitempty"`
+	Until     time.Time `json:"until,omitempty"`
+}
+
+// Limit returns the page size, bounded by MaxPageSize
+func (opt AuditListOption) Limit() int {
+	return ListOption{PageSize: opt.PageSize}.Limit()
+}
+
+// After returns the id the page starts after
+func (opt AuditListOption) After() (int64, error) {
+	return ListOption{PageToken: opt.PageToken}.After()
+}
+
+// Match reports whether an event passes the actor and time filters
+func (opt AuditListOption) Match(event *AuditEvent) bool {
+	return (opt.Actor == "" || opt.Actor == event.Actor) &&
+		(opt.Since.IsZero() || event.OccurredAt >= opt.Since.UnixNano()) &&
+		(opt.Until.IsZero() || event.OccurredAt < opt.Until.UnixNano())
+}
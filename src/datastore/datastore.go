@@ -3,17 +3,73 @@ package datastore
 import (
 	"context"
 	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hanzezhenalex/auth/src/password"
 )
 
 type Datastore interface {
+	CreateUser(ctx context.Context, user *User) error
+	DeleteUserByID(ctx context.Context, id int64) error
+	GetUserByID(ctx context.Context, id int64) (*User, error)
+	GetUserByName(ctx context.Context, name string) (*User, error)
+	UpdateUser(ctx context.Context, user *User) error
+
+	AssignRoles(ctx context.Context, userID int64, roles []string) error
+	UnassignRoles(ctx context.Context, userID int64, roles []string) error
+	ListUserRoles(ctx context.Context, userID int64) ([]string, error)
+	GetUserPermissions(ctx context.Context, userID int64) (*Permission, error)
+
 	CreateAuthority(ctx context.Context, auth *Authority) error
 	DeleteAuthorityByID(ctx context.Context, id int64, force bool) error
+	RestoreAuthorityByID(ctx context.Context, id int64) error
 	GetAuthorityByID(ctx context.Context, id int64) (*Authority, error)
+	GetAuthorityByName(ctx context.Context, name string) (*Authority, error)
+	ListAuthorities(ctx context.Context, opt ListOption) ([]*Authority, string, error)
 
 	CreateRole(ctx context.Context, role *Role) error
 	DeleteRoleByID(ctx context.Context, id int64) error
+	RestoreRoleByID(ctx context.Context, id int64) error
 	GetRoleByID(ctx context.Context, id int64) (*Role, error)
+	GetRoleByName(ctx context.Context, name string) (*Role, error)
+	ListRoles(ctx context.Context, opt ListOption) ([]*Role, string, error)
+	ListRolesByAuthority(ctx context.Context, authID int64) ([]*Role, error)
+	ListRolesByScope(ctx context.Context, scope string) ([]*Role, error)
 	UpdateScopesByID(ctx context.Context, id int64, op UpdateRoleScopeOption) error
+	UpdateRoleAuthsByID(ctx context.Context, id int64, op UpdateRoleAuthOption) error
+
+	// CreateSession stores a session of a live user, the caller fills the
+	// token hash and the times. TouchSession only moves last_seen_at, it is
+	// neither checked for the actor nor audited.
+	CreateSession(ctx context.Context, session *Session) error
+	GetSessionByTokenHash(ctx context.Context, hash string) (*Session, error)
+	TouchSession(ctx context.Context, id int64, seenAt time.Time) error
+	RevokeSessionByID(ctx context.Context, id int64) error
+	ListUserSessions(ctx context.Context, userID int64) ([]*Session, error)
+
+	// CreateSigningKey stores a key sealed by the caller, ListSigningKeys
+	// returns the keys not deleted in id order. Retiring a retired key
+	// does nothing.
+	CreateSigningKey(ctx context.Context, key *SigningKey) error
+	ListSigningKeys(ctx context.Context) ([]*SigningKey, error)
+	RetireSigningKey(ctx context.Context, id int64, retiredAt time.Time) error
+	DeleteSigningKeyByID(ctx context.Context, id int64) error
+
+	// CreateRefreshToken stores the first token of a family for a live
+	// user, the caller fills the token hash and the expiry. RotateRefreshToken
+	// marks a token used and stores next in its family at once, a token used
+	// already is ErrorRefreshTokenUsed. The revokes return how many tokens
+	// they revoked.
+	CreateRefreshToken(ctx context.Context, token *RefreshToken) error
+	GetRefreshTokenByTokenHash(ctx context.Context, hash string) (*RefreshToken, error)
+	RotateRefreshToken(ctx context.Context, id int64, usedAt time.Time, next *RefreshToken) error
+	RevokeRefreshTokenFamily(ctx context.Context, familyID int64) (int64, error)
+	RevokeUserRefreshTokens(ctx context.Context, userID int64) (int64, error)
+
+	Purge(ctx context.Context, olderThan time.Duration) (*PurgeReport, error)
+
+	ListAuditEvents(ctx context.Context, opt AuditListOption) ([]*AuditEvent, string, error)
 }
 
 type UpdateRoleScopeOption struct {
@@ -21,7 +77,42 @@ type UpdateRoleScopeOption struct {
 	Unassign []string `json:"unassign,omitempty"`
 }
 
+// UpdateRoleAuthOption binds and unbinds authorities, by name, to a role
+type UpdateRoleAuthOption struct {
+	Assign   []string `json:"assign,omitempty"`
+	Unassign []string `json:"unassign,omitempty"`
+}
+
+// PurgeReport counts, by table, the rows removed by Purge. Rows soft deleted
+// for longer than the retention are removed, with the rows referring to them.
+// Sessions and refresh tokens expired for longer than the retention are
+// removed too.
+type PurgeReport struct {
+	Users            int64 `json:"users"`
+	Authorities      int64 `json:"authorities"`
+	Roles            int64 `json:"roles"`
+	RoleScopes       int64 `json:"role_scopes"`
+	RoleBindings     int64 `json:"role_bindings"`
+	UserRoleBindings int64 `json:"user_role_bindings"`
+	Sessions         int64 `json:"sessions"`
+	SigningKeys      int64 `json:"signing_keys"`
+	RefreshTokens    int64 `json:"refresh_tokens"`
+}
+
+// Permission is the effective permission of a user, merged from all the active roles
+// assigned to the user. Roles keeps where each scope and authority comes from.
+type Permission struct {
+	Scopes Scopes   `json:"scopes"`
+	Auths  []string `json:"auths"`
+	Roles  []*Role  `json:"roles"`
+}
+
 var (
+	ErrorUserExist    = errors.New("user exist")
+	ErrorUserNotExist = errors.New("user not exist")
+
+	ErrorPasswordMismatch = errors.New("password mismatch")
+
 	ErrorAuthExist             = errors.New("authority exist")
 	ErrorAuthNotExist          = errors.New("authority not exist")
 	ErrorDeleteAuthWithBinding = errors.New("delete an auth with bindings")
@@ -29,7 +120,49 @@ var (
 	ErrorRoleExist    = errors.New("role exist")
 	ErrorRoleNotExist = errors.New("role not exist")
 
+	ErrorUnassignNonExistedRoles = errors.New("unassign non-assigned roles")
+
 	//ErrorScopesDuplicatedAssign   = errors.New("try to assign scopes which have been assigned to role")
 
 	ErrorUnassignNonExistedScopes = errors.New("unassign non-existed scopes")
+	ErrorUnassignNonExistedAuths  = errors.New("unassign non-bound authorities")
+
+	ErrorSessionNotExist = errors.New("session not exist")
+
+	ErrorSigningKeyExist    = errors.New("signing key exist")
+	ErrorSigningKeyNotExist = errors.New("signing key not exist")
+
+	ErrorRefreshTokenNotExist = errors.New("refresh token not exist")
+	ErrorRefreshTokenUsed     = errors.New("refresh token used")
+
+	ErrorActorRequired = errors.New("actor required")
 )
+
+// AuthenticateUser verifies the password of the named user. A stored hash
+// weaker than the hasher's configuration is upgraded transparently.
+func AuthenticateUser(
+	ctx context.Context,
+	store Datastore,
+	hasher *password.Hasher,
+	name string,
+	plain string,
+) (*User, error) {
+	user, err := store.GetUserByName(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	ok, rehashed, err := user.VerifyPassword(hasher, plain)
+	if err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, ErrorPasswordMismatch
+	}
+
+	if rehashed {
+		if err := store.UpdateUser(ctx, user); err != nil {
+			return nil, fmt.Errorf("fail to upgrade password hash, %w", err)
+		}
+	}
+	return user, nil
+}
@@ -0,0 +1,104 @@
+package datastoretest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hanzezhenalex/auth/src/datastore"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testActor(t *testing.T, store datastore.Datastore) {
+	ctx := context.Background()
+	as := func(actor string) context.Context {
+		return datastore.WithActor(ctx, actor)
+	}
+
+	t.Run("created by the actor", func(t *testing.T) {
+		rq := require.New(t)
+		auth := &datastore.Authority{AuthName: "test_actor_auth", CreatedBy: "spoofed"}
+		rq.NoError(store.CreateAuthority(as("admin"), auth))
+		role := &datastore.Role{RoleName: "test_actor_role", CreatedBy: "spoofed"}
+		rq.NoError(store.CreateRole(as("admin"), role))
+
+		actualAuth, err := store.GetAuthorityByID(ctx, auth.ID)
+		rq.NoError(err)
+		rq.Equal("admin", actualAuth.CreatedBy)
+		rq.Equal("admin", actualAuth.UpdatedBy)
+		rq.False(actualAuth.UpdatedAt.IsZero())
+
+		actualRole, err := store.GetRoleByID(ctx, role.ID)
+		rq.NoError(err)
+		rq.Equal("admin", actualRole.CreatedBy)
+		rq.Equal("admin", actualRole.UpdatedBy)
+		rq.False(actualRole.UpdatedAt.IsZero())
+	})
+
+	t.Run("created without actor", func(t *testing.T) {
+		rq := require.New(t)
+		role := &datastore.Role{RoleName: "test_actor_role_anonymous", CreatedBy: "caller"}
+		rq.NoError(store.CreateRole(ctx, role))
+
+		actual, err := store.GetRoleByID(ctx, role.ID)
+		rq.NoError(err)
+		rq.Equal("caller", actual.CreatedBy)
+		rq.Equal("caller", actual.UpdatedBy)
+	})
+
+	t.Run("role updated by the actor", func(t *testing.T) {
+		rq := require.New(t)
+		auth := &datastore.Authority{AuthName: "test_actor_role_update_auth"}
+		rq.NoError(store.CreateAuthority(as("admin"), auth))
+		role := &datastore.Role{RoleName: "test_actor_role_update", Scopes: []string{"scope1"}}
+		rq.NoError(store.CreateRole(as("admin"), role))
+		updatedBy := func() string {
+			actual, err := store.GetRoleByID(ctx, role.ID)
+			rq.NoError(err)
+			rq.Equal("admin", actual.CreatedBy)
+			rq.False(actual.UpdatedAt.Before(actual.CreatedAt))
+			return actual.UpdatedBy
+		}
+
+		rq.NoError(store.UpdateScopesByID(as("scoper"), role.ID, datastore.UpdateRoleScopeOption{
+			Assign: []string{"scope2"},
+		}))
+		rq.Equal("scoper", updatedBy())
+
+		// changes nothing, the role is not updated
+		rq.NoError(store.UpdateScopesByID(as("other"), role.ID, datastore.UpdateRoleScopeOption{
+			Assign: []string{"scope2"},
+		}))
+		rq.Equal("scoper", updatedBy())
+
+		rq.NoError(store.UpdateRoleAuthsByID(as("binder"), role.ID, datastore.UpdateRoleAuthOption{
+			Assign: []string{auth.AuthName},
+		}))
+		rq.Equal("binder", updatedBy())
+
+		rq.NoError(store.DeleteRoleByID(as("deleter"), role.ID))
+		rq.NoError(store.RestoreRoleByID(as("restorer"), role.ID))
+		rq.Equal("restorer", updatedBy())
+	})
+
+	t.Run("authority updated by the actor", func(t *testing.T) {
+		rq := require.New(t)
+		auth := &datastore.Authority{AuthName: "test_actor_auth_update"}
+		rq.NoError(store.CreateAuthority(as("admin"), auth))
+
+		rq.NoError(store.DeleteAuthorityByID(as("deleter"), auth.ID, false))
+		auths, _, err := store.ListAuthorities(ctx, datastore.ListOption{
+			NamePrefix:     auth.AuthName,
+			IncludeDeleted: true,
+		})
+		rq.NoError(err)
+		rq.Len(auths, 1)
+		rq.Equal("deleter", auths[0].UpdatedBy)
+
+		rq.NoError(store.RestoreAuthorityByID(as("restorer"), auth.ID))
+		actual, err := store.GetAuthorityByID(ctx, auth.ID)
+		rq.NoError(err)
+		rq.Equal("admin", actual.CreatedBy)
+		rq.Equal("restorer", actual.UpdatedBy)
+	})
+}
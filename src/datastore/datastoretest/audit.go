@@ -0,0 +1,173 @@
+package datastoretest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hanzezhenalex/auth/src/datastore"
+
+	"github.com/stretchr/testify/require"
+)
+
+// auditRecord is the part of an event the tests compare
+type auditRecord struct {
+	Actor      string
+	Action     string
+	TargetType string
+	TargetID   int64
+	Before     string
+	After      string
+}
+
+func auditRecordsOf(events []*datastore.AuditEvent) []auditRecord {
+	records := make([]auditRecord, 0, len(events))
+	for _, event := range events {
+		records = append(records, auditRecord{
+			Actor:      event.Actor,
+			Action:     event.Action,
+			TargetType: event.TargetType,
+			TargetID:   event.TargetID,
+			Before:     event.Before,
+			After:      event.After,
+		})
+	}
+	return records
+}
+
+func testAudit(t *testing.T, store datastore.Datastore) {
+	rq := require.New(t)
+	const (
+		admin    = "test_audit_admin"
+		operator = "test_audit_operator"
+	)
+
+	// admin sets up the permissions
+	ctx := datastore.WithActor(context.Background(), admin)
+	since := time.Now()
+
+	auth := &datastore.Authority{AuthName: "test_audit_auth"}
+	rq.NoError(store.CreateAuthority(ctx, auth))
+	role := &datastore.Role{
+		RoleName: "test_audit_role",
+		Scopes:   []string{"scope2", "scope1"},
+		Auths:    []string{auth.AuthName},
+	}
+	rq.NoError(store.CreateRole(ctx, role))
+	rq.NoError(store.UpdateScopesByID(ctx, role.ID, datastore.UpdateRoleScopeOption{
+		Assign:   []string{"scope3"},
+		Unassign: []string{"scope1"},
+	}))
+	// changes nothing, not recorded
+	rq.NoError(store.UpdateScopesByID(ctx, role.ID, datastore.UpdateRoleScopeOption{Assign: []string{"scope2"}}))
+	// fails, not recorded
+	rq.Equal(datastore.ErrorAuthExist, store.CreateAuthority(ctx, &datastore.Authority{AuthName: auth.AuthName}))
+	rq.NoError(store.DeleteAuthorityByID(ctx, auth.ID, true))
+
+	// operator manages the users
+	middle := time.Now()
+	ctx = datastore.WithActor(context.Background(), operator)
+
+	user := &datastore.User{Username: "test_audit_user", Password: "password"}
+	rq.NoError(store.CreateUser(ctx, user))
+	rq.NoError(store.AssignRoles(ctx, user.ID, []string{role.RoleName}))
+	user.Password = "changed"
+	rq.NoError(store.UpdateUser(ctx, user))
+	rq.NoError(store.DeleteUserByID(ctx, user.ID))
+	until := time.Now()
+
+	adminRecords := []auditRecord{
+		{admin, datastore.AuditCreate, datastore.AuditTargetAuthority, auth.ID,
+			``, `{"authority_name":"test_audit_auth"}`},
+		{admin, datastore.AuditCreate, datastore.AuditTargetRole, role.ID,
+			``, `{"auths":["test_audit_auth"],"role_name":"test_audit_role","scopes":["scope1","scope2"]}`},
+		{admin, datastore.AuditUpdateScopes, datastore.AuditTargetRole, role.ID,
+			`{"scopes":["scope1"]}`, `{"scopes":["scope3"]}`},
+		{admin, datastore.AuditForceDelete, datastore.AuditTargetAuthority, auth.ID,
+			`{"authority_name":"test_audit_auth"}`, ``},
+	}
+	operatorRecords := []auditRecord{
+		{operator, datastore.AuditCreate, datastore.AuditTargetUser, user.ID,
+			``, `{"user_name":"test_audit_user"}`},
+		{operator, datastore.AuditAssignRoles, datastore.AuditTargetUser, user.ID,
+			``, `{"roles":["test_audit_role"]}`},
+		{operator, datastore.AuditUpdate, datastore.AuditTargetUser, user.ID,
+			`{"password":"[redacted]"}`, `{"password":"[redacted]"}`},
+		{operator, datastore.AuditDelete, datastore.AuditTargetUser, user.ID,
+			`{"user_name":"test_audit_user"}`, ``},
+	}
+
+	t.Run("list all", func(t *testing.T) {
+		rq := require.New(t)
+		events, next, err := store.ListAuditEvents(ctx, datastore.AuditListOption{})
+		rq.NoError(err)
+		rq.Empty(next)
+		rq.Equal(append(append([]auditRecord(nil), adminRecords...), operatorRecords...), auditRecordsOf(events))
+
+		for i := 1; i < len(events); i++ {
+			rq.Greater(events[i].ID, events[i-1].ID)
+			rq.GreaterOrEqual(events[i].OccurredAt, events[i-1].OccurredAt)
+		}
+		rq.False(events[0].Time().Before(since))
+		rq.True(events[len(events)-1].Time().Before(until))
+	})
+
+	t.Run("filter by actor", func(t *testing.T) {
+		rq := require.New(t)
+		events, _, err := store.ListAuditEvents(ctx, datastore.AuditListOption{Actor: operator})
+		rq.NoError(err)
+		rq.Equal(operatorRecords, auditRecordsOf(events))
+
+		events, _, err = store.ListAuditEvents(ctx, datastore.AuditListOption{Actor: "test_audit_nobody"})
+		rq.NoError(err)
+		rq.Empty(events)
+	})
+
+	t.Run("filter by time range", func(t *testing.T) {
+		rq := require.New(t)
+		events, _, err := store.ListAuditEvents(ctx, datastore.AuditListOption{Since: since, Until: middle})
+		rq.NoError(err)
+		rq.Equal(adminRecords, auditRecordsOf(events))
+
+		events, _, err = store.ListAuditEvents(ctx, datastore.AuditListOption{Since: middle})
+		rq.NoError(err)
+		rq.Equal(operatorRecords, auditRecordsOf(events))
+
+		events, _, err = store.ListAuditEvents(ctx, datastore.AuditListOption{Since: until})
+		rq.NoError(err)
+		rq.Empty(events)
+	})
+
+	t.Run("paginate", func(t *testing.T) {
+		rq := require.New(t)
+		opt := datastore.AuditListOption{Actor: admin, PageSize: 3}
+
+		events, next, err := store.ListAuditEvents(ctx, opt)
+		rq.NoError(err)
+		rq.Equal(adminRecords[:3], auditRecordsOf(events))
+		rq.NotEmpty(next)
+
+		opt.PageToken = next
+		events, next, err = store.ListAuditEvents(ctx, opt)
+		rq.NoError(err)
+		rq.Equal(adminRecords[3:], auditRecordsOf(events))
+		rq.Empty(next)
+
+		opt.PageToken = "!"
+		_, _, err = store.ListAuditEvents(ctx, opt)
+		rq.Equal(datastore.ErrorInvalidPageToken, err)
+	})
+
+	t.Run("anonymous actor", func(t *testing.T) {
+		rq := require.New(t)
+		role := &datastore.Role{RoleName: "test_audit_role_anonymous"}
+		rq.NoError(store.CreateRole(context.Background(), role))
+
+		events, _, err := store.ListAuditEvents(ctx, datastore.AuditListOption{Since: until})
+		rq.NoError(err)
+		rq.Equal([]auditRecord{
+			{"", datastore.AuditCreate, datastore.AuditTargetRole, role.ID,
+				``, `{"role_name":"test_audit_role_anonymous"}`},
+		}, auditRecordsOf(events))
+	})
+}
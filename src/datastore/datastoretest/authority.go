@@ -0,0 +1,200 @@
+package datastoretest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hanzezhenalex/auth/src/datastore"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testAuthority(t *testing.T, store datastore.Datastore) {
+	ctx := context.Background()
+
+	t.Run("create", func(t *testing.T) {
+		rq := require.New(t)
+		const name = "test_1"
+
+		rq.NoError(store.CreateAuthority(ctx, &datastore.Authority{AuthName: name}))
+	})
+
+	t.Run("create duplicated authority, should fail", func(t *testing.T) {
+		rq := require.New(t)
+		const name = "test_2"
+
+		rq.NoError(store.CreateAuthority(ctx, &datastore.Authority{AuthName: name}))
+		rq.Equal(datastore.ErrorAuthExist, store.CreateAuthority(ctx, &datastore.Authority{AuthName: name}))
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		rq := require.New(t)
+		const name = "test_3"
+		auth := &datastore.Authority{AuthName: name}
+
+		rq.NoError(store.CreateAuthority(ctx, auth))
+		rq.NoError(store.DeleteAuthorityByID(ctx, auth.ID, false))
+	})
+
+	t.Run("delete a non-existed one, should fail", func(t *testing.T) {
+		rq := require.New(t)
+		rq.Equal(datastore.ErrorAuthNotExist, store.DeleteAuthorityByID(ctx, nonExistedID, false))
+	})
+
+	t.Run("read", func(t *testing.T) {
+		rq := require.New(t)
+		const name = "test_5"
+		expected := &datastore.Authority{AuthName: name}
+
+		rq.NoError(store.CreateAuthority(ctx, expected))
+
+		actual, err := store.GetAuthorityByID(ctx, expected.ID)
+
+		rq.NoError(err)
+		rq.Equal(expected.ID, actual.ID)
+		rq.Equal(expected.AuthName, actual.AuthName)
+		rq.Equal(expected.CreatedAt.Unix(), actual.CreatedAt.Unix())
+	})
+
+	t.Run("read a non-existed one, should fail", func(t *testing.T) {
+		rq := require.New(t)
+		actual, err := store.GetAuthorityByID(ctx, nonExistedID)
+
+		rq.Nil(actual)
+		rq.Equal(datastore.ErrorAuthNotExist, err)
+	})
+
+	t.Run("read by name", func(t *testing.T) {
+		rq := require.New(t)
+		const name = "test_auth_read_by_name"
+		expected := &datastore.Authority{AuthName: name}
+
+		rq.NoError(store.CreateAuthority(ctx, expected))
+
+		actual, err := store.GetAuthorityByName(ctx, name)
+		rq.NoError(err)
+		rq.Equal(expected.ID, actual.ID)
+	})
+
+	t.Run("read by name, non-existed or deleted one should fail", func(t *testing.T) {
+		rq := require.New(t)
+		const name = "test_auth_read_by_name_deleted"
+		auth := &datastore.Authority{AuthName: name}
+
+		rq.NoError(store.CreateAuthority(ctx, auth))
+		rq.NoError(store.DeleteAuthorityByID(ctx, auth.ID, false))
+
+		actual, err := store.GetAuthorityByName(ctx, name)
+		rq.Nil(actual)
+		rq.Equal(datastore.ErrorAuthNotExist, err)
+
+		_, err = store.GetAuthorityByName(ctx, "test_auth_read_by_name_non_existed")
+		rq.Equal(datastore.ErrorAuthNotExist, err)
+	})
+
+	t.Run("read a deleted one", func(t *testing.T) {
+		rq := require.New(t)
+		const name = "test_auth_read_a_deleted_one"
+		expected := &datastore.Authority{AuthName: name}
+
+		rq.NoError(store.CreateAuthority(ctx, expected))
+		rq.NoError(store.DeleteAuthorityByID(ctx, expected.ID, false))
+
+		actual, err := store.GetAuthorityByID(ctx, expected.ID)
+
+		rq.Nil(actual)
+		rq.Equal(datastore.ErrorAuthNotExist, err)
+	})
+
+	t.Run("create and delete multiple times", func(t *testing.T) {
+		rq := require.New(t)
+		const name = "test_4"
+		auth := &datastore.Authority{AuthName: name}
+
+		// create and delete first time
+		rq.NoError(store.CreateAuthority(ctx, auth))
+		rq.NoError(store.DeleteAuthorityByID(ctx, auth.ID, false))
+
+		// clean up
+		auth.ID = 0
+		auth.DeletedAt = 0
+
+		// create and delete second time
+		rq.NoError(store.CreateAuthority(ctx, auth))
+		rq.NoError(store.DeleteAuthorityByID(ctx, auth.ID, false))
+	})
+
+	t.Run("delete an auth with role binding", func(t *testing.T) {
+		rq := require.New(t)
+		const authName = "test_auth_delete_an_auth_with_role_binding"
+		expected := &datastore.Authority{AuthName: authName}
+
+		rq.NoError(store.CreateAuthority(ctx, expected))
+
+		role := datastore.Role{
+			RoleName: "test_auth_delete_an_auth_with_role_binding_role",
+			Scopes:   []string{"scope1", "scope2"},
+			Auths:    []string{authName},
+		}
+		rq.NoError(store.CreateRole(ctx, &role))
+
+		rq.Equal(
+			datastore.ErrorDeleteAuthWithBinding,
+			store.DeleteAuthorityByID(ctx, expected.ID, false),
+		)
+
+		rq.NoError(store.DeleteAuthorityByID(ctx, expected.ID, true))
+	})
+
+	t.Run("delete twice, should fail", func(t *testing.T) {
+		rq := require.New(t)
+		auth := &datastore.Authority{AuthName: "test_auth_delete_twice"}
+
+		rq.NoError(store.CreateAuthority(ctx, auth))
+		rq.NoError(store.DeleteAuthorityByID(ctx, auth.ID, false))
+		rq.Equal(datastore.ErrorAuthNotExist, store.DeleteAuthorityByID(ctx, auth.ID, false))
+		rq.Equal(datastore.ErrorAuthNotExist, store.DeleteAuthorityByID(ctx, auth.ID, true))
+	})
+
+	t.Run("delete an auth whose role is deleted", func(t *testing.T) {
+		rq := require.New(t)
+		const authName = "test_auth_delete_an_auth_whose_role_is_deleted"
+		auth := &datastore.Authority{AuthName: authName}
+		rq.NoError(store.CreateAuthority(ctx, auth))
+
+		role := datastore.Role{
+			RoleName: "test_auth_delete_an_auth_whose_role_is_deleted_role",
+			Scopes:   []string{"scope1"},
+			Auths:    []string{authName},
+		}
+		rq.NoError(store.CreateRole(ctx, &role))
+		rq.NoError(store.DeleteRoleByID(ctx, role.ID))
+
+		rq.NoError(store.DeleteAuthorityByID(ctx, auth.ID, false))
+	})
+
+	t.Run("recreate a force deleted auth with role binding", func(t *testing.T) {
+		rq := require.New(t)
+		const authName = "test_auth_recreate_a_force_deleted_auth"
+		auth := &datastore.Authority{AuthName: authName}
+		rq.NoError(store.CreateAuthority(ctx, auth))
+
+		role := datastore.Role{
+			RoleName: "test_auth_recreate_a_force_deleted_auth_role",
+			Scopes:   []string{"scope1"},
+			Auths:    []string{authName},
+		}
+		rq.NoError(store.CreateRole(ctx, &role))
+		rq.NoError(store.DeleteAuthorityByID(ctx, auth.ID, true))
+
+		recreated := &datastore.Authority{AuthName: authName}
+		rq.NoError(store.CreateAuthority(ctx, recreated))
+		rq.NotEqual(auth.ID, recreated.ID)
+
+		// the binding belongs to the deleted one
+		actual, err := store.GetRoleByID(ctx, role.ID)
+		rq.NoError(err)
+		rq.Equal(0, len(actual.Auths))
+		rq.NoError(store.DeleteAuthorityByID(ctx, recreated.ID, false))
+	})
+}
@@ -0,0 +1,116 @@
+package datastoretest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hanzezhenalex/auth/src/datastore"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testList(t *testing.T, store datastore.Datastore) {
+	rq := require.New(t)
+	ctx := context.Background()
+
+	const prefix = "test_list_"
+
+	var authIDs []int64
+	for i, name := range []string{"a_1", "a_2", "a_3", "a_4", "a_5"} {
+		auth := &datastore.Authority{AuthName: prefix + name, CreatedBy: "alice"}
+		if i%2 == 1 {
+			auth.CreatedBy = "bob"
+		}
+		rq.NoError(store.CreateAuthority(ctx, auth))
+		authIDs = append(authIDs, auth.ID)
+	}
+	rq.NoError(store.DeleteAuthorityByID(ctx, authIDs[4], false))
+
+	// "_" in prefix should not match any character
+	rq.NoError(store.CreateAuthority(ctx, &datastore.Authority{AuthName: "test_listXa_6"}))
+
+	t.Run("list authorities by pages", func(t *testing.T) {
+		rq := require.New(t)
+		opt := datastore.ListOption{NamePrefix: prefix, PageSize: 3}
+
+		auths, next, err := store.ListAuthorities(ctx, opt)
+		rq.NoError(err)
+		rq.Equal(3, len(auths))
+		rq.NotEmpty(next)
+		rq.Equal(authIDs[0], auths[0].ID)
+
+		opt.PageToken = next
+		auths, next, err = store.ListAuthorities(ctx, opt)
+		rq.NoError(err)
+		rq.Equal(1, len(auths))
+		rq.Empty(next)
+		rq.Equal(authIDs[3], auths[0].ID)
+	})
+
+	t.Run("list authorities with filters", func(t *testing.T) {
+		rq := require.New(t)
+		auths, _, err := store.ListAuthorities(ctx, datastore.ListOption{NamePrefix: prefix, CreatedBy: "bob"})
+		rq.NoError(err)
+		rq.Equal(2, len(auths))
+
+		auths, _, err = store.ListAuthorities(ctx, datastore.ListOption{NamePrefix: prefix, IncludeDeleted: true})
+		rq.NoError(err)
+		rq.Equal(5, len(auths))
+		rq.NotEqual(int64(0), auths[4].DeletedAt)
+	})
+
+	t.Run("list with invalid page token", func(t *testing.T) {
+		rq := require.New(t)
+		_, _, err := store.ListAuthorities(ctx, datastore.ListOption{PageToken: "!!!"})
+		rq.Equal(datastore.ErrorInvalidPageToken, err)
+	})
+
+	t.Run("list roles", func(t *testing.T) {
+		rq := require.New(t)
+		role1 := &datastore.Role{
+			RoleName: prefix + "role_1",
+			Scopes:   []string{"scope1"},
+			Auths:    []string{prefix + "a_1", prefix + "a_2"},
+		}
+		rq.NoError(store.CreateRole(ctx, role1))
+		role2 := &datastore.Role{RoleName: prefix + "role_2", Scopes: []string{"scope2"}}
+		rq.NoError(store.CreateRole(ctx, role2))
+
+		roles, next, err := store.ListRoles(ctx, datastore.ListOption{NamePrefix: prefix, PageSize: 1})
+		rq.NoError(err)
+		rq.Equal(1, len(roles))
+		rq.Equal(role1.ID, roles[0].ID)
+		rq.EqualValues([]string{prefix + "a_1", prefix + "a_2"}, roles[0].Auths)
+		rq.EqualValues(datastore.Scopes{"scope1"}, roles[0].Scopes)
+
+		roles, next, err = store.ListRoles(ctx, datastore.ListOption{NamePrefix: prefix, PageToken: next})
+		rq.NoError(err)
+		rq.Equal(1, len(roles))
+		rq.Equal(role2.ID, roles[0].ID)
+		rq.Empty(next)
+	})
+
+	t.Run("list roles with filters", func(t *testing.T) {
+		rq := require.New(t)
+		role := &datastore.Role{RoleName: prefix + "role_3", Scopes: []string{"scope3"}, CreatedBy: "bob"}
+		rq.NoError(store.CreateRole(ctx, role))
+		rq.NoError(store.DeleteRoleByID(ctx, role.ID))
+
+		roles, _, err := store.ListRoles(ctx, datastore.ListOption{NamePrefix: prefix, CreatedBy: "bob"})
+		rq.NoError(err)
+		rq.Equal(0, len(roles))
+
+		roles, _, err = store.ListRoles(ctx, datastore.ListOption{
+			NamePrefix:     prefix,
+			CreatedBy:      "bob",
+			IncludeDeleted: true,
+		})
+		rq.NoError(err)
+		rq.Equal(1, len(roles))
+		rq.Equal(role.ID, roles[0].ID)
+		rq.NotEqual(int64(0), roles[0].DeletedAt)
+
+		_, _, err = store.ListRoles(ctx, datastore.ListOption{PageToken: "!!!"})
+		rq.Equal(datastore.ErrorInvalidPageToken, err)
+	})
+}
@@ -0,0 +1,106 @@
+package datastoretest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hanzezhenalex/auth/src/datastore"
+
+	"github.com/stretchr/testify/require"
+)
+
+func roleNamesOf(roles []*datastore.Role) []string {
+	var names []string
+	for _, role := range roles {
+		names = append(names, role.RoleName)
+	}
+	return names
+}
+
+func testRoleLookup(t *testing.T, store datastore.Datastore) {
+	rq := require.New(t)
+	ctx := context.Background()
+
+	auth1 := &datastore.Authority{AuthName: "test_lookup_auth_1"}
+	rq.NoError(store.CreateAuthority(ctx, auth1))
+	auth2 := &datastore.Authority{AuthName: "test_lookup_auth_2"}
+	rq.NoError(store.CreateAuthority(ctx, auth2))
+
+	newRole := func(name string, scopes []string, auths ...string) *datastore.Role {
+		role := &datastore.Role{RoleName: name, Scopes: scopes, Auths: auths}
+		rq.NoError(store.CreateRole(ctx, role))
+		return role
+	}
+
+	t.Run("by authority", func(t *testing.T) {
+		rq := require.New(t)
+		role1 := newRole("test_lookup_auth_role_1", []string{"scope1"}, auth1.AuthName)
+		role2 := newRole("test_lookup_auth_role_2", []string{"scope2"}, auth1.AuthName, auth2.AuthName)
+		newRole("test_lookup_auth_role_3", []string{"scope3"}, auth2.AuthName)
+
+		roles, err := store.ListRolesByAuthority(ctx, auth1.ID)
+		rq.NoError(err)
+		rq.Equal([]string{role1.RoleName, role2.RoleName}, roleNamesOf(roles))
+		rq.EqualValues([]string{"scope2"}, roles[1].Scopes)
+		rq.Equal([]string{auth1.AuthName, auth2.AuthName}, roles[1].Auths)
+
+		// unbound
+		rq.NoError(store.UpdateRoleAuthsByID(ctx, role2.ID, datastore.UpdateRoleAuthOption{
+			Unassign: []string{auth1.AuthName},
+		}))
+		roles, err = store.ListRolesByAuthority(ctx, auth1.ID)
+		rq.NoError(err)
+		rq.Equal([]string{role1.RoleName}, roleNamesOf(roles))
+
+		// deleted role
+		rq.NoError(store.DeleteRoleByID(ctx, role1.ID))
+		roles, err = store.ListRolesByAuthority(ctx, auth1.ID)
+		rq.NoError(err)
+		rq.Empty(roles)
+	})
+
+	t.Run("by non-existed authority", func(t *testing.T) {
+		rq := require.New(t)
+		_, err := store.ListRolesByAuthority(ctx, nonExistedID)
+		rq.Equal(datastore.ErrorAuthNotExist, err)
+	})
+
+	t.Run("by deleted authority", func(t *testing.T) {
+		rq := require.New(t)
+		auth := &datastore.Authority{AuthName: "test_lookup_auth_deleted"}
+		rq.NoError(store.CreateAuthority(ctx, auth))
+		newRole("test_lookup_auth_deleted_role", nil, auth.AuthName)
+
+		rq.NoError(store.DeleteAuthorityByID(ctx, auth.ID, true))
+		_, err := store.ListRolesByAuthority(ctx, auth.ID)
+		rq.Equal(datastore.ErrorAuthNotExist, err)
+	})
+
+	t.Run("by scope", func(t *testing.T) {
+		rq := require.New(t)
+		role1 := newRole("test_lookup_scope_role_1", []string{"lookup:read", "lookup:write"})
+		role2 := newRole("test_lookup_scope_role_2", []string{"lookup:read"})
+		role3 := newRole("test_lookup_scope_role_3", []string{"lookup:read"})
+		newRole("test_lookup_scope_role_4", []string{"lookup:*"})
+
+		roles, err := store.ListRolesByScope(ctx, "lookup:read")
+		rq.NoError(err)
+		rq.Equal([]string{role1.RoleName, role2.RoleName, role3.RoleName}, roleNamesOf(roles))
+		rq.EqualValues([]string{"lookup:read", "lookup:write"}, roles[0].Scopes)
+
+		// unassigned
+		rq.NoError(store.UpdateScopesByID(ctx, role2.ID, datastore.UpdateRoleScopeOption{
+			Unassign: []string{"lookup:read"},
+		}))
+		// deleted role
+		rq.NoError(store.DeleteRoleByID(ctx, role3.ID))
+
+		roles, err = store.ListRolesByScope(ctx, "lookup:read")
+		rq.NoError(err)
+		rq.Equal([]string{role1.RoleName}, roleNamesOf(roles))
+
+		roles, err = store.ListRolesByScope(ctx, "lookup:none")
+		rq.NoError(err)
+		rq.Empty(roles)
+	})
+}
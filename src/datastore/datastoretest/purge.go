@@ -0,0 +1,150 @@
+package datastoretest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hanzezhenalex/auth/src/datastore"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testPurge(t *testing.T, store datastore.Datastore) {
+	rq := require.New(t)
+	ctx := context.Background()
+
+	auth1 := &datastore.Authority{AuthName: "test_purge_auth_1"}
+	rq.NoError(store.CreateAuthority(ctx, auth1))
+	auth2 := &datastore.Authority{AuthName: "test_purge_auth_2"}
+	rq.NoError(store.CreateAuthority(ctx, auth2))
+
+	role1 := &datastore.Role{
+		RoleName: "test_purge_role_1",
+		Scopes:   []string{"scope1", "scope2"},
+		Auths:    []string{auth1.AuthName, auth2.AuthName},
+	}
+	rq.NoError(store.CreateRole(ctx, role1))
+	role2 := &datastore.Role{
+		RoleName: "test_purge_role_2",
+		Scopes:   []string{"scope3"},
+		Auths:    []string{auth1.AuthName},
+	}
+	rq.NoError(store.CreateRole(ctx, role2))
+
+	user1 := &datastore.User{Username: "test_purge_user_1", Password: "password"}
+	rq.NoError(store.CreateUser(ctx, user1))
+	user2 := &datastore.User{Username: "test_purge_user_2", Password: "password"}
+	rq.NoError(store.CreateUser(ctx, user2))
+	rq.NoError(store.AssignRoles(ctx, user1.ID, []string{role1.RoleName, role2.RoleName}))
+	rq.NoError(store.AssignRoles(ctx, user2.ID, []string{role1.RoleName}))
+
+	now := time.Now()
+	live := &datastore.Session{UserID: user1.ID, TokenHash: "test_purge_live",
+		ExpiresAt: now.Add(time.Hour).UnixNano(), LastSeenAt: now.UnixNano()}
+	rq.NoError(store.CreateSession(ctx, live))
+	// 1 session, expired but kept until past retention
+	rq.NoError(store.CreateSession(ctx, &datastore.Session{UserID: user1.ID, TokenHash: "test_purge_expired",
+		ExpiresAt: now.Add(-time.Minute).UnixNano(), LastSeenAt: now.UnixNano()}))
+	// 1 session, revoked along with user2
+	rq.NoError(store.CreateSession(ctx, &datastore.Session{UserID: user2.ID, TokenHash: "test_purge_revoked",
+		ExpiresAt: now.Add(time.Hour).UnixNano(), LastSeenAt: now.UnixNano()}))
+
+	liveRefresh := &datastore.RefreshToken{UserID: user1.ID, TokenHash: "test_purge_refresh_live",
+		ExpiresAt: now.Add(time.Hour).UnixNano()}
+	rq.NoError(store.CreateRefreshToken(ctx, liveRefresh))
+	// 1 refresh token, expired but kept until past retention
+	rq.NoError(store.CreateRefreshToken(ctx, &datastore.RefreshToken{UserID: user1.ID,
+		TokenHash: "test_purge_refresh_expired", ExpiresAt: now.Add(-time.Minute).UnixNano()}))
+	// 1 refresh token, revoked along with user2
+	rq.NoError(store.CreateRefreshToken(ctx, &datastore.RefreshToken{UserID: user2.ID,
+		TokenHash: "test_purge_refresh_revoked", ExpiresAt: now.Add(time.Hour).UnixNano()}))
+
+	// 1 signing key
+	key := &datastore.SigningKey{KeyID: "test_purge_key", Algorithm: "ES256", PrivateKey: "sealed"}
+	rq.NoError(store.CreateSigningKey(ctx, key))
+	rq.NoError(store.DeleteSigningKeyByID(ctx, key.ID))
+
+	// 1 scope row
+	rq.NoError(store.UpdateScopesByID(ctx, role1.ID, datastore.UpdateRoleScopeOption{
+		Unassign: []string{"scope2"},
+	}))
+	// 1 role, 1 scope row, 1 role binding, 1 user binding
+	rq.NoError(store.DeleteRoleByID(ctx, role2.ID))
+	// 1 user, 1 user binding
+	rq.NoError(store.DeleteUserByID(ctx, user2.ID))
+	// 1 authority, and the binding of role1 to it
+	rq.NoError(store.DeleteAuthorityByID(ctx, auth2.ID, true))
+
+	t.Run("within retention", func(t *testing.T) {
+		rq := require.New(t)
+		report, err := store.Purge(ctx, time.Hour)
+		rq.NoError(err)
+		rq.Equal(datastore.PurgeReport{}, *report)
+
+		rq.NoError(store.RestoreRoleByID(ctx, role2.ID))
+		rq.NoError(store.DeleteRoleByID(ctx, role2.ID))
+	})
+
+	t.Run("past retention", func(t *testing.T) {
+		rq := require.New(t)
+		since := time.Now()
+		report, err := store.Purge(ctx, 0)
+		rq.NoError(err)
+		rq.Equal(datastore.PurgeReport{
+			Users:            1,
+			Authorities:      1,
+			Roles:            1,
+			RoleScopes:       2,
+			RoleBindings:     2,
+			UserRoleBindings: 2,
+			Sessions:         2,
+			SigningKeys:      1,
+			RefreshTokens:    2,
+		}, *report)
+
+		// the live rows are untouched
+		role, err := store.GetRoleByID(ctx, role1.ID)
+		rq.NoError(err)
+		rq.EqualValues([]string{"scope1"}, role.Scopes)
+		rq.Equal([]string{auth1.AuthName}, role.Auths)
+
+		roles, err := store.ListUserRoles(ctx, user1.ID)
+		rq.NoError(err)
+		rq.Equal([]string{role1.RoleName}, roles)
+
+		sessions, err := store.ListUserSessions(ctx, user1.ID)
+		rq.NoError(err)
+		rq.Len(sessions, 1)
+		rq.Equal(live.ID, sessions[0].ID)
+
+		refresh, err := store.GetRefreshTokenByTokenHash(ctx, liveRefresh.TokenHash)
+		rq.NoError(err)
+		rq.Equal(liveRefresh.ID, refresh.ID)
+
+		// the purged ones are gone for good
+		rq.Equal(datastore.ErrorRoleNotExist, store.RestoreRoleByID(ctx, role2.ID))
+		rq.Equal(datastore.ErrorAuthNotExist, store.RestoreAuthorityByID(ctx, auth2.ID))
+
+		roles2, _, err := store.ListRoles(ctx, datastore.ListOption{NamePrefix: "test_purge_", IncludeDeleted: true})
+		rq.NoError(err)
+		rq.Equal([]string{role1.RoleName}, roleNamesOf(roles2))
+
+		// nothing left to purge
+		report, err = store.Purge(ctx, 0)
+		rq.NoError(err)
+		rq.Equal(datastore.PurgeReport{}, *report)
+
+		// recorded once, along with the counts
+		events, _, err := store.ListAuditEvents(ctx, datastore.AuditListOption{Since: since})
+		rq.NoError(err)
+		rq.Len(events, 1)
+		rq.Equal(datastore.AuditPurge, events[0].Action)
+		rq.Equal(datastore.AuditTargetDatastore, events[0].TargetType)
+		rq.JSONEq(`{"purged":{
+			"users":1, "authorities":1, "roles":1,
+			"role_scopes":2, "role_bindings":2, "user_role_bindings":2, "sessions":2,
+			"signing_keys":1, "refresh_tokens":2
+		}}`, events[0].After)
+	})
+}
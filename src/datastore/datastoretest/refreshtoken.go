@@ -0,0 +1,190 @@
+package datastoretest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hanzezhenalex/auth/src/datastore"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testRefreshToken(t *testing.T, store datastore.Datastore) {
+	ctx := context.Background()
+	now := time.Now()
+	newToken := func(userID int64, hash string) *datastore.RefreshToken {
+		return &datastore.RefreshToken{
+			UserID:    userID,
+			TokenHash: hash,
+			ExpiresAt: now.Add(time.Hour).UnixNano(),
+		}
+	}
+
+	user := &datastore.User{Username: "test_refresh_user", Password: "pwd"}
+	require.NoError(t, store.CreateUser(ctx, user))
+
+	t.Run("create and read", func(t *testing.T) {
+		rq := require.New(t)
+		expected := newToken(user.ID, "test_refresh_read")
+		rq.NoError(store.CreateRefreshToken(ctx, expected))
+		rq.NotZero(expected.ID)
+		rq.Equal(expected.ID, expected.FamilyID)
+
+		actual, err := store.GetRefreshTokenByTokenHash(ctx, expected.TokenHash)
+		rq.NoError(err)
+		rq.Equal(expected.ID, actual.ID)
+		rq.Equal(expected.ID, actual.FamilyID)
+		rq.Equal(user.ID, actual.UserID)
+		rq.Equal(expected.ExpiresAt, actual.ExpiresAt)
+		rq.Zero(actual.UsedAt)
+		rq.False(actual.CreatedAt.IsZero())
+	})
+
+	t.Run("read a non-existed one, should fail", func(t *testing.T) {
+		rq := require.New(t)
+		_, err := store.GetRefreshTokenByTokenHash(ctx, "test_refresh_non_existed")
+		rq.Equal(datastore.ErrorRefreshTokenNotExist, err)
+		_, err = store.GetRefreshTokenByTokenHash(ctx, "")
+		rq.Equal(datastore.ErrorRefreshTokenNotExist, err)
+	})
+
+	t.Run("create for a non-existed user, should fail", func(t *testing.T) {
+		rq := require.New(t)
+		err := store.CreateRefreshToken(ctx, newToken(nonExistedID, "test_refresh_no_user"))
+		rq.Equal(datastore.ErrorUserNotExist, err)
+	})
+
+	t.Run("rotate", func(t *testing.T) {
+		rq := require.New(t)
+		first := newToken(user.ID, "test_refresh_rotate_1")
+		rq.NoError(store.CreateRefreshToken(ctx, first))
+
+		usedAt := now.Add(time.Minute)
+		next := newToken(0, "test_refresh_rotate_2")
+		rq.NoError(store.RotateRefreshToken(ctx, first.ID, usedAt, next))
+		rq.NotZero(next.ID)
+		rq.Equal(user.ID, next.UserID)
+		rq.Equal(first.FamilyID, next.FamilyID)
+
+		actual, err := store.GetRefreshTokenByTokenHash(ctx, first.TokenHash)
+		rq.NoError(err)
+		rq.Equal(usedAt.UnixNano(), actual.UsedAt)
+		actual, err = store.GetRefreshTokenByTokenHash(ctx, next.TokenHash)
+		rq.NoError(err)
+		rq.Equal(first.FamilyID, actual.FamilyID)
+		rq.Zero(actual.UsedAt)
+
+		// a token is used once
+		err = store.RotateRefreshToken(ctx, first.ID, usedAt, newToken(0, "test_refresh_rotate_3"))
+		rq.Equal(datastore.ErrorRefreshTokenUsed, err)
+		_, err = store.GetRefreshTokenByTokenHash(ctx, "test_refresh_rotate_3")
+		rq.Equal(datastore.ErrorRefreshTokenNotExist, err)
+
+		err = store.RotateRefreshToken(ctx, nonExistedID, usedAt, newToken(0, "test_refresh_rotate_4"))
+		rq.Equal(datastore.ErrorRefreshTokenNotExist, err)
+	})
+
+	t.Run("revoke family", func(t *testing.T) {
+		rq := require.New(t)
+		first := newToken(user.ID, "test_refresh_family_1")
+		rq.NoError(store.CreateRefreshToken(ctx, first))
+		second := newToken(0, "test_refresh_family_2")
+		rq.NoError(store.RotateRefreshToken(ctx, first.ID, now, second))
+		other := newToken(user.ID, "test_refresh_family_other")
+		rq.NoError(store.CreateRefreshToken(ctx, other))
+
+		n, err := store.RevokeRefreshTokenFamily(ctx, first.FamilyID)
+		rq.NoError(err)
+		rq.Equal(int64(2), n)
+		for _, hash := range []string{first.TokenHash, second.TokenHash} {
+			_, err = store.GetRefreshTokenByTokenHash(ctx, hash)
+			rq.Equal(datastore.ErrorRefreshTokenNotExist, err)
+		}
+		err = store.RotateRefreshToken(ctx, second.ID, now, newToken(0, "test_refresh_family_3"))
+		rq.Equal(datastore.ErrorRefreshTokenNotExist, err)
+
+		// other families are left alone
+		_, err = store.GetRefreshTokenByTokenHash(ctx, other.TokenHash)
+		rq.NoError(err)
+
+		n, err = store.RevokeRefreshTokenFamily(ctx, first.FamilyID)
+		rq.NoError(err)
+		rq.Zero(n)
+	})
+
+	t.Run("revoke user tokens", func(t *testing.T) {
+		rq := require.New(t)
+		owner := &datastore.User{Username: "test_refresh_revoke_user", Password: "pwd"}
+		rq.NoError(store.CreateUser(ctx, owner))
+		first := newToken(owner.ID, "test_refresh_revoke_user_1")
+		rq.NoError(store.CreateRefreshToken(ctx, first))
+		second := newToken(owner.ID, "test_refresh_revoke_user_2")
+		rq.NoError(store.CreateRefreshToken(ctx, second))
+		kept := newToken(user.ID, "test_refresh_revoke_user_kept")
+		rq.NoError(store.CreateRefreshToken(ctx, kept))
+
+		n, err := store.RevokeUserRefreshTokens(ctx, owner.ID)
+		rq.NoError(err)
+		rq.Equal(int64(2), n)
+		for _, hash := range []string{first.TokenHash, second.TokenHash} {
+			_, err = store.GetRefreshTokenByTokenHash(ctx, hash)
+			rq.Equal(datastore.ErrorRefreshTokenNotExist, err)
+		}
+		_, err = store.GetRefreshTokenByTokenHash(ctx, kept.TokenHash)
+		rq.NoError(err)
+
+		n, err = store.RevokeUserRefreshTokens(ctx, owner.ID)
+		rq.NoError(err)
+		rq.Zero(n)
+
+		_, err = store.RevokeUserRefreshTokens(ctx, nonExistedID)
+		rq.Equal(datastore.ErrorUserNotExist, err)
+	})
+
+	t.Run("revoked with the user", func(t *testing.T) {
+		rq := require.New(t)
+		other := &datastore.User{Username: "test_refresh_user_deleted", Password: "pwd"}
+		rq.NoError(store.CreateUser(ctx, other))
+		token := newToken(other.ID, "test_refresh_user_deleted")
+		rq.NoError(store.CreateRefreshToken(ctx, token))
+
+		rq.NoError(store.DeleteUserByID(ctx, other.ID))
+		_, err := store.GetRefreshTokenByTokenHash(ctx, token.TokenHash)
+		rq.Equal(datastore.ErrorRefreshTokenNotExist, err)
+	})
+
+	t.Run("audit", func(t *testing.T) {
+		rq := require.New(t)
+		owner := &datastore.User{Username: "test_refresh_audit", Password: "pwd"}
+		rq.NoError(store.CreateUser(ctx, owner))
+
+		since := time.Now()
+		first := newToken(owner.ID, "test_refresh_audit_1")
+		rq.NoError(store.CreateRefreshToken(datastore.WithActor(ctx, "user"), first))
+		next := newToken(0, "test_refresh_audit_2")
+		rq.NoError(store.RotateRefreshToken(datastore.WithActor(ctx, "user"), first.ID, now, next))
+		_, err := store.RevokeRefreshTokenFamily(datastore.WithActor(ctx, "user"), first.FamilyID)
+		rq.NoError(err)
+		other := newToken(owner.ID, "test_refresh_audit_3")
+		rq.NoError(store.CreateRefreshToken(datastore.WithActor(ctx, "user"), other))
+		_, err = store.RevokeUserRefreshTokens(datastore.WithActor(ctx, "admin"), owner.ID)
+		rq.NoError(err)
+
+		events, _, err := store.ListAuditEvents(ctx, datastore.AuditListOption{Since: since})
+		rq.NoError(err)
+		rq.Equal([]auditRecord{
+			{"user", datastore.AuditCreate, datastore.AuditTargetRefreshToken, first.ID,
+				``, fmt.Sprintf(`{"family_id":%d,"user_id":%d}`, first.FamilyID, owner.ID)},
+			{"user", datastore.AuditRotate, datastore.AuditTargetRefreshToken, first.ID,
+				``, fmt.Sprintf(`{"family_id":%d,"next_id":%d}`, first.FamilyID, next.ID)},
+			{"user", datastore.AuditRevoke, datastore.AuditTargetRefreshToken, first.FamilyID,
+				fmt.Sprintf(`{"tokens":2,"user_id":%d}`, owner.ID), ``},
+			{"user", datastore.AuditCreate, datastore.AuditTargetRefreshToken, other.ID,
+				``, fmt.Sprintf(`{"family_id":%d,"user_id":%d}`, other.FamilyID, owner.ID)},
+			{"admin", datastore.AuditRevoke, datastore.AuditTargetUser, owner.ID,
+				`{"refresh_tokens":1}`, ``},
+		}, auditRecordsOf(events))
+	})
+}
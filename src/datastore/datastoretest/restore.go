@@ -0,0 +1,150 @@
+package datastoretest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hanzezhenalex/auth/src/datastore"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testRestore(t *testing.T, store datastore.Datastore) {
+	ctx := context.Background()
+
+	t.Run("restore authority", func(t *testing.T) {
+		rq := require.New(t)
+		auth := &datastore.Authority{AuthName: "test_restore_auth"}
+		rq.NoError(store.CreateAuthority(ctx, auth))
+		rq.NoError(store.DeleteAuthorityByID(ctx, auth.ID, false))
+
+		rq.NoError(store.RestoreAuthorityByID(ctx, auth.ID))
+		actual, err := store.GetAuthorityByID(ctx, auth.ID)
+		rq.NoError(err)
+		rq.Equal(auth.AuthName, actual.AuthName)
+
+		// restoring a live authority does nothing
+		rq.NoError(store.RestoreAuthorityByID(ctx, auth.ID))
+	})
+
+	t.Run("restore force deleted authority", func(t *testing.T) {
+		rq := require.New(t)
+		auth := &datastore.Authority{AuthName: "test_restore_auth_force"}
+		rq.NoError(store.CreateAuthority(ctx, auth))
+		role := &datastore.Role{RoleName: "test_restore_auth_force_role", Auths: []string{auth.AuthName}}
+		rq.NoError(store.CreateRole(ctx, role))
+
+		rq.NoError(store.DeleteAuthorityByID(ctx, auth.ID, true))
+		actual, err := store.GetRoleByID(ctx, role.ID)
+		rq.NoError(err)
+		rq.Empty(actual.Auths)
+
+		rq.NoError(store.RestoreAuthorityByID(ctx, auth.ID))
+		actual, err = store.GetRoleByID(ctx, role.ID)
+		rq.NoError(err)
+		rq.Equal([]string{auth.AuthName}, actual.Auths)
+	})
+
+	t.Run("restore authority whose name is taken", func(t *testing.T) {
+		rq := require.New(t)
+		const name = "test_restore_auth_taken"
+		auth := &datastore.Authority{AuthName: name}
+		rq.NoError(store.CreateAuthority(ctx, auth))
+		rq.NoError(store.DeleteAuthorityByID(ctx, auth.ID, false))
+		rq.NoError(store.CreateAuthority(ctx, &datastore.Authority{AuthName: name}))
+
+		rq.Equal(datastore.ErrorAuthExist, store.RestoreAuthorityByID(ctx, auth.ID))
+		_, err := store.GetAuthorityByID(ctx, auth.ID)
+		rq.Equal(datastore.ErrorAuthNotExist, err)
+	})
+
+	t.Run("restore non-existed authority", func(t *testing.T) {
+		rq := require.New(t)
+		rq.Equal(datastore.ErrorAuthNotExist, store.RestoreAuthorityByID(ctx, nonExistedID))
+	})
+
+	t.Run("restore role", func(t *testing.T) {
+		rq := require.New(t)
+		auth1 := &datastore.Authority{AuthName: "test_restore_role_auth_1"}
+		rq.NoError(store.CreateAuthority(ctx, auth1))
+		auth2 := &datastore.Authority{AuthName: "test_restore_role_auth_2"}
+		rq.NoError(store.CreateAuthority(ctx, auth2))
+
+		role := &datastore.Role{
+			RoleName: "test_restore_role",
+			Scopes:   []string{"scope1", "scope2"},
+			Auths:    []string{auth1.AuthName, auth2.AuthName},
+		}
+		rq.NoError(store.CreateRole(ctx, role))
+
+		// removed before the role is deleted, they stay removed
+		rq.NoError(store.UpdateScopesByID(ctx, role.ID, datastore.UpdateRoleScopeOption{
+			Unassign: []string{"scope2"},
+		}))
+		rq.NoError(store.UpdateRoleAuthsByID(ctx, role.ID, datastore.UpdateRoleAuthOption{
+			Unassign: []string{auth2.AuthName},
+		}))
+
+		user := &datastore.User{Username: "test_restore_role_user", Password: "password"}
+		rq.NoError(store.CreateUser(ctx, user))
+		rq.NoError(store.AssignRoles(ctx, user.ID, []string{role.RoleName}))
+
+		rq.NoError(store.DeleteRoleByID(ctx, role.ID))
+		rq.NoError(store.RestoreRoleByID(ctx, role.ID))
+
+		actual, err := store.GetRoleByID(ctx, role.ID)
+		rq.NoError(err)
+		rq.EqualValues([]string{"scope1"}, actual.Scopes)
+		rq.Equal([]string{auth1.AuthName}, actual.Auths)
+
+		// users are not assigned again
+		roles, err := store.ListUserRoles(ctx, user.ID)
+		rq.NoError(err)
+		rq.Empty(roles)
+
+		// restoring a live role does nothing
+		rq.NoError(store.RestoreRoleByID(ctx, role.ID))
+	})
+
+	t.Run("delete and restore role multiple times", func(t *testing.T) {
+		rq := require.New(t)
+		auth := &datastore.Authority{AuthName: "test_restore_role_times_auth"}
+		rq.NoError(store.CreateAuthority(ctx, auth))
+		role := &datastore.Role{
+			RoleName: "test_restore_role_times",
+			Scopes:   []string{"scope1"},
+			Auths:    []string{auth.AuthName},
+		}
+		rq.NoError(store.CreateRole(ctx, role))
+
+		for i := 0; i < 3; i++ {
+			rq.NoError(store.DeleteRoleByID(ctx, role.ID))
+			rq.NoError(store.RestoreRoleByID(ctx, role.ID))
+		}
+
+		actual, err := store.GetRoleByID(ctx, role.ID)
+		rq.NoError(err)
+		rq.EqualValues([]string{"scope1"}, actual.Scopes)
+		rq.Equal([]string{auth.AuthName}, actual.Auths)
+	})
+
+	t.Run("restore role whose name is taken", func(t *testing.T) {
+		rq := require.New(t)
+		const name = "test_restore_role_taken"
+		role := &datastore.Role{RoleName: name, Scopes: []string{"scope1"}}
+		rq.NoError(store.CreateRole(ctx, role))
+		rq.NoError(store.DeleteRoleByID(ctx, role.ID))
+		rq.NoError(store.CreateRole(ctx, &datastore.Role{RoleName: name, Scopes: []string{"scope2"}}))
+
+		rq.Equal(datastore.ErrorRoleExist, store.RestoreRoleByID(ctx, role.ID))
+
+		actual, err := store.GetRoleByName(ctx, name)
+		rq.NoError(err)
+		rq.EqualValues([]string{"scope2"}, actual.Scopes)
+	})
+
+	t.Run("restore non-existed role", func(t *testing.T) {
+		rq := require.New(t)
+		rq.Equal(datastore.ErrorRoleNotExist, store.RestoreRoleByID(ctx, nonExistedID))
+	})
+}
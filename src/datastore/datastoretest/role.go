@@ -0,0 +1,501 @@
+package datastoretest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hanzezhenalex/auth/src"
+	"github.com/hanzezhenalex/auth/src/datastore"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testRole(t *testing.T, store datastore.Datastore) {
+	rq := require.New(t)
+	ctx := context.Background()
+
+	const auth1 = "test_role_auth_1"
+	rq.NoError(store.CreateAuthority(ctx, &datastore.Authority{AuthName: auth1}))
+
+	const auth2 = "test_role_auth_2"
+	rq.NoError(store.CreateAuthority(ctx, &datastore.Authority{AuthName: auth2}))
+
+	const auth3 = "test_role_auth_3_not_exist"
+
+	t.Run("create, no auth", func(t *testing.T) {
+		rq := require.New(t)
+		role := datastore.Role{
+			RoleName: "test_role_create_no_auth",
+			Scopes:   []string{"scope1", "scope2"},
+		}
+		rq.NoError(store.CreateRole(ctx, &role))
+	})
+
+	t.Run("create, with auth", func(t *testing.T) {
+		rq := require.New(t)
+		role := datastore.Role{
+			RoleName: "test_role_create_with_auth",
+			Scopes:   []string{"scope1", "scope2"},
+			Auths:    []string{auth1, auth2},
+		}
+		rq.NoError(store.CreateRole(ctx, &role))
+	})
+
+	t.Run("create, with non-existed auth", func(t *testing.T) {
+		rq := require.New(t)
+		role := datastore.Role{
+			RoleName: "test_role_create_with non-existed auth",
+			Scopes:   []string{"scope1", "scope2"},
+			Auths:    []string{auth1, auth3},
+		}
+		rq.Equal(datastore.ErrorAuthNotExist, store.CreateRole(ctx, &role))
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		rq := require.New(t)
+		role := datastore.Role{
+			RoleName: "test_role_delete",
+			Scopes:   []string{"scope1", "scope2"},
+			Auths:    []string{auth1, auth2},
+		}
+		rq.NoError(store.CreateRole(ctx, &role))
+
+		rq.NoError(store.DeleteRoleByID(ctx, role.ID))
+	})
+
+	t.Run("delete an non-existed role", func(t *testing.T) {
+		rq := require.New(t)
+		rq.Equal(datastore.ErrorRoleNotExist, store.DeleteRoleByID(ctx, nonExistedID))
+	})
+
+	t.Run("read", func(t *testing.T) {
+		rq := require.New(t)
+		expected := &datastore.Role{
+			RoleName: "test_role_read",
+			Scopes:   []string{"scope1", "scope2"},
+			Auths:    []string{auth1, auth2},
+		}
+		rq.NoError(store.CreateRole(ctx, expected))
+
+		actual, err := store.GetRoleByID(ctx, expected.ID)
+		rq.NoError(err)
+
+		expected.CreatedAt = time.Unix(expected.CreatedAt.Unix(), 0)
+		expected.UpdatedAt = time.Unix(expected.UpdatedAt.Unix(), 0)
+		actual.CreatedAt = time.Unix(actual.CreatedAt.Unix(), 0)
+		actual.UpdatedAt = time.Unix(actual.UpdatedAt.Unix(), 0)
+
+		rq.EqualValues(expected, actual)
+	})
+
+	t.Run("read an non-existed role", func(t *testing.T) {
+		rq := require.New(t)
+		_, err := store.GetRoleByID(ctx, nonExistedID)
+		rq.Equal(datastore.ErrorRoleNotExist, err)
+	})
+
+	t.Run("read by name", func(t *testing.T) {
+		rq := require.New(t)
+		expected := &datastore.Role{
+			RoleName: "test_role_read_by_name",
+			Scopes:   []string{"scope1", "scope2"},
+			Auths:    []string{auth1, auth2},
+		}
+		rq.NoError(store.CreateRole(ctx, expected))
+
+		actual, err := store.GetRoleByName(ctx, expected.RoleName)
+		rq.NoError(err)
+
+		expected.CreatedAt = time.Unix(expected.CreatedAt.Unix(), 0)
+		expected.UpdatedAt = time.Unix(expected.UpdatedAt.Unix(), 0)
+		actual.CreatedAt = time.Unix(actual.CreatedAt.Unix(), 0)
+		actual.UpdatedAt = time.Unix(actual.UpdatedAt.Unix(), 0)
+
+		rq.EqualValues(expected, actual)
+	})
+
+	t.Run("read by name, non-existed one should fail", func(t *testing.T) {
+		rq := require.New(t)
+		actual, err := store.GetRoleByName(ctx, "test_role_read_by_name_non_existed")
+		rq.Nil(actual)
+		rq.Equal(datastore.ErrorRoleNotExist, err)
+	})
+
+	t.Run("read a role with deleted auth", func(t *testing.T) {
+		rq := require.New(t)
+		const deletedAuth = "read_a_role_with_deleted_auth"
+		auth := &datastore.Authority{AuthName: deletedAuth}
+		rq.NoError(store.CreateAuthority(ctx, auth))
+
+		role := &datastore.Role{
+			RoleName: "test_role_read_a_role_with_deleted_auth",
+			Scopes:   []string{"scope1", "scope2"},
+			Auths:    []string{auth1, deletedAuth},
+		}
+
+		rq.NoError(store.CreateRole(ctx, role))
+
+		rq.NoError(store.DeleteAuthorityByID(ctx, auth.ID, true))
+
+		actual, err := store.GetRoleByID(ctx, role.ID)
+		rq.NoError(err)
+
+		role.CreatedAt = time.Unix(role.CreatedAt.Unix(), 0)
+		role.UpdatedAt = time.Unix(role.UpdatedAt.Unix(), 0)
+		role.Auths = []string{auth1}
+		actual.CreatedAt = time.Unix(actual.CreatedAt.Unix(), 0)
+		actual.UpdatedAt = time.Unix(actual.UpdatedAt.Unix(), 0)
+
+		rq.EqualValues(role, actual)
+	})
+
+	t.Run("read a deleted role", func(t *testing.T) {
+		rq := require.New(t)
+		role := datastore.Role{
+			RoleName: "test_role_read_a_deleted_role",
+			Scopes:   []string{"scope1", "scope2"},
+			Auths:    []string{auth1, auth2},
+		}
+		rq.NoError(store.CreateRole(ctx, &role))
+		rq.NoError(store.DeleteRoleByID(ctx, role.ID))
+
+		_, err := store.GetRoleByID(ctx, role.ID)
+		rq.Equal(datastore.ErrorRoleNotExist, err)
+	})
+
+	t.Run("assign/unassign scopes", func(t *testing.T) {
+		rq := require.New(t)
+		role := datastore.Role{
+			RoleName: "test_role_assign_scopes",
+			Scopes:   []string{"scope1", "scope2"},
+			Auths:    []string{auth1, auth2},
+		}
+		rq.NoError(store.CreateRole(ctx, &role))
+
+		rq.NoError(store.UpdateScopesByID(ctx, role.ID, datastore.UpdateRoleScopeOption{
+			Assign:   []string{"scope3"},
+			Unassign: []string{"scope1"},
+		}))
+
+		actual, err := store.GetRoleByID(ctx, role.ID)
+		rq.NoError(err)
+		rq.EqualValues([]string{"scope2", "scope3"}, actual.Scopes)
+	})
+
+	t.Run("assign duplicated scopes", func(t *testing.T) {
+		rq := require.New(t)
+		role := datastore.Role{
+			RoleName: "test_role_assign_deplicated_scopes",
+			Scopes:   []string{"scope1", "scope2"},
+			Auths:    []string{auth1, auth2},
+		}
+		rq.NoError(store.CreateRole(ctx, &role))
+
+		rq.NoError(store.UpdateScopesByID(ctx, role.ID, datastore.UpdateRoleScopeOption{
+			Assign: []string{"scope2"},
+		}))
+
+		actual, err := store.GetRoleByID(ctx, role.ID)
+		rq.NoError(err)
+		rq.EqualValues([]string{"scope1", "scope2"}, actual.Scopes)
+	})
+
+	t.Run("unassign duplicated scopes", func(t *testing.T) {
+		rq := require.New(t)
+		role := datastore.Role{
+			RoleName: "test_role_unassign_duplicated_scopes",
+			Scopes:   []string{"scope1", "scope2"},
+			Auths:    []string{auth1, auth2},
+		}
+		rq.NoError(store.CreateRole(ctx, &role))
+
+		rq.NoError(store.UpdateScopesByID(ctx, role.ID, datastore.UpdateRoleScopeOption{
+			Unassign: []string{"scope1", "scope1"},
+		}))
+
+		actual, err := store.GetRoleByID(ctx, role.ID)
+		rq.NoError(err)
+		rq.EqualValues([]string{"scope2"}, actual.Scopes)
+	})
+
+	t.Run("unassign non-existed scopes", func(t *testing.T) {
+		rq := require.New(t)
+		role := datastore.Role{
+			RoleName: "test_role_unassign_non-existed_scopes",
+			Scopes:   []string{"scope1", "scope2"},
+			Auths:    []string{auth1, auth2},
+		}
+		rq.NoError(store.CreateRole(ctx, &role))
+
+		rq.Equal(datastore.ErrorUnassignNonExistedScopes,
+			store.UpdateScopesByID(ctx, role.ID, datastore.UpdateRoleScopeOption{
+				Unassign: []string{"scope3"},
+			}),
+		)
+	})
+
+	t.Run("assign/unassign auths", func(t *testing.T) {
+		rq := require.New(t)
+		role := datastore.Role{
+			RoleName: "test_role_assign_auths",
+			Scopes:   []string{"scope1"},
+			Auths:    []string{auth1},
+		}
+		rq.NoError(store.CreateRole(ctx, &role))
+
+		rq.NoError(store.UpdateRoleAuthsByID(ctx, role.ID, datastore.UpdateRoleAuthOption{
+			Assign:   []string{auth2},
+			Unassign: []string{auth1},
+		}))
+
+		actual, err := store.GetRoleByID(ctx, role.ID)
+		rq.NoError(err)
+		rq.EqualValues([]string{auth2}, actual.Auths)
+
+		// bind again after unbind
+		rq.NoError(store.UpdateRoleAuthsByID(ctx, role.ID, datastore.UpdateRoleAuthOption{
+			Assign: []string{auth1, auth2},
+		}))
+
+		actual, err = store.GetRoleByID(ctx, role.ID)
+		rq.NoError(err)
+		src.SortSliceAsc(actual.Auths)
+		rq.EqualValues([]string{auth1, auth2}, actual.Auths)
+	})
+
+	t.Run("assign non-existed auths", func(t *testing.T) {
+		rq := require.New(t)
+		role := datastore.Role{
+			RoleName: "test_role_assign_non-existed_auths",
+			Scopes:   []string{"scope1"},
+		}
+		rq.NoError(store.CreateRole(ctx, &role))
+
+		rq.Equal(datastore.ErrorAuthNotExist,
+			store.UpdateRoleAuthsByID(ctx, role.ID, datastore.UpdateRoleAuthOption{
+				Assign: []string{auth1, auth3},
+			}),
+		)
+
+		actual, err := store.GetRoleByID(ctx, role.ID)
+		rq.NoError(err)
+		rq.Equal(0, len(actual.Auths))
+	})
+
+	t.Run("unassign non-bound auths", func(t *testing.T) {
+		rq := require.New(t)
+		role := datastore.Role{
+			RoleName: "test_role_unassign_non-bound_auths",
+			Scopes:   []string{"scope1"},
+			Auths:    []string{auth1},
+		}
+		rq.NoError(store.CreateRole(ctx, &role))
+
+		rq.Equal(datastore.ErrorUnassignNonExistedAuths,
+			store.UpdateRoleAuthsByID(ctx, role.ID, datastore.UpdateRoleAuthOption{
+				Unassign: []string{auth2},
+			}),
+		)
+	})
+
+	t.Run("update auths of non-existed role", func(t *testing.T) {
+		rq := require.New(t)
+		rq.Equal(datastore.ErrorRoleNotExist,
+			store.UpdateRoleAuthsByID(ctx, nonExistedID, datastore.UpdateRoleAuthOption{
+				Assign: []string{auth1},
+			}),
+		)
+	})
+
+	t.Run("create duplicated role, should fail", func(t *testing.T) {
+		rq := require.New(t)
+		const name = "test_role_create_duplicated"
+
+		rq.NoError(store.CreateRole(ctx, &datastore.Role{RoleName: name, Scopes: []string{"scope1"}}))
+		rq.Equal(datastore.ErrorRoleExist,
+			store.CreateRole(ctx, &datastore.Role{RoleName: name, Scopes: []string{"scope1"}}),
+		)
+	})
+
+	t.Run("create with non-existed auth, role should not be created", func(t *testing.T) {
+		rq := require.New(t)
+		const name = "test_role_create_with_non-existed_auth_rollback"
+
+		rq.Equal(datastore.ErrorAuthNotExist, store.CreateRole(ctx, &datastore.Role{
+			RoleName: name,
+			Scopes:   []string{"scope1"},
+			Auths:    []string{auth3},
+		}))
+
+		_, err := store.GetRoleByName(ctx, name)
+		rq.Equal(datastore.ErrorRoleNotExist, err)
+	})
+
+	t.Run("create and delete multiple times", func(t *testing.T) {
+		rq := require.New(t)
+		role := &datastore.Role{
+			RoleName: "test_role_create_and_delete_multiple_times",
+			Scopes:   []string{"scope1"},
+			Auths:    []string{auth1},
+		}
+
+		rq.NoError(store.CreateRole(ctx, role))
+		rq.NoError(store.DeleteRoleByID(ctx, role.ID))
+
+		role.ID = 0
+		role.DeletedAt = 0
+
+		rq.NoError(store.CreateRole(ctx, role))
+		rq.NoError(store.DeleteRoleByID(ctx, role.ID))
+	})
+
+	t.Run("delete twice, should fail", func(t *testing.T) {
+		rq := require.New(t)
+		role := &datastore.Role{RoleName: "test_role_delete_twice", Scopes: []string{"scope1"}}
+
+		rq.NoError(store.CreateRole(ctx, role))
+		rq.NoError(store.DeleteRoleByID(ctx, role.ID))
+		rq.Equal(datastore.ErrorRoleNotExist, store.DeleteRoleByID(ctx, role.ID))
+	})
+
+	t.Run("update scopes of non-existed role", func(t *testing.T) {
+		rq := require.New(t)
+		rq.Equal(datastore.ErrorRoleNotExist,
+			store.UpdateScopesByID(ctx, nonExistedID, datastore.UpdateRoleScopeOption{
+				Assign: []string{"scope1"},
+			}),
+		)
+	})
+
+	t.Run("assign scopes less than the assigned ones", func(t *testing.T) {
+		rq := require.New(t)
+		role := datastore.Role{
+			RoleName: "test_role_assign_scopes_less_than_assigned",
+			Scopes:   []string{"scope2", "scope3"},
+		}
+		rq.NoError(store.CreateRole(ctx, &role))
+
+		rq.NoError(store.UpdateScopesByID(ctx, role.ID, datastore.UpdateRoleScopeOption{
+			Assign:   []string{"scope1"},
+			Unassign: []string{"scope3"},
+		}))
+
+		actual, err := store.GetRoleByID(ctx, role.ID)
+		rq.NoError(err)
+		rq.EqualValues([]string{"scope1", "scope2"}, actual.Scopes)
+	})
+
+	t.Run("unassign all scopes", func(t *testing.T) {
+		rq := require.New(t)
+		role := datastore.Role{
+			RoleName: "test_role_unassign_all_scopes",
+			Scopes:   []string{"scope1"},
+		}
+		rq.NoError(store.CreateRole(ctx, &role))
+
+		rq.NoError(store.UpdateScopesByID(ctx, role.ID, datastore.UpdateRoleScopeOption{
+			Unassign: []string{"scope1"},
+		}))
+
+		actual, err := store.GetRoleByID(ctx, role.ID)
+		rq.NoError(err)
+		rq.Equal(0, len(actual.Scopes))
+	})
+
+	t.Run("assign duplicated auths", func(t *testing.T) {
+		rq := require.New(t)
+		role := datastore.Role{
+			RoleName: "test_role_assign_duplicated_auths",
+			Scopes:   []string{"scope1"},
+			Auths:    []string{auth1},
+		}
+		rq.NoError(store.CreateRole(ctx, &role))
+
+		rq.NoError(store.UpdateRoleAuthsByID(ctx, role.ID, datastore.UpdateRoleAuthOption{
+			Assign: []string{auth1, auth2, auth2},
+		}))
+
+		actual, err := store.GetRoleByID(ctx, role.ID)
+		rq.NoError(err)
+		src.SortSliceAsc(actual.Auths)
+		rq.EqualValues([]string{auth1, auth2}, actual.Auths)
+	})
+
+	t.Run("scopes are sorted and distinct", func(t *testing.T) {
+		rq := require.New(t)
+		role := datastore.Role{
+			RoleName: "test_role_scopes_sorted_distinct",
+			Scopes:   []string{"scope2", "scope1", "scope2"},
+		}
+		rq.NoError(store.CreateRole(ctx, &role))
+
+		rq.NoError(store.UpdateScopesByID(ctx, role.ID, datastore.UpdateRoleScopeOption{
+			Assign: []string{"scope0", "scope0"},
+		}))
+
+		actual, err := store.GetRoleByID(ctx, role.ID)
+		rq.NoError(err)
+		rq.EqualValues([]string{"scope0", "scope1", "scope2"}, actual.Scopes)
+	})
+
+	t.Run("scopes with the legacy delimiter", func(t *testing.T) {
+		rq := require.New(t)
+		role := datastore.Role{
+			RoleName: "test_role_scopes_with_delimiter",
+			Scopes:   []string{"orders;read"},
+		}
+		rq.NoError(store.CreateRole(ctx, &role))
+
+		rq.NoError(store.UpdateScopesByID(ctx, role.ID, datastore.UpdateRoleScopeOption{
+			Assign: []string{"orders;write"},
+		}))
+
+		actual, err := store.GetRoleByID(ctx, role.ID)
+		rq.NoError(err)
+		rq.EqualValues([]string{"orders;read", "orders;write"}, actual.Scopes)
+	})
+
+	t.Run("reassign unassigned scopes", func(t *testing.T) {
+		rq := require.New(t)
+		role := datastore.Role{
+			RoleName: "test_role_reassign_scopes",
+			Scopes:   []string{"scope1"},
+		}
+		rq.NoError(store.CreateRole(ctx, &role))
+
+		for i := 0; i < 3; i++ {
+			rq.NoError(store.UpdateScopesByID(ctx, role.ID, datastore.UpdateRoleScopeOption{
+				Unassign: []string{"scope1"},
+			}))
+			rq.NoError(store.UpdateScopesByID(ctx, role.ID, datastore.UpdateRoleScopeOption{
+				Assign: []string{"scope1"},
+			}))
+		}
+
+		actual, err := store.GetRoleByID(ctx, role.ID)
+		rq.NoError(err)
+		rq.EqualValues([]string{"scope1"}, actual.Scopes)
+	})
+
+	t.Run("scopes of a deleted role", func(t *testing.T) {
+		rq := require.New(t)
+		role := datastore.Role{
+			RoleName: "test_role_deleted_scopes",
+			Scopes:   []string{"scope1", "scope2"},
+		}
+		rq.NoError(store.CreateRole(ctx, &role))
+		rq.NoError(store.UpdateScopesByID(ctx, role.ID, datastore.UpdateRoleScopeOption{
+			Unassign: []string{"scope2"},
+		}))
+		rq.NoError(store.DeleteRoleByID(ctx, role.ID))
+
+		roles, _, err := store.ListRoles(ctx, datastore.ListOption{
+			NamePrefix:     role.RoleName,
+			IncludeDeleted: true,
+		})
+		rq.NoError(err)
+		rq.Len(roles, 1)
+		rq.EqualValues([]string{"scope1"}, roles[0].Scopes)
+	})
+}
@@ -0,0 +1,136 @@
+package datastoretest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hanzezhenalex/auth/src/datastore"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testSession(t *testing.T, store datastore.Datastore) {
+	ctx := context.Background()
+	now := time.Now()
+	newSession := func(userID int64, hash string) *datastore.Session {
+		return &datastore.Session{
+			UserID:     userID,
+			TokenHash:  hash,
+			ExpiresAt:  now.Add(time.Hour).UnixNano(),
+			LastSeenAt: now.UnixNano(),
+		}
+	}
+
+	user := &datastore.User{Username: "test_session_user", Password: "pwd"}
+	require.NoError(t, store.CreateUser(ctx, user))
+
+	t.Run("create and read", func(t *testing.T) {
+		rq := require.New(t)
+		expected := newSession(user.ID, "test_session_read")
+		rq.NoError(store.CreateSession(ctx, expected))
+		rq.NotZero(expected.ID)
+
+		actual, err := store.GetSessionByTokenHash(ctx, expected.TokenHash)
+		rq.NoError(err)
+		rq.Equal(expected.ID, actual.ID)
+		rq.Equal(user.ID, actual.UserID)
+		rq.Equal(expected.ExpiresAt, actual.ExpiresAt)
+		rq.Equal(expected.LastSeenAt, actual.LastSeenAt)
+		rq.False(actual.CreatedAt.IsZero())
+	})
+
+	t.Run("read a non-existed one, should fail", func(t *testing.T) {
+		rq := require.New(t)
+		_, err := store.GetSessionByTokenHash(ctx, "test_session_non_existed")
+		rq.Equal(datastore.ErrorSessionNotExist, err)
+		_, err = store.GetSessionByTokenHash(ctx, "")
+		rq.Equal(datastore.ErrorSessionNotExist, err)
+	})
+
+	t.Run("create for a non-existed user, should fail", func(t *testing.T) {
+		rq := require.New(t)
+		err := store.CreateSession(ctx, newSession(nonExistedID, "test_session_no_user"))
+		rq.Equal(datastore.ErrorUserNotExist, err)
+	})
+
+	t.Run("touch", func(t *testing.T) {
+		rq := require.New(t)
+		sess := newSession(user.ID, "test_session_touch")
+		rq.NoError(store.CreateSession(ctx, sess))
+
+		seenAt := now.Add(time.Minute)
+		rq.NoError(store.TouchSession(ctx, sess.ID, seenAt))
+		actual, err := store.GetSessionByTokenHash(ctx, sess.TokenHash)
+		rq.NoError(err)
+		rq.Equal(seenAt.UnixNano(), actual.LastSeenAt)
+
+		rq.Equal(datastore.ErrorSessionNotExist, store.TouchSession(ctx, nonExistedID, seenAt))
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		rq := require.New(t)
+		sess := newSession(user.ID, "test_session_revoke")
+		rq.NoError(store.CreateSession(ctx, sess))
+
+		rq.NoError(store.RevokeSessionByID(ctx, sess.ID))
+		_, err := store.GetSessionByTokenHash(ctx, sess.TokenHash)
+		rq.Equal(datastore.ErrorSessionNotExist, err)
+		rq.Equal(datastore.ErrorSessionNotExist, store.RevokeSessionByID(ctx, sess.ID))
+		rq.Equal(datastore.ErrorSessionNotExist, store.TouchSession(ctx, sess.ID, now))
+	})
+
+	t.Run("list user sessions", func(t *testing.T) {
+		rq := require.New(t)
+		other := &datastore.User{Username: "test_session_list", Password: "pwd"}
+		rq.NoError(store.CreateUser(ctx, other))
+
+		first := newSession(other.ID, "test_session_list_1")
+		rq.NoError(store.CreateSession(ctx, first))
+		second := newSession(other.ID, "test_session_list_2")
+		rq.NoError(store.CreateSession(ctx, second))
+		revoked := newSession(other.ID, "test_session_list_3")
+		rq.NoError(store.CreateSession(ctx, revoked))
+		rq.NoError(store.RevokeSessionByID(ctx, revoked.ID))
+
+		sessions, err := store.ListUserSessions(ctx, other.ID)
+		rq.NoError(err)
+		rq.Len(sessions, 2)
+		rq.Equal(first.ID, sessions[0].ID)
+		rq.Equal(second.ID, sessions[1].ID)
+
+		_, err = store.ListUserSessions(ctx, nonExistedID)
+		rq.Equal(datastore.ErrorUserNotExist, err)
+	})
+
+	t.Run("revoked with the user", func(t *testing.T) {
+		rq := require.New(t)
+		other := &datastore.User{Username: "test_session_user_deleted", Password: "pwd"}
+		rq.NoError(store.CreateUser(ctx, other))
+		sess := newSession(other.ID, "test_session_user_deleted")
+		rq.NoError(store.CreateSession(ctx, sess))
+
+		rq.NoError(store.DeleteUserByID(ctx, other.ID))
+		_, err := store.GetSessionByTokenHash(ctx, sess.TokenHash)
+		rq.Equal(datastore.ErrorSessionNotExist, err)
+	})
+
+	t.Run("audit", func(t *testing.T) {
+		rq := require.New(t)
+		since := time.Now()
+		sess := newSession(user.ID, "test_session_audit")
+		rq.NoError(store.CreateSession(datastore.WithActor(ctx, "user"), sess))
+		rq.NoError(store.TouchSession(ctx, sess.ID, time.Now()))
+		rq.NoError(store.RevokeSessionByID(datastore.WithActor(ctx, "admin"), sess.ID))
+
+		// touching is not recorded
+		events, _, err := store.ListAuditEvents(ctx, datastore.AuditListOption{Since: since})
+		rq.NoError(err)
+		diff := fmt.Sprintf(`{"user_id":%d}`, user.ID)
+		rq.Equal([]auditRecord{
+			{"user", datastore.AuditCreate, datastore.AuditTargetSession, sess.ID, ``, diff},
+			{"admin", datastore.AuditDelete, datastore.AuditTargetSession, sess.ID, diff, ``},
+		}, auditRecordsOf(events))
+	})
+}
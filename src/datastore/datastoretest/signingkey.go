@@ -0,0 +1,95 @@
+package datastoretest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hanzezhenalex/auth/src/datastore"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testSigningKey(t *testing.T, store datastore.Datastore) {
+	ctx := context.Background()
+	now := time.Now()
+	newKey := func(kid string) *datastore.SigningKey {
+		return &datastore.SigningKey{
+			KeyID:       kid,
+			Algorithm:   "ES256",
+			PrivateKey:  "sealed",
+			ActivatedAt: now.UnixNano(),
+		}
+	}
+
+	t.Run("create and list", func(t *testing.T) {
+		rq := require.New(t)
+		first := newKey("test_key_1")
+		rq.NoError(store.CreateSigningKey(ctx, first))
+		second := newKey("test_key_2")
+		rq.NoError(store.CreateSigningKey(ctx, second))
+
+		keys, err := store.ListSigningKeys(ctx)
+		rq.NoError(err)
+		rq.Len(keys, 2)
+		rq.Equal(first.ID, keys[0].ID)
+		rq.Equal("test_key_1", keys[0].KeyID)
+		rq.Equal("ES256", keys[0].Algorithm)
+		rq.Equal("sealed", keys[0].PrivateKey)
+		rq.Equal(now.UnixNano(), keys[0].ActivatedAt)
+		rq.Zero(keys[0].RetiredAt)
+		rq.Equal(second.ID, keys[1].ID)
+	})
+
+	t.Run("create duplicated kid, should fail", func(t *testing.T) {
+		rq := require.New(t)
+		rq.NoError(store.CreateSigningKey(ctx, newKey("test_key_duplicated")))
+		rq.Equal(datastore.ErrorSigningKeyExist, store.CreateSigningKey(ctx, newKey("test_key_duplicated")))
+	})
+
+	t.Run("retire", func(t *testing.T) {
+		rq := require.New(t)
+		key := newKey("test_key_retire")
+		rq.NoError(store.CreateSigningKey(ctx, key))
+
+		since := time.Now()
+		retiredAt := now.Add(time.Minute)
+		rq.NoError(store.RetireSigningKey(ctx, key.ID, retiredAt))
+		// retired once, the first time stays
+		rq.NoError(store.RetireSigningKey(ctx, key.ID, retiredAt.Add(time.Minute)))
+
+		keys, err := store.ListSigningKeys(ctx)
+		rq.NoError(err)
+		for _, actual := range keys {
+			if actual.ID == key.ID {
+				rq.Equal(retiredAt.UnixNano(), actual.RetiredAt)
+			}
+		}
+
+		events, _, err := store.ListAuditEvents(ctx, datastore.AuditListOption{Since: since})
+		rq.NoError(err)
+		rq.Equal([]auditRecord{
+			{"", datastore.AuditRetire, datastore.AuditTargetSigningKey, key.ID, ``, `{"kid":"test_key_retire"}`},
+		}, auditRecordsOf(events))
+
+		rq.Equal(datastore.ErrorSigningKeyNotExist, store.RetireSigningKey(ctx, nonExistedID, retiredAt))
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		rq := require.New(t)
+		key := newKey("test_key_delete")
+		rq.NoError(store.CreateSigningKey(ctx, key))
+		rq.NoError(store.DeleteSigningKeyByID(ctx, key.ID))
+
+		keys, err := store.ListSigningKeys(ctx)
+		rq.NoError(err)
+		for _, actual := range keys {
+			rq.NotEqual(key.ID, actual.ID)
+		}
+
+		rq.Equal(datastore.ErrorSigningKeyNotExist, store.DeleteSigningKeyByID(ctx, key.ID))
+		rq.Equal(datastore.ErrorSigningKeyNotExist, store.RetireSigningKey(ctx, key.ID, now))
+		// the kid of a deleted key is not reused
+		rq.Equal(datastore.ErrorSigningKeyExist, store.CreateSigningKey(ctx, newKey("test_key_delete")))
+	})
+}
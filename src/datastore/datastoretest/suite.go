@@ -0,0 +1,41 @@
+// Package datastoretest is the conformance suite of datastore.Datastore.
+//
+// Any implementation, including third-party ones, proves it is compatible
+// with the bundled backends by running the suite from its own tests:
+//
+//	func TestMyDatastore(t *testing.T) {
+//		datastoretest.RunSuite(t, func(t *testing.T) datastore.Datastore {
+//			return newEmptyStore(t)
+//		})
+//	}
+package datastoretest
+
+import (
+	"testing"
+
+	"github.com/hanzezhenalex/auth/src/datastore"
+)
+
+const nonExistedID = 99999
+
+// Factory returns an empty Datastore under test. It is called once for
+// every group of tests, a backend reusing one store must clean it up.
+type Factory func(t *testing.T) datastore.Datastore
+
+// RunSuite runs the behavioural tests every Datastore implementation must pass
+func RunSuite(t *testing.T, factory Factory) {
+	t.Run("Authority", func(t *testing.T) { testAuthority(t, factory(t)) })
+	t.Run("Role", func(t *testing.T) { testRole(t, factory(t)) })
+	t.Run("User", func(t *testing.T) { testUser(t, factory(t)) })
+	t.Run("UserRole", func(t *testing.T) { testUserRole(t, factory(t)) })
+	t.Run("UserPermissions", func(t *testing.T) { testUserPermissions(t, factory(t)) })
+	t.Run("List", func(t *testing.T) { testList(t, factory(t)) })
+	t.Run("RoleLookup", func(t *testing.T) { testRoleLookup(t, factory(t)) })
+	t.Run("Restore", func(t *testing.T) { testRestore(t, factory(t)) })
+	t.Run("Purge", func(t *testing.T) { testPurge(t, factory(t)) })
+	t.Run("Audit", func(t *testing.T) { testAudit(t, factory(t)) })
+	t.Run("Actor", func(t *testing.T) { testActor(t, factory(t)) })
+	t.Run("Session", func(t *testing.T) { testSession(t, factory(t)) })
+	t.Run("SigningKey", func(t *testing.T) { testSigningKey(t, factory(t)) })
+	t.Run("RefreshToken", func(t *testing.T) { testRefreshToken(t, factory(t)) })
+}
@@ -0,0 +1,396 @@
+package datastoretest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hanzezhenalex/auth/src"
+	"github.com/hanzezhenalex/auth/src/datastore"
+	"github.com/hanzezhenalex/auth/src/password"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testUser(t *testing.T, store datastore.Datastore) {
+	ctx := context.Background()
+
+	t.Run("create", func(t *testing.T) {
+		rq := require.New(t)
+		rq.NoError(store.CreateUser(ctx, &datastore.User{Username: "test_user_create", Password: "pwd"}))
+	})
+
+	t.Run("create duplicated user, should fail", func(t *testing.T) {
+		rq := require.New(t)
+		const name = "test_user_create_duplicated"
+
+		rq.NoError(store.CreateUser(ctx, &datastore.User{Username: name, Password: "pwd"}))
+		rq.Equal(datastore.ErrorUserExist, store.CreateUser(ctx, &datastore.User{Username: name, Password: "pwd"}))
+	})
+
+	t.Run("read", func(t *testing.T) {
+		rq := require.New(t)
+		expected := &datastore.User{Username: "test_user_read", Password: "pwd", Reserve: "reserve"}
+		rq.NoError(store.CreateUser(ctx, expected))
+
+		actual, err := store.GetUserByID(ctx, expected.ID)
+		rq.NoError(err)
+		rq.Equal(expected.Username, actual.Username)
+		rq.Equal(expected.Password, actual.Password)
+		rq.Equal(expected.Reserve, actual.Reserve)
+
+		actual, err = store.GetUserByName(ctx, expected.Username)
+		rq.NoError(err)
+		rq.Equal(expected.ID, actual.ID)
+	})
+
+	t.Run("read a non-existed one, should fail", func(t *testing.T) {
+		rq := require.New(t)
+		_, err := store.GetUserByID(ctx, nonExistedID)
+		rq.Equal(datastore.ErrorUserNotExist, err)
+
+		_, err = store.GetUserByName(ctx, "test_user_non_existed")
+		rq.Equal(datastore.ErrorUserNotExist, err)
+	})
+
+	t.Run("update", func(t *testing.T) {
+		rq := require.New(t)
+		user := &datastore.User{Username: "test_user_update", Password: "pwd"}
+		rq.NoError(store.CreateUser(ctx, user))
+
+		user.Username = "test_user_update_renamed"
+		user.Password = "new_pwd"
+		rq.NoError(store.UpdateUser(ctx, user))
+
+		actual, err := store.GetUserByID(ctx, user.ID)
+		rq.NoError(err)
+		rq.Equal(user.Username, actual.Username)
+		rq.Equal(user.Password, actual.Password)
+	})
+
+	t.Run("update to a duplicated name, should fail", func(t *testing.T) {
+		rq := require.New(t)
+		rq.NoError(store.CreateUser(ctx, &datastore.User{Username: "test_user_update_duplicated_1", Password: "pwd"}))
+		user := &datastore.User{Username: "test_user_update_duplicated_2", Password: "pwd"}
+		rq.NoError(store.CreateUser(ctx, user))
+
+		user.Username = "test_user_update_duplicated_1"
+		rq.Equal(datastore.ErrorUserExist, store.UpdateUser(ctx, user))
+	})
+
+	t.Run("update a non-existed one, should fail", func(t *testing.T) {
+		rq := require.New(t)
+		rq.Equal(datastore.ErrorUserNotExist, store.UpdateUser(ctx, &datastore.User{ID: nonExistedID}))
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		rq := require.New(t)
+		user := &datastore.User{Username: "test_user_delete", Password: "pwd"}
+		rq.NoError(store.CreateUser(ctx, user))
+		rq.NoError(store.DeleteUserByID(ctx, user.ID))
+
+		_, err := store.GetUserByID(ctx, user.ID)
+		rq.Equal(datastore.ErrorUserNotExist, err)
+
+		// name is available again after soft delete
+		user.ID = 0
+		user.DeletedAt = 0
+		rq.NoError(store.CreateUser(ctx, user))
+	})
+
+	t.Run("delete a non-existed one, should fail", func(t *testing.T) {
+		rq := require.New(t)
+		rq.Equal(datastore.ErrorUserNotExist, store.DeleteUserByID(ctx, nonExistedID))
+	})
+
+	t.Run("authenticate, upgrade weak hash", func(t *testing.T) {
+		rq := require.New(t)
+		weakCfg, strongCfg := src.NewPasswordConfig(), src.NewPasswordConfig()
+		weakCfg.Iterations, strongCfg.Iterations = password.MinIterations, 2*password.MinIterations
+		weak, err := password.NewHasher(weakCfg)
+		rq.NoError(err)
+		strong, err := password.NewHasher(strongCfg)
+		rq.NoError(err)
+
+		user := &datastore.User{Username: "test_user_authenticate"}
+		rq.NoError(user.SetPassword(weak, "secret"))
+		rq.NoError(store.CreateUser(ctx, user))
+
+		_, err = datastore.AuthenticateUser(ctx, store, weak, user.Username, "wrong")
+		rq.Equal(datastore.ErrorPasswordMismatch, err)
+
+		actual, err := datastore.AuthenticateUser(ctx, store, strong, user.Username, "secret")
+		rq.NoError(err)
+		rq.Equal(user.ID, actual.ID)
+
+		saved, err := store.GetUserByID(ctx, user.ID)
+		rq.NoError(err)
+		rq.False(strong.NeedsRehash(saved.Password))
+	})
+
+	t.Run("update keeping the name", func(t *testing.T) {
+		rq := require.New(t)
+		user := &datastore.User{Username: "test_user_update_keeping_name", Password: "pwd"}
+		rq.NoError(store.CreateUser(ctx, user))
+
+		user.Reserve = "reserve"
+		rq.NoError(store.UpdateUser(ctx, user))
+
+		actual, err := store.GetUserByName(ctx, user.Username)
+		rq.NoError(err)
+		rq.Equal("reserve", actual.Reserve)
+	})
+
+	t.Run("update a deleted one, should fail", func(t *testing.T) {
+		rq := require.New(t)
+		user := &datastore.User{Username: "test_user_update_deleted", Password: "pwd"}
+		rq.NoError(store.CreateUser(ctx, user))
+		rq.NoError(store.DeleteUserByID(ctx, user.ID))
+
+		rq.Equal(datastore.ErrorUserNotExist, store.UpdateUser(ctx, user))
+	})
+}
+
+func testUserRole(t *testing.T, store datastore.Datastore) {
+	rq := require.New(t)
+	ctx := context.Background()
+
+	const role1 = "test_user_role_1"
+	rq.NoError(store.CreateRole(ctx, &datastore.Role{RoleName: role1, Scopes: []string{"scope1"}}))
+
+	const role2 = "test_user_role_2"
+	rq.NoError(store.CreateRole(ctx, &datastore.Role{RoleName: role2, Scopes: []string{"scope2"}}))
+
+	const role3 = "test_user_role_3_not_exist"
+
+	newUser := func(name string) *datastore.User {
+		user := &datastore.User{Username: name, Password: "pwd"}
+		rq.NoError(store.CreateUser(ctx, user))
+		return user
+	}
+
+	t.Run("assign", func(t *testing.T) {
+		rq := require.New(t)
+		user := newUser("test_user_role_assign")
+
+		rq.NoError(store.AssignRoles(ctx, user.ID, []string{role2, role1}))
+
+		roles, err := store.ListUserRoles(ctx, user.ID)
+		rq.NoError(err)
+		rq.EqualValues([]string{role1, role2}, roles)
+	})
+
+	t.Run("assign duplicated roles", func(t *testing.T) {
+		rq := require.New(t)
+		user := newUser("test_user_role_assign_duplicated")
+
+		rq.NoError(store.AssignRoles(ctx, user.ID, []string{role1}))
+		rq.NoError(store.AssignRoles(ctx, user.ID, []string{role1, role2, role2}))
+
+		roles, err := store.ListUserRoles(ctx, user.ID)
+		rq.NoError(err)
+		rq.EqualValues([]string{role1, role2}, roles)
+	})
+
+	t.Run("assign non-existed role", func(t *testing.T) {
+		rq := require.New(t)
+		user := newUser("test_user_role_assign_non_existed")
+
+		rq.Equal(datastore.ErrorRoleNotExist, store.AssignRoles(ctx, user.ID, []string{role1, role3}))
+
+		roles, err := store.ListUserRoles(ctx, user.ID)
+		rq.NoError(err)
+		rq.Equal(0, len(roles))
+	})
+
+	t.Run("assign to non-existed user", func(t *testing.T) {
+		rq := require.New(t)
+		rq.Equal(datastore.ErrorUserNotExist, store.AssignRoles(ctx, nonExistedID, []string{role1}))
+	})
+
+	t.Run("unassign", func(t *testing.T) {
+		rq := require.New(t)
+		user := newUser("test_user_role_unassign")
+
+		rq.NoError(store.AssignRoles(ctx, user.ID, []string{role1, role2}))
+		rq.NoError(store.UnassignRoles(ctx, user.ID, []string{role1}))
+
+		roles, err := store.ListUserRoles(ctx, user.ID)
+		rq.NoError(err)
+		rq.EqualValues([]string{role2}, roles)
+
+		// assign again after unassign
+		rq.NoError(store.AssignRoles(ctx, user.ID, []string{role1}))
+		roles, err = store.ListUserRoles(ctx, user.ID)
+		rq.NoError(err)
+		rq.EqualValues([]string{role1, role2}, roles)
+	})
+
+	t.Run("unassign non-assigned role", func(t *testing.T) {
+		rq := require.New(t)
+		user := newUser("test_user_role_unassign_non_assigned")
+
+		rq.NoError(store.AssignRoles(ctx, user.ID, []string{role1}))
+		rq.Equal(datastore.ErrorUnassignNonExistedRoles, store.UnassignRoles(ctx, user.ID, []string{role2}))
+	})
+
+	t.Run("list roles of non-existed user", func(t *testing.T) {
+		rq := require.New(t)
+		_, err := store.ListUserRoles(ctx, nonExistedID)
+		rq.Equal(datastore.ErrorUserNotExist, err)
+	})
+
+	t.Run("delete role", func(t *testing.T) {
+		rq := require.New(t)
+		const name = "test_user_role_delete_role"
+		role := &datastore.Role{RoleName: name, Scopes: []string{"scope1"}}
+		rq.NoError(store.CreateRole(ctx, role))
+
+		user := newUser("test_user_role_delete_role")
+		rq.NoError(store.AssignRoles(ctx, user.ID, []string{role1, name}))
+		rq.NoError(store.DeleteRoleByID(ctx, role.ID))
+
+		roles, err := store.ListUserRoles(ctx, user.ID)
+		rq.NoError(err)
+		rq.EqualValues([]string{role1}, roles)
+	})
+
+	t.Run("unassign from non-existed user", func(t *testing.T) {
+		rq := require.New(t)
+		rq.Equal(datastore.ErrorUserNotExist, store.UnassignRoles(ctx, nonExistedID, []string{role1}))
+	})
+
+	t.Run("delete user", func(t *testing.T) {
+		rq := require.New(t)
+		const name = "test_user_role_delete_user"
+		user := newUser(name)
+		rq.NoError(store.AssignRoles(ctx, user.ID, []string{role1}))
+		rq.NoError(store.DeleteUserByID(ctx, user.ID))
+
+		_, err := store.ListUserRoles(ctx, user.ID)
+		rq.Equal(datastore.ErrorUserNotExist, err)
+
+		// bindings are not inherited by a new user with the same name
+		recreated := newUser(name)
+		roles, err := store.ListUserRoles(ctx, recreated.ID)
+		rq.NoError(err)
+		rq.Equal(0, len(roles))
+	})
+}
+
+func testUserPermissions(t *testing.T, store datastore.Datastore) {
+	rq := require.New(t)
+	ctx := context.Background()
+
+	const auth1 = "test_user_perm_auth_1"
+	rq.NoError(store.CreateAuthority(ctx, &datastore.Authority{AuthName: auth1}))
+
+	const auth2 = "test_user_perm_auth_2"
+	deletedAuth := &datastore.Authority{AuthName: auth2}
+	rq.NoError(store.CreateAuthority(ctx, deletedAuth))
+
+	const role1 = "test_user_perm_role_1"
+	rq.NoError(store.CreateRole(ctx, &datastore.Role{
+		RoleName: role1,
+		Scopes:   []string{"scope1", "scope2"},
+		Auths:    []string{auth1, auth2},
+	}))
+
+	const role2 = "test_user_perm_role_2"
+	rq.NoError(store.CreateRole(ctx, &datastore.Role{
+		RoleName: role2,
+		Scopes:   []string{"scope2", "scope3"},
+		Auths:    []string{auth1},
+	}))
+
+	rq.NoError(store.DeleteAuthorityByID(ctx, deletedAuth.ID, true))
+
+	t.Run("union of roles", func(t *testing.T) {
+		rq := require.New(t)
+		user := &datastore.User{Username: "test_user_perm_union", Password: "pwd"}
+		rq.NoError(store.CreateUser(ctx, user))
+		rq.NoError(store.AssignRoles(ctx, user.ID, []string{role1, role2}))
+
+		perm, err := store.GetUserPermissions(ctx, user.ID)
+		rq.NoError(err)
+		rq.EqualValues(datastore.Scopes{"scope1", "scope2", "scope3"}, perm.Scopes)
+		rq.EqualValues([]string{auth1}, perm.Auths)
+		rq.Equal(2, len(perm.Roles))
+		rq.Equal(role1, perm.Roles[0].RoleName)
+		rq.EqualValues(datastore.Scopes{"scope1", "scope2"}, perm.Roles[0].Scopes)
+		rq.EqualValues([]string{auth1}, perm.Roles[0].Auths)
+		rq.Equal(role2, perm.Roles[1].RoleName)
+	})
+
+	t.Run("role with several scopes and authorities", func(t *testing.T) {
+		rq := require.New(t)
+		const auth3 = "test_user_perm_auth_3"
+		rq.NoError(store.CreateAuthority(ctx, &datastore.Authority{AuthName: auth3}))
+		role := &datastore.Role{
+			RoleName: "test_user_perm_role_several",
+			Scopes:   []string{"scope1", "scope2", "scope3"},
+			Auths:    []string{auth1, auth3},
+		}
+		rq.NoError(store.CreateRole(ctx, role))
+
+		user := &datastore.User{Username: "test_user_perm_several", Password: "pwd"}
+		rq.NoError(store.CreateUser(ctx, user))
+		rq.NoError(store.AssignRoles(ctx, user.ID, []string{role.RoleName, role2}))
+
+		perm, err := store.GetUserPermissions(ctx, user.ID)
+		rq.NoError(err)
+		rq.EqualValues(datastore.Scopes{"scope1", "scope2", "scope3"}, perm.Scopes)
+		rq.EqualValues([]string{auth1, auth3}, perm.Auths)
+		rq.Equal(2, len(perm.Roles))
+		rq.Equal(role.RoleName, perm.Roles[1].RoleName)
+		rq.EqualValues(datastore.Scopes{"scope1", "scope2", "scope3"}, perm.Roles[1].Scopes)
+		rq.EqualValues([]string{auth1, auth3}, perm.Roles[1].Auths)
+		rq.Equal(role2, perm.Roles[0].RoleName)
+		rq.EqualValues(datastore.Scopes{"scope2", "scope3"}, perm.Roles[0].Scopes)
+		rq.EqualValues([]string{auth1}, perm.Roles[0].Auths)
+	})
+
+	t.Run("user without role", func(t *testing.T) {
+		rq := require.New(t)
+		user := &datastore.User{Username: "test_user_perm_no_role", Password: "pwd"}
+		rq.NoError(store.CreateUser(ctx, user))
+
+		perm, err := store.GetUserPermissions(ctx, user.ID)
+		rq.NoError(err)
+		rq.Equal(0, len(perm.Scopes))
+		rq.Equal(0, len(perm.Auths))
+		rq.Equal(0, len(perm.Roles))
+	})
+
+	t.Run("non-existed user", func(t *testing.T) {
+		rq := require.New(t)
+		_, err := store.GetUserPermissions(ctx, nonExistedID)
+		rq.Equal(datastore.ErrorUserNotExist, err)
+	})
+
+	t.Run("deleted role is not granted", func(t *testing.T) {
+		rq := require.New(t)
+		role := &datastore.Role{RoleName: "test_user_perm_deleted_role", Scopes: []string{"scope4"}}
+		rq.NoError(store.CreateRole(ctx, role))
+
+		user := &datastore.User{Username: "test_user_perm_deleted_role", Password: "pwd"}
+		rq.NoError(store.CreateUser(ctx, user))
+		rq.NoError(store.AssignRoles(ctx, user.ID, []string{role1, role.RoleName}))
+		rq.NoError(store.DeleteRoleByID(ctx, role.ID))
+
+		perm, err := store.GetUserPermissions(ctx, user.ID)
+		rq.NoError(err)
+		rq.Equal(1, len(perm.Roles))
+		rq.EqualValues(datastore.Scopes{"scope1", "scope2"}, perm.Scopes)
+	})
+
+	t.Run("deleted user", func(t *testing.T) {
+		rq := require.New(t)
+		user := &datastore.User{Username: "test_user_perm_deleted_user", Password: "pwd"}
+		rq.NoError(store.CreateUser(ctx, user))
+		rq.NoError(store.AssignRoles(ctx, user.ID, []string{role1}))
+		rq.NoError(store.DeleteUserByID(ctx, user.ID))
+
+		_, err := store.GetUserPermissions(ctx, user.ID)
+		rq.Equal(datastore.ErrorUserNotExist, err)
+	})
+}
@@ -0,0 +1,77 @@
+package datastore
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/hanzezhenalex/auth/src"
+)
+
+/*
+	Drivers
+
+	The backends import this package, so it can not construct them itself.
+	Instead each backend registers an OpenFunc under its driver name in
+	init(), the same way database/sql drivers do. Import the backend, or
+	datastore/all for every one of them, before calling Open.
+*/
+
+var ErrorUnknownDriver = errors.New("unknown datastore driver")
+
+type OpenFunc func(cfg src.Config) (Datastore, error)
+
+var (
+	driversMu sync.RWMutex
+	drivers   = make(map[string]OpenFunc)
+)
+
+// Register makes a backend available under the driver name. It panics if
+// open is nil or the name is registered twice.
+func Register(driver string, open OpenFunc) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+
+	if open == nil {
+		panic("datastore: register a nil driver " + driver)
+	}
+	if _, ok := drivers[driver]; ok {
+		panic("datastore: register driver " + driver + " twice")
+	}
+	drivers[driver] = open
+}
+
+// Drivers returns the sorted names of the registered drivers
+func Drivers() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open returns the Datastore of the driver in cfg, mysql if it is not set
+func Open(cfg src.Config) (Datastore, error) {
+	driver := cfg.Driver
+	if driver == "" {
+		driver = src.DriverMysql
+	}
+
+	driversMu.RLock()
+	open, ok := drivers[driver]
+	driversMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w %q, forgotten import?", ErrorUnknownDriver, driver)
+	}
+
+	store, err := open(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("fail to open %s datastore, %w", driver, err)
+	}
+	return store, nil
+}
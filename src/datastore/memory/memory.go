@@ -0,0 +1,1380 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hanzezhenalex/auth/src"
+	"github.com/hanzezhenalex/auth/src/datastore"
+)
+
+// memoryDatastore keeps every table in memory with the same semantics as the
+// sql backends: soft deletes, names unique among live rows, sentinel errors.
+// Rows of a table are kept in id order, deleted ones included.
+type memoryDatastore struct {
+	mu           sync.RWMutex
+	requireActor bool
+
+	userSeq    int64
+	authSeq    int64
+	roleSeq    int64
+	eventSeq   int64
+	sessionSeq int64
+	keySeq     int64
+	refreshSeq int64
+
+	users            []*datastore.User
+	auths            []*datastore.Authority
+	roles            []*datastore.Role
+	roleScopes       []*datastore.RoleScope
+	roleBindings     []*datastore.RoleBinding
+	userRoleBindings []*datastore.UserRoleBinding
+	auditEvents      []*datastore.AuditEvent
+	sessions         []*datastore.Session
+	signingKeys      []*datastore.SigningKey
+	refreshTokens    []*datastore.RefreshToken
+}
+
+func NewMemoryDatastore() *memoryDatastore {
+	return &memoryDatastore{}
+}
+
+func init() {
+	datastore.Register(src.DriverMemory, func(cfg src.Config) (datastore.Datastore, error) {
+		store := NewMemoryDatastore()
+		store.requireActor = cfg.RequireActor
+		return store, nil
+	})
+}
+
+func now() time.Time {
+	return time.Now()
+}
+
+// touch records who updated a role or an authority, and when
+func touch(updatedBy *string, updatedAt *time.Time, actor string) {
+	*updatedBy = actor
+	*updatedAt = now()
+}
+
+func copyUser(user *datastore.User) *datastore.User {
+	c := *user
+	return &c
+}
+
+func copyAuth(auth *datastore.Authority) *datastore.Authority {
+	c := *auth
+	return &c
+}
+
+func copyRole(role *datastore.Role) *datastore.Role {
+	c := *role
+	c.Scopes = append(datastore.Scopes(nil), role.Scopes...)
+	c.Auths = append([]string(nil), role.Auths...)
+	return &c
+}
+
+/*
+	User
+*/
+
+func (store *memoryDatastore) liveUser(id int64) *datastore.User {
+	for _, user := range store.users {
+		if user.ID == id && user.DeletedAt == 0 {
+			return user
+		}
+	}
+	return nil
+}
+
+func (store *memoryDatastore) liveUserByName(name string) *datastore.User {
+	for _, user := range store.users {
+		if user.Username == name && user.DeletedAt == 0 {
+			return user
+		}
+	}
+	return nil
+}
+
+func (store *memoryDatastore) CreateUser(ctx context.Context, user *datastore.User) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, err := datastore.CheckActor(ctx, store.requireActor); err != nil {
+		return err
+	}
+
+	if store.liveUserByName(user.Username) != nil {
+		return datastore.ErrorUserExist
+	}
+
+	store.userSeq++
+	user.ID = store.userSeq
+	user.CreatedAt = now()
+	user.DeletedAt = 0
+	store.users = append(store.users, copyUser(user))
+	return store.audit(ctx, datastore.AuditCreate, datastore.AuditTargetUser, user.ID,
+		nil, datastore.AuditDiff{"user_name": user.Username})
+}
+
+func (store *memoryDatastore) DeleteUserByID(ctx context.Context, id int64) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, err := datastore.CheckActor(ctx, store.requireActor); err != nil {
+		return err
+	}
+
+	user := store.liveUser(id)
+	if user == nil {
+		return datastore.ErrorUserNotExist
+	}
+
+	deletedAt := now().UnixNano()
+	for _, urb := range store.userRoleBindings {
+		if urb.UserID == id && urb.DeletedAt == 0 {
+			urb.DeletedAt = deletedAt
+		}
+	}
+	for _, sess := range store.sessions {
+		if sess.UserID == id && sess.DeletedAt == 0 {
+			sess.DeletedAt = deletedAt
+		}
+	}
+	for _, token := range store.refreshTokens {
+		if token.UserID == id && token.DeletedAt == 0 {
+			token.DeletedAt = deletedAt
+		}
+	}
+	user.DeletedAt = deletedAt
+	return store.audit(ctx, datastore.AuditDelete, datastore.AuditTargetUser, id,
+		datastore.AuditDiff{"user_name": user.Username}, nil)
+}
+
+func (store *memoryDatastore) GetUserByID(_ context.Context, id int64) (*datastore.User, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	user := store.liveUser(id)
+	if user == nil {
+		return nil, datastore.ErrorUserNotExist
+	}
+	return copyUser(user), nil
+}
+
+func (store *memoryDatastore) GetUserByName(_ context.Context, name string) (*datastore.User, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	user := store.liveUserByName(name)
+	if user == nil {
+		return nil, datastore.ErrorUserNotExist
+	}
+	return copyUser(user), nil
+}
+
+func (store *memoryDatastore) UpdateUser(ctx context.Context, user *datastore.User) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, err := datastore.CheckActor(ctx, store.requireActor); err != nil {
+		return err
+	}
+
+	origin := store.liveUser(user.ID)
+	if origin == nil {
+		return datastore.ErrorUserNotExist
+	}
+	if other := store.liveUserByName(user.Username); other != nil && other.ID != user.ID {
+		return datastore.ErrorUserExist
+	}
+
+	before, after := datastore.UserAuditDiff(origin, user)
+	origin.Username = user.Username
+	origin.Password = user.Password
+	origin.Reserve = user.Reserve
+	if len(after) == 0 {
+		return nil
+	}
+	return store.audit(ctx, datastore.AuditUpdate, datastore.AuditTargetUser, user.ID, before, after)
+}
+
+/*
+	User Role
+*/
+
+// userRoles returns the active roles of a user
+func (store *memoryDatastore) userRoles(userID int64) []*datastore.Role {
+	var roles []*datastore.Role
+	for _, urb := range store.userRoleBindings {
+		if urb.UserID != userID || urb.DeletedAt != 0 {
+			continue
+		}
+		if role := store.liveRole(urb.RoleID); role != nil {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+func roleNames(roles []*datastore.Role) []string {
+	names := make([]string, 0, len(roles))
+	for _, role := range roles {
+		names = append(names, role.RoleName)
+	}
+	return names
+}
+
+func (store *memoryDatastore) AssignRoles(ctx context.Context, userID int64, roles []string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, err := datastore.CheckActor(ctx, store.requireActor); err != nil {
+		return err
+	}
+
+	if store.liveUser(userID) == nil {
+		return datastore.ErrorUserNotExist
+	}
+
+	_, toAssign := src.SliceRemove(roleNames(store.userRoles(userID)), src.SliceUnique(roles))
+
+	found := make([]*datastore.Role, 0, len(toAssign))
+	for _, name := range toAssign {
+		role := store.liveRoleByName(name)
+		if role == nil {
+			return datastore.ErrorRoleNotExist
+		}
+		found = append(found, role)
+	}
+
+	createdAt := now()
+	for _, role := range found {
+		store.userRoleBindings = append(store.userRoleBindings, &datastore.UserRoleBinding{
+			UserID:    userID,
+			RoleID:    role.ID,
+			RoleName:  role.RoleName,
+			CreatedAt: createdAt,
+		})
+	}
+	if len(found) == 0 {
+		return nil
+	}
+	return store.audit(ctx, datastore.AuditAssignRoles, datastore.AuditTargetUser, userID,
+		nil, datastore.AuditDiff{}.WithList("roles", toAssign))
+}
+
+func (store *memoryDatastore) UnassignRoles(ctx context.Context, userID int64, roles []string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, err := datastore.CheckActor(ctx, store.requireActor); err != nil {
+		return err
+	}
+
+	if store.liveUser(userID) == nil {
+		return datastore.ErrorUserNotExist
+	}
+
+	roles = src.SliceUnique(roles)
+	_, nonExisted := src.SliceRemove(roleNames(store.userRoles(userID)), roles)
+	if len(nonExisted) > 0 {
+		return datastore.ErrorUnassignNonExistedRoles
+	}
+
+	deletedAt := now().UnixNano()
+	for _, urb := range store.userRoleBindings {
+		if urb.UserID != userID || urb.DeletedAt != 0 {
+			continue
+		}
+		for _, name := range roles {
+			if urb.RoleName == name {
+				urb.DeletedAt = deletedAt
+			}
+		}
+	}
+	if len(roles) == 0 {
+		return nil
+	}
+	return store.audit(ctx, datastore.AuditUnassignRoles, datastore.AuditTargetUser, userID,
+		datastore.AuditDiff{}.WithList("roles", roles), nil)
+}
+
+func (store *memoryDatastore) ListUserRoles(_ context.Context, userID int64) ([]string, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	if store.liveUser(userID) == nil {
+		return nil, datastore.ErrorUserNotExist
+	}
+
+	names := roleNames(store.userRoles(userID))
+	src.SortSliceAsc(names)
+	return names, nil
+}
+
+func (store *memoryDatastore) GetUserPermissions(_ context.Context, userID int64) (*datastore.Permission, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	if store.liveUser(userID) == nil {
+		return nil, datastore.ErrorUserNotExist
+	}
+
+	var perm datastore.Permission
+	for _, role := range store.userRoles(userID) {
+		granted := &datastore.Role{
+			ID:       role.ID,
+			RoleName: role.RoleName,
+			Scopes:   store.roleScopeNames(role),
+			Auths:    store.roleAuthNames(role.ID),
+		}
+		src.SortSliceAsc(granted.Auths)
+
+		perm.Roles = append(perm.Roles, granted)
+		perm.Scopes, _ = src.SliceAppend(perm.Scopes, granted.Scopes)
+		perm.Auths, _ = src.SliceAppend(perm.Auths, granted.Auths)
+	}
+
+	sort.Slice(perm.Roles, func(i, j int) bool {
+		return perm.Roles[i].RoleName < perm.Roles[j].RoleName
+	})
+	src.SortSliceAsc(perm.Scopes, perm.Auths)
+	return &perm, nil
+}
+
+/*
+	Authority
+*/
+
+func (store *memoryDatastore) liveAuth(id int64) *datastore.Authority {
+	for _, auth := range store.auths {
+		if auth.ID == id && auth.DeletedAt == 0 {
+			return auth
+		}
+	}
+	return nil
+}
+
+func (store *memoryDatastore) liveAuthByName(name string) *datastore.Authority {
+	for _, auth := range store.auths {
+		if auth.AuthName == name && auth.DeletedAt == 0 {
+			return auth
+		}
+	}
+	return nil
+}
+
+func (store *memoryDatastore) CreateAuthority(ctx context.Context, auth *datastore.Authority) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	actor, err := datastore.CheckActor(ctx, store.requireActor)
+	if err != nil {
+		return err
+	}
+
+	if store.liveAuthByName(auth.AuthName) != nil {
+		return datastore.ErrorAuthExist
+	}
+
+	if actor != "" {
+		auth.CreatedBy = actor
+	}
+	store.authSeq++
+	auth.ID = store.authSeq
+	auth.CreatedAt = now()
+	auth.UpdatedBy = auth.CreatedBy
+	auth.UpdatedAt = auth.CreatedAt
+	auth.DeletedAt = 0
+	store.auths = append(store.auths, copyAuth(auth))
+	return store.audit(ctx, datastore.AuditCreate, datastore.AuditTargetAuthority, auth.ID,
+		nil, datastore.AuditDiff{"authority_name": auth.AuthName})
+}
+
+// DeleteAuthorityByID soft delete
+func (store *memoryDatastore) DeleteAuthorityByID(ctx context.Context, id int64, force bool) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	actor, err := datastore.CheckActor(ctx, store.requireActor)
+	if err != nil {
+		return err
+	}
+
+	if !force {
+		for _, rb := range store.roleBindings {
+			if rb.AuthID == id && rb.DeletedAt == 0 {
+				return datastore.ErrorDeleteAuthWithBinding
+			}
+		}
+	}
+
+	auth := store.liveAuth(id)
+	if auth == nil {
+		return datastore.ErrorAuthNotExist
+	}
+	auth.DeletedAt = now().UnixNano()
+	touch(&auth.UpdatedBy, &auth.UpdatedAt, actor)
+
+	action := datastore.AuditDelete
+	if force {
+		action = datastore.AuditForceDelete
+	}
+	return store.audit(ctx, action, datastore.AuditTargetAuthority, id,
+		datastore.AuditDiff{"authority_name": auth.AuthName}, nil)
+}
+
+func (store *memoryDatastore) RestoreAuthorityByID(ctx context.Context, id int64) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	actor, err := datastore.CheckActor(ctx, store.requireActor)
+	if err != nil {
+		return err
+	}
+
+	var auth *datastore.Authority
+	for _, a := range store.auths {
+		if a.ID == id {
+			auth = a
+		}
+	}
+	if auth == nil {
+		return datastore.ErrorAuthNotExist
+	}
+	if auth.DeletedAt == 0 {
+		return nil
+	}
+
+	if store.liveAuthByName(auth.AuthName) != nil {
+		return datastore.ErrorAuthExist
+	}
+	auth.DeletedAt = 0
+	touch(&auth.UpdatedBy, &auth.UpdatedAt, actor)
+	return store.audit(ctx, datastore.AuditRestore, datastore.AuditTargetAuthority, id,
+		nil, datastore.AuditDiff{"authority_name": auth.AuthName})
+}
+
+func (store *memoryDatastore) GetAuthorityByID(_ context.Context, id int64) (*datastore.Authority, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	auth := store.liveAuth(id)
+	if auth == nil {
+		return nil, datastore.ErrorAuthNotExist
+	}
+	return copyAuth(auth), nil
+}
+
+func (store *memoryDatastore) GetAuthorityByName(_ context.Context, name string) (*datastore.Authority, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	auth := store.liveAuthByName(name)
+	if auth == nil {
+		return nil, datastore.ErrorAuthNotExist
+	}
+	return copyAuth(auth), nil
+}
+
+func (store *memoryDatastore) ListAuthorities(
+	_ context.Context,
+	opt datastore.ListOption,
+) ([]*datastore.Authority, string, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	after, err := opt.After()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var auths []*datastore.Authority
+	for _, auth := range store.auths {
+		if auth.ID > after && matchList(opt, auth.AuthName, auth.CreatedBy, auth.DeletedAt) {
+			auths = append(auths, copyAuth(auth))
+		}
+	}
+
+	var next string
+	if limit := opt.Limit(); len(auths) > limit {
+		auths = auths[:limit]
+		next = datastore.NextPageToken(auths[limit-1].ID)
+	}
+	return auths, next, nil
+}
+
+func matchList(opt datastore.ListOption, name string, createdBy string, deletedAt int64) bool {
+	return (opt.IncludeDeleted || deletedAt == 0) &&
+		strings.HasPrefix(name, opt.NamePrefix) &&
+		(opt.CreatedBy == "" || opt.CreatedBy == createdBy)
+}
+
+/*
+	Role
+*/
+
+func (store *memoryDatastore) liveRole(id int64) *datastore.Role {
+	for _, role := range store.roles {
+		if role.ID == id && role.DeletedAt == 0 {
+			return role
+		}
+	}
+	return nil
+}
+
+func (store *memoryDatastore) liveRoleByName(name string) *datastore.Role {
+	for _, role := range store.roles {
+		if role.RoleName == name && role.DeletedAt == 0 {
+			return role
+		}
+	}
+	return nil
+}
+
+// activeRoleBindings returns the active bindings of a role, ignoring deleted authorities
+func (store *memoryDatastore) activeRoleBindings(roleID int64) []*datastore.RoleBinding {
+	var rbs []*datastore.RoleBinding
+	for _, rb := range store.roleBindings {
+		if rb.RoleID == roleID && rb.DeletedAt == 0 && store.liveAuth(rb.AuthID) != nil {
+			rbs = append(rbs, rb)
+		}
+	}
+	return rbs
+}
+
+func (store *memoryDatastore) roleAuthNames(roleID int64) []string {
+	var names []string
+	for _, rb := range store.activeRoleBindings(roleID) {
+		names = append(names, rb.AuthName)
+	}
+	return names
+}
+
+// bindAuths binds the named authorities to a role, all of them must exist
+func (store *memoryDatastore) bindAuths(roleID int64, names []string) error {
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+
+	var auths []*datastore.Authority
+	for _, auth := range store.auths {
+		if auth.DeletedAt == 0 && wanted[auth.AuthName] {
+			auths = append(auths, auth)
+		}
+	}
+	if len(auths) != len(names) {
+		return datastore.ErrorAuthNotExist
+	}
+
+	createdAt := now()
+	for _, auth := range auths {
+		store.roleBindings = append(store.roleBindings, &datastore.RoleBinding{
+			RoleID:    roleID,
+			AuthID:    auth.ID,
+			AuthName:  auth.AuthName,
+			CreatedAt: createdAt,
+		})
+	}
+	return nil
+}
+
+func (store *memoryDatastore) CreateRole(ctx context.Context, role *datastore.Role) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	actor, err := datastore.CheckActor(ctx, store.requireActor)
+	if err != nil {
+		return err
+	}
+
+	if store.liveRoleByName(role.RoleName) != nil {
+		return datastore.ErrorRoleExist
+	}
+
+	store.roleSeq++
+	id := store.roleSeq
+	if err := store.bindAuths(id, role.Auths); err != nil {
+		return err
+	}
+
+	if actor != "" {
+		role.CreatedBy = actor
+	}
+	role.ID = id
+	role.CreatedAt = now()
+	role.UpdatedBy = role.CreatedBy
+	role.UpdatedAt = role.CreatedAt
+	role.DeletedAt = 0
+
+	store.insertRoleScopes(id, src.SliceUnique(role.Scopes))
+
+	stored := copyRole(role)
+	stored.Scopes = nil
+	stored.Auths = nil
+	store.roles = append(store.roles, stored)
+
+	after := datastore.AuditDiff{"role_name": role.RoleName}.
+		WithList("scopes", src.SliceUnique(role.Scopes)).
+		WithList("auths", role.Auths)
+	return store.audit(ctx, datastore.AuditCreate, datastore.AuditTargetRole, id, nil, after)
+}
+
+func (store *memoryDatastore) DeleteRoleByID(ctx context.Context, id int64) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	actor, err := datastore.CheckActor(ctx, store.requireActor)
+	if err != nil {
+		return err
+	}
+
+	role := store.liveRole(id)
+	if role == nil {
+		return datastore.ErrorRoleNotExist
+	}
+
+	deletedAt := now().UnixNano()
+	for _, rb := range store.roleBindings {
+		if rb.RoleID == id && rb.DeletedAt == 0 {
+			rb.DeletedAt = deletedAt
+		}
+	}
+	for _, urb := range store.userRoleBindings {
+		if urb.RoleID == id && urb.DeletedAt == 0 {
+			urb.DeletedAt = deletedAt
+		}
+	}
+	for _, rs := range store.roleScopes {
+		if rs.RoleID == id && rs.DeletedAt == 0 {
+			rs.DeletedAt = deletedAt
+		}
+	}
+	role.DeletedAt = deletedAt
+	touch(&role.UpdatedBy, &role.UpdatedAt, actor)
+	return store.audit(ctx, datastore.AuditDelete, datastore.AuditTargetRole, id,
+		datastore.AuditDiff{"role_name": role.RoleName}, nil)
+}
+
+func (store *memoryDatastore) RestoreRoleByID(ctx context.Context, id int64) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	actor, err := datastore.CheckActor(ctx, store.requireActor)
+	if err != nil {
+		return err
+	}
+
+	var role *datastore.Role
+	for _, r := range store.roles {
+		if r.ID == id {
+			role = r
+		}
+	}
+	if role == nil {
+		return datastore.ErrorRoleNotExist
+	}
+	if role.DeletedAt == 0 {
+		return nil
+	}
+
+	if store.liveRoleByName(role.RoleName) != nil {
+		return datastore.ErrorRoleExist
+	}
+	for _, rb := range store.roleBindings {
+		if rb.RoleID == id && rb.DeletedAt == role.DeletedAt {
+			rb.DeletedAt = 0
+		}
+	}
+	for _, rs := range store.roleScopes {
+		if rs.RoleID == id && rs.DeletedAt == role.DeletedAt {
+			rs.DeletedAt = 0
+		}
+	}
+	role.DeletedAt = 0
+	touch(&role.UpdatedBy, &role.UpdatedAt, actor)
+	return store.audit(ctx, datastore.AuditRestore, datastore.AuditTargetRole, id,
+		nil, datastore.AuditDiff{"role_name": role.RoleName})
+}
+
+// roleScopeNames returns the sorted scopes of a role, the ones deleted
+// along with it for a deleted role
+func (store *memoryDatastore) roleScopeNames(role *datastore.Role) []string {
+	var scopes []string
+	for _, rs := range store.roleScopes {
+		if rs.RoleID == role.ID && rs.DeletedAt == role.DeletedAt {
+			scopes = append(scopes, rs.Scope)
+		}
+	}
+	src.SortSliceAsc(scopes)
+	return scopes
+}
+
+func (store *memoryDatastore) insertRoleScopes(roleID int64, scopes []string) {
+	createdAt := now()
+	for _, scope := range scopes {
+		store.roleScopes = append(store.roleScopes, &datastore.RoleScope{
+			RoleID:    roleID,
+			Scope:     scope,
+			CreatedAt: createdAt,
+		})
+	}
+}
+
+func (store *memoryDatastore) getRole(role *datastore.Role) *datastore.Role {
+	c := copyRole(role)
+	c.Scopes = store.roleScopeNames(role)
+	c.Auths = store.roleAuthNames(role.ID)
+	return c
+}
+
+func (store *memoryDatastore) GetRoleByID(_ context.Context, id int64) (*datastore.Role, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	role := store.liveRole(id)
+	if role == nil {
+		return nil, datastore.ErrorRoleNotExist
+	}
+	return store.getRole(role), nil
+}
+
+func (store *memoryDatastore) GetRoleByName(_ context.Context, name string) (*datastore.Role, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	role := store.liveRoleByName(name)
+	if role == nil {
+		return nil, datastore.ErrorRoleNotExist
+	}
+	return store.getRole(role), nil
+}
+
+func (store *memoryDatastore) ListRoles(_ context.Context, opt datastore.ListOption) ([]*datastore.Role, string, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	after, err := opt.After()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var roles []*datastore.Role
+	for _, role := range store.roles {
+		if role.ID > after && matchList(opt, role.RoleName, role.CreatedBy, role.DeletedAt) {
+			c := store.getRole(role)
+			src.SortSliceAsc(c.Auths)
+			roles = append(roles, c)
+		}
+	}
+
+	var next string
+	if limit := opt.Limit(); len(roles) > limit {
+		roles = roles[:limit]
+		next = datastore.NextPageToken(roles[limit-1].ID)
+	}
+	return roles, next, nil
+}
+
+func (store *memoryDatastore) ListRolesByAuthority(_ context.Context, authID int64) ([]*datastore.Role, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	if store.liveAuth(authID) == nil {
+		return nil, datastore.ErrorAuthNotExist
+	}
+
+	return store.findRoles(func(role *datastore.Role) bool {
+		for _, rb := range store.activeRoleBindings(role.ID) {
+			if rb.AuthID == authID {
+				return true
+			}
+		}
+		return false
+	}), nil
+}
+
+func (store *memoryDatastore) ListRolesByScope(_ context.Context, scope string) ([]*datastore.Role, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	return store.findRoles(func(role *datastore.Role) bool {
+		for _, rs := range store.roleScopes {
+			if rs.RoleID == role.ID && rs.DeletedAt == 0 && rs.Scope == scope {
+				return true
+			}
+		}
+		return false
+	}), nil
+}
+
+// findRoles returns the live roles matching fn in id order
+func (store *memoryDatastore) findRoles(fn func(role *datastore.Role) bool) []*datastore.Role {
+	var roles []*datastore.Role
+	for _, role := range store.roles {
+		if role.DeletedAt == 0 && fn(role) {
+			c := store.getRole(role)
+			src.SortSliceAsc(c.Auths)
+			roles = append(roles, c)
+		}
+	}
+	return roles
+}
+
+func (store *memoryDatastore) UpdateScopesByID(ctx context.Context, id int64, op datastore.UpdateRoleScopeOption) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	actor, err := datastore.CheckActor(ctx, store.requireActor)
+	if err != nil {
+		return err
+	}
+
+	role := store.liveRole(id)
+	if role == nil {
+		return datastore.ErrorRoleNotExist
+	}
+
+	assigned := store.roleScopeNames(role)
+	scopesAppend, _ := src.SliceAppend(append([]string(nil), assigned...), src.SliceUnique(op.Assign))
+	scopesRemoved, nonExisted := src.SliceRemove(scopesAppend, src.SliceUnique(op.Unassign))
+	if len(nonExisted) > 0 {
+		return datastore.ErrorUnassignNonExistedScopes
+	}
+
+	toInsert, _ := src.SliceRemove(scopesRemoved, assigned)
+	toDelete, _ := src.SliceRemove(assigned, scopesRemoved)
+
+	store.insertRoleScopes(id, toInsert)
+	deleted := make(map[string]bool, len(toDelete))
+	for _, scope := range toDelete {
+		deleted[scope] = true
+	}
+	deletedAt := now().UnixNano()
+	for _, rs := range store.roleScopes {
+		if rs.RoleID == id && rs.DeletedAt == 0 && deleted[rs.Scope] {
+			rs.DeletedAt = deletedAt
+		}
+	}
+
+	if len(toInsert) == 0 && len(toDelete) == 0 {
+		return nil
+	}
+	touch(&role.UpdatedBy, &role.UpdatedAt, actor)
+	return store.audit(ctx, datastore.AuditUpdateScopes, datastore.AuditTargetRole, id,
+		datastore.AuditDiff{}.WithList("scopes", toDelete),
+		datastore.AuditDiff{}.WithList("scopes", toInsert))
+}
+
+func (store *memoryDatastore) UpdateRoleAuthsByID(ctx context.Context, id int64, op datastore.UpdateRoleAuthOption) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	actor, err := datastore.CheckActor(ctx, store.requireActor)
+	if err != nil {
+		return err
+	}
+
+	role := store.liveRole(id)
+	if role == nil {
+		return datastore.ErrorRoleNotExist
+	}
+
+	current := store.roleAuthNames(id)
+	authsAppend, _ := src.SliceAppend(append([]string(nil), current...), src.SliceUnique(op.Assign))
+	authsRemoved, nonExisted := src.SliceRemove(authsAppend, src.SliceUnique(op.Unassign))
+	if len(nonExisted) > 0 {
+		return datastore.ErrorUnassignNonExistedAuths
+	}
+	_, toBind := src.SliceRemove(current, authsRemoved)
+	toUnbind, _ := src.SliceRemove(current, authsRemoved)
+
+	if err := store.bindAuths(id, toBind); err != nil {
+		return err
+	}
+
+	deletedAt := now().UnixNano()
+	for _, rb := range store.activeRoleBindings(id) {
+		for _, name := range toUnbind {
+			if rb.AuthName == name {
+				rb.DeletedAt = deletedAt
+			}
+		}
+	}
+
+	if len(toBind) == 0 && len(toUnbind) == 0 {
+		return nil
+	}
+	touch(&role.UpdatedBy, &role.UpdatedAt, actor)
+	return store.audit(ctx, datastore.AuditUpdateAuths, datastore.AuditTargetRole, id,
+		datastore.AuditDiff{}.WithList("auths", toUnbind),
+		datastore.AuditDiff{}.WithList("auths", toBind))
+}
+
+/*
+	Session
+*/
+
+func (store *memoryDatastore) liveSession(fn func(sess *datastore.Session) bool) *datastore.Session {
+	for _, sess := range store.sessions {
+		if sess.DeletedAt == 0 && fn(sess) {
+			return sess
+		}
+	}
+	return nil
+}
+
+func (store *memoryDatastore) CreateSession(ctx context.Context, sess *datastore.Session) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, err := datastore.CheckActor(ctx, store.requireActor); err != nil {
+		return err
+	}
+
+	if store.liveUser(sess.UserID) == nil {
+		return datastore.ErrorUserNotExist
+	}
+
+	store.sessionSeq++
+	sess.ID = store.sessionSeq
+	sess.CreatedAt = now()
+	sess.DeletedAt = 0
+	c := *sess
+	store.sessions = append(store.sessions, &c)
+	return store.audit(ctx, datastore.AuditCreate, datastore.AuditTargetSession, sess.ID,
+		nil, datastore.AuditDiff{"user_id": sess.UserID})
+}
+
+func (store *memoryDatastore) GetSessionByTokenHash(_ context.Context, hash string) (*datastore.Session, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	sess := store.liveSession(func(sess *datastore.Session) bool {
+		return sess.TokenHash == hash
+	})
+	if sess == nil {
+		return nil, datastore.ErrorSessionNotExist
+	}
+	c := *sess
+	return &c, nil
+}
+
+func (store *memoryDatastore) TouchSession(_ context.Context, id int64, seenAt time.Time) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	sess := store.liveSession(func(sess *datastore.Session) bool {
+		return sess.ID == id
+	})
+	if sess == nil {
+		return datastore.ErrorSessionNotExist
+	}
+	sess.LastSeenAt = seenAt.UnixNano()
+	return nil
+}
+
+func (store *memoryDatastore) RevokeSessionByID(ctx context.Context, id int64) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, err := datastore.CheckActor(ctx, store.requireActor); err != nil {
+		return err
+	}
+
+	sess := store.liveSession(func(sess *datastore.Session) bool {
+		return sess.ID == id
+	})
+	if sess == nil {
+		return datastore.ErrorSessionNotExist
+	}
+	sess.DeletedAt = now().UnixNano()
+	return store.audit(ctx, datastore.AuditDelete, datastore.AuditTargetSession, id,
+		datastore.AuditDiff{"user_id": sess.UserID}, nil)
+}
+
+func (store *memoryDatastore) ListUserSessions(_ context.Context, userID int64) ([]*datastore.Session, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	if store.liveUser(userID) == nil {
+		return nil, datastore.ErrorUserNotExist
+	}
+
+	var sessions []*datastore.Session
+	for _, sess := range store.sessions {
+		if sess.UserID == userID && sess.DeletedAt == 0 {
+			c := *sess
+			sessions = append(sessions, &c)
+		}
+	}
+	return sessions, nil
+}
+
+/*
+	Signing Key
+*/
+
+func (store *memoryDatastore) liveSigningKey(id int64) *datastore.SigningKey {
+	for _, key := range store.signingKeys {
+		if key.ID == id && key.DeletedAt == 0 {
+			return key
+		}
+	}
+	return nil
+}
+
+func (store *memoryDatastore) CreateSigningKey(ctx context.Context, key *datastore.SigningKey) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, err := datastore.CheckActor(ctx, store.requireActor); err != nil {
+		return err
+	}
+
+	// kids are unique among the deleted keys too
+	for _, existed := range store.signingKeys {
+		if existed.KeyID == key.KeyID {
+			return datastore.ErrorSigningKeyExist
+		}
+	}
+
+	store.keySeq++
+	key.ID = store.keySeq
+	key.CreatedAt = now()
+	key.DeletedAt = 0
+	c := *key
+	store.signingKeys = append(store.signingKeys, &c)
+	return store.audit(ctx, datastore.AuditCreate, datastore.AuditTargetSigningKey, key.ID,
+		nil, datastore.AuditDiff{"kid": key.KeyID, "algorithm": key.Algorithm})
+}
+
+func (store *memoryDatastore) ListSigningKeys(_ context.Context) ([]*datastore.SigningKey, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	var keys []*datastore.SigningKey
+	for _, key := range store.signingKeys {
+		if key.DeletedAt == 0 {
+			c := *key
+			keys = append(keys, &c)
+		}
+	}
+	return keys, nil
+}
+
+func (store *memoryDatastore) RetireSigningKey(ctx context.Context, id int64, retiredAt time.Time) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, err := datastore.CheckActor(ctx, store.requireActor); err != nil {
+		return err
+	}
+
+	key := store.liveSigningKey(id)
+	if key == nil {
+		return datastore.ErrorSigningKeyNotExist
+	}
+	if key.RetiredAt != 0 {
+		return nil
+	}
+	key.RetiredAt = retiredAt.UnixNano()
+	return store.audit(ctx, datastore.AuditRetire, datastore.AuditTargetSigningKey, id,
+		nil, datastore.AuditDiff{"kid": key.KeyID})
+}
+
+func (store *memoryDatastore) DeleteSigningKeyByID(ctx context.Context, id int64) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, err := datastore.CheckActor(ctx, store.requireActor); err != nil {
+		return err
+	}
+
+	key := store.liveSigningKey(id)
+	if key == nil {
+		return datastore.ErrorSigningKeyNotExist
+	}
+	key.DeletedAt = now().UnixNano()
+	return store.audit(ctx, datastore.AuditDelete, datastore.AuditTargetSigningKey, id,
+		datastore.AuditDiff{"kid": key.KeyID}, nil)
+}
+
+/*
+	Refresh Token
+*/
+
+func (store *memoryDatastore) liveRefreshToken(fn func(token *datastore.RefreshToken) bool) *datastore.RefreshToken {
+	for _, token := range store.refreshTokens {
+		if token.DeletedAt == 0 && fn(token) {
+			return token
+		}
+	}
+	return nil
+}
+
+func (store *memoryDatastore) insertRefreshToken(token *datastore.RefreshToken) {
+	store.refreshSeq++
+	token.ID = store.refreshSeq
+	token.CreatedAt = now()
+	token.UsedAt = 0
+	token.DeletedAt = 0
+	c := *token
+	store.refreshTokens = append(store.refreshTokens, &c)
+}
+
+func (store *memoryDatastore) CreateRefreshToken(ctx context.Context, token *datastore.RefreshToken) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, err := datastore.CheckActor(ctx, store.requireActor); err != nil {
+		return err
+	}
+
+	if store.liveUser(token.UserID) == nil {
+		return datastore.ErrorUserNotExist
+	}
+
+	// the family is the id of its first token
+	token.FamilyID = store.refreshSeq + 1
+	store.insertRefreshToken(token)
+	return store.audit(ctx, datastore.AuditCreate, datastore.AuditTargetRefreshToken, token.ID,
+		nil, datastore.AuditDiff{"user_id": token.UserID, "family_id": token.FamilyID})
+}
+
+func (store *memoryDatastore) GetRefreshTokenByTokenHash(_ context.Context, hash string) (*datastore.RefreshToken, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	token := store.liveRefreshToken(func(token *datastore.RefreshToken) bool {
+		return token.TokenHash == hash
+	})
+	if token == nil {
+		return nil, datastore.ErrorRefreshTokenNotExist
+	}
+	c := *token
+	return &c, nil
+}
+
+func (store *memoryDatastore) RotateRefreshToken(
+	ctx context.Context,
+	id int64,
+	usedAt time.Time,
+	next *datastore.RefreshToken,
+) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, err := datastore.CheckActor(ctx, store.requireActor); err != nil {
+		return err
+	}
+
+	token := store.liveRefreshToken(func(token *datastore.RefreshToken) bool {
+		return token.ID == id
+	})
+	if token == nil {
+		return datastore.ErrorRefreshTokenNotExist
+	}
+	if store.liveUser(token.UserID) == nil {
+		return datastore.ErrorUserNotExist
+	}
+	if token.UsedAt != 0 {
+		return datastore.ErrorRefreshTokenUsed
+	}
+
+	token.UsedAt = usedAt.UnixNano()
+	next.UserID = token.UserID
+	next.FamilyID = token.FamilyID
+	store.insertRefreshToken(next)
+	return store.audit(ctx, datastore.AuditRotate, datastore.AuditTargetRefreshToken, id,
+		nil, datastore.AuditDiff{"family_id": token.FamilyID, "next_id": next.ID})
+}
+
+func (store *memoryDatastore) RevokeRefreshTokenFamily(ctx context.Context, familyID int64) (int64, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, err := datastore.CheckActor(ctx, store.requireActor); err != nil {
+		return 0, err
+	}
+
+	var (
+		n      int64
+		userID int64
+	)
+	deletedAt := now().UnixNano()
+	for _, token := range store.refreshTokens {
+		if token.FamilyID == familyID && token.DeletedAt == 0 {
+			token.DeletedAt = deletedAt
+			userID = token.UserID
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return n, store.audit(ctx, datastore.AuditRevoke, datastore.AuditTargetRefreshToken, familyID,
+		datastore.AuditDiff{"user_id": userID, "tokens": n}, nil)
+}
+
+func (store *memoryDatastore) RevokeUserRefreshTokens(ctx context.Context, userID int64) (int64, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, err := datastore.CheckActor(ctx, store.requireActor); err != nil {
+		return 0, err
+	}
+
+	if store.liveUser(userID) == nil {
+		return 0, datastore.ErrorUserNotExist
+	}
+
+	var n int64
+	deletedAt := now().UnixNano()
+	for _, token := range store.refreshTokens {
+		if token.UserID == userID && token.DeletedAt == 0 {
+			token.DeletedAt = deletedAt
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return n, store.audit(ctx, datastore.AuditRevoke, datastore.AuditTargetUser, userID,
+		datastore.AuditDiff{"refresh_tokens": n}, nil)
+}
+
+/*
+	Maintenance
+*/
+
+func (store *memoryDatastore) Purge(ctx context.Context, olderThan time.Duration) (*datastore.PurgeReport, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, err := datastore.CheckActor(ctx, store.requireActor); err != nil {
+		return nil, err
+	}
+
+	if olderThan < 0 {
+		olderThan = 0
+	}
+	cutoff := now().Add(-olderThan).UnixNano()
+	expired := func(deletedAt int64) bool {
+		return deletedAt > 0 && deletedAt <= cutoff
+	}
+
+	var report datastore.PurgeReport
+	users := make(map[int64]bool)
+	store.users = filter(store.users, func(user *datastore.User) bool {
+		users[user.ID] = expired(user.DeletedAt)
+		return users[user.ID]
+	}, &report.Users)
+	roles := make(map[int64]bool)
+	store.roles = filter(store.roles, func(role *datastore.Role) bool {
+		roles[role.ID] = expired(role.DeletedAt)
+		return roles[role.ID]
+	}, &report.Roles)
+	auths := make(map[int64]bool)
+	store.auths = filter(store.auths, func(auth *datastore.Authority) bool {
+		auths[auth.ID] = expired(auth.DeletedAt)
+		return auths[auth.ID]
+	}, &report.Authorities)
+
+	store.userRoleBindings = filter(store.userRoleBindings, func(urb *datastore.UserRoleBinding) bool {
+		return expired(urb.DeletedAt) || users[urb.UserID] || roles[urb.RoleID]
+	}, &report.UserRoleBindings)
+	store.roleBindings = filter(store.roleBindings, func(rb *datastore.RoleBinding) bool {
+		return expired(rb.DeletedAt) || roles[rb.RoleID] || auths[rb.AuthID]
+	}, &report.RoleBindings)
+	store.roleScopes = filter(store.roleScopes, func(rs *datastore.RoleScope) bool {
+		return expired(rs.DeletedAt) || roles[rs.RoleID]
+	}, &report.RoleScopes)
+	store.sessions = filter(store.sessions, func(sess *datastore.Session) bool {
+		return expired(sess.DeletedAt) || sess.ExpiresAt <= cutoff || users[sess.UserID]
+	}, &report.Sessions)
+	store.signingKeys = filter(store.signingKeys, func(key *datastore.SigningKey) bool {
+		return expired(key.DeletedAt)
+	}, &report.SigningKeys)
+	store.refreshTokens = filter(store.refreshTokens, func(token *datastore.RefreshToken) bool {
+		return expired(token.DeletedAt) || token.ExpiresAt <= cutoff || users[token.UserID]
+	}, &report.RefreshTokens)
+
+	if report == (datastore.PurgeReport{}) {
+		return &report, nil
+	}
+	if err := store.audit(ctx, datastore.AuditPurge, datastore.AuditTargetDatastore, 0,
+		nil, datastore.AuditDiff{"purged": &report}); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// filter removes the rows matching purged in place, and counts them
+func filter[T any](rows []T, purged func(T) bool, count *int64) []T {
+	kept := rows[:0]
+	for _, row := range rows {
+		if purged(row) {
+			*count++
+		} else {
+			kept = append(kept, row)
+		}
+	}
+	return kept
+}
+
+/*
+	Audit
+*/
+
+// audit records a mutation, the diffs never fail to encode so the events
+// are kept in step with the tables
+func (store *memoryDatastore) audit(
+	ctx context.Context,
+	action, targetType string,
+	targetID int64,
+	before, after datastore.AuditDiff,
+) error {
+	event, err := datastore.NewAuditEvent(ctx, action, targetType, targetID, before, after)
+	if err != nil {
+		return err
+	}
+
+	store.eventSeq++
+	event.ID = store.eventSeq
+	store.auditEvents = append(store.auditEvents, event)
+	return nil
+}
+
+func (store *memoryDatastore) ListAuditEvents(
+	_ context.Context,
+	opt datastore.AuditListOption,
+) ([]*datastore.AuditEvent, string, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	after, err := opt.After()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var events []*datastore.AuditEvent
+	for _, event := range store.auditEvents {
+		if event.ID > after && opt.Match(event) {
+			c := *event
+			events = append(events, &c)
+		}
+	}
+
+	var next string
+	if limit := opt.Limit(); len(events) > limit {
+		events = events[:limit]
+		next = datastore.NextPageToken(events[limit-1].ID)
+	}
+	return events, next, nil
+}
@@ -0,0 +1,45 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hanzezhenalex/auth/src/datastore"
+	"github.com/hanzezhenalex/auth/src/datastore/datastoretest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryDatastore(t *testing.T) {
+	datastoretest.RunSuite(t, func(t *testing.T) datastore.Datastore {
+		return NewMemoryDatastore()
+	})
+}
+
+func TestMemoryDatastore_Concurrency(t *testing.T) {
+	rq := require.New(t)
+	ctx := context.Background()
+	store := NewMemoryDatastore()
+
+	const workers = 16
+	var (
+		wg      sync.WaitGroup
+		created int32
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := store.CreateAuthority(ctx, &datastore.Authority{AuthName: "concurrency"}); err == nil {
+				atomic.AddInt32(&created, 1)
+			} else {
+				rq.Equal(datastore.ErrorAuthExist, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	rq.Equal(int32(1), created)
+}
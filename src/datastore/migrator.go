@@ -0,0 +1,15 @@
+package datastore
+
+import "context"
+
+// LatestSchemaVersion is the target of Migrate applying every migration
+const LatestSchemaVersion int64 = -1
+
+// Migrator is implemented by the backends with a versioned schema, the
+// in-memory one has none.
+type Migrator interface {
+	// Migrate applies or reverts migrations until the schema is at target
+	Migrate(ctx context.Context, target int64) error
+	// SchemaVersion returns the version of the live schema
+	SchemaVersion(ctx context.Context) (int64, error)
+}
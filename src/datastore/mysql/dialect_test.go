@@ -0,0 +1,20 @@
+package mysql
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMysqlDialect(t *testing.T) {
+	rq := require.New(t)
+	dialect := mysqlDialect{}
+
+	rq.True(dialect.IsDuplicated(&mysql.MySQLError{Number: duplicatedOnPrimaryKey}))
+	rq.True(dialect.IsDuplicated(fmt.Errorf("fail to insert, %w", &mysql.MySQLError{Number: duplicatedOnPrimaryKey})))
+	// 1452: foreign key constraint fails
+	rq.False(dialect.IsDuplicated(&mysql.MySQLError{Number: 1452}))
+	rq.False(dialect.IsDuplicated(fmt.Errorf("other")))
+}
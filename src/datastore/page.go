@@ -0,0 +1,57 @@
+package datastore
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+var ErrorInvalidPageToken = errors.New("invalid page token")
+
+// ListOption filters and paginates a listing. Pages are keyed on id, the
+// token returned with a page is passed as PageToken to fetch the next one.
+type ListOption struct {
+	PageToken      string `json:"page_token,omitempty"`
+	PageSize       int    `json:"page_size,omitempty"`
+	NamePrefix     string `json:"name_prefix,omitempty"`
+	CreatedBy      string `json:"created_by,omitempty"`
+	IncludeDeleted bool   `json:"include_deleted,omitempty"`
+}
+
+// Limit returns the page size, bounded by MaxPageSize
+func (opt ListOption) Limit() int {
+	if opt.PageSize <= 0 {
+		return DefaultPageSize
+	}
+	if opt.PageSize > MaxPageSize {
+		return MaxPageSize
+	}
+	return opt.PageSize
+}
+
+// After returns the id the page starts after
+func (opt ListOption) After() (int64, error) {
+	if opt.PageToken == "" {
+		return 0, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(opt.PageToken)
+	if err != nil {
+		return 0, ErrorInvalidPageToken
+	}
+	id, err := strconv.ParseInt(string(raw), 10, 64)
+	if err != nil || id < 0 {
+		return 0, ErrorInvalidPageToken
+	}
+	return id, nil
+}
+
+// NextPageToken returns the token of the page following the one ending with lastID
+func NextPageToken(lastID int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(lastID, 10)))
+}
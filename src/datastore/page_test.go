@@ -0,0 +1,30 @@
+package datastore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestListOption(t *testing.T) {
+	rq := require.New(t)
+
+	t.Run("limit", func(t *testing.T) {
+		rq.Equal(DefaultPageSize, ListOption{}.Limit())
+		rq.Equal(5, ListOption{PageSize: 5}.Limit())
+		rq.Equal(MaxPageSize, ListOption{PageSize: MaxPageSize + 1}.Limit())
+	})
+
+	t.Run("page token", func(t *testing.T) {
+		after, err := ListOption{}.After()
+		rq.NoError(err)
+		rq.Equal(int64(0), after)
+
+		after, err = ListOption{PageToken: NextPageToken(42)}.After()
+		rq.NoError(err)
+		rq.Equal(int64(42), after)
+
+		_, err = ListOption{PageToken: "!!!"}.After()
+		rq.Equal(ErrorInvalidPageToken, err)
+	})
+}
@@ -0,0 +1,41 @@
+//go:build docker
+
+package postgres
+
+import (
+	"testing"
+
+	"github.com/hanzezhenalex/auth/src"
+	"github.com/hanzezhenalex/auth/src/datastore"
+	"github.com/hanzezhenalex/auth/src/datastore/datastoretest"
+	"github.com/hanzezhenalex/auth/src/datastore/sqlstore"
+)
+
+func createPostgresDatastore() *sqlstore.Store {
+	const path = "../../../dev/postgres.json"
+	cfg, err := src.NewConfigFromFile(path)
+	if err != nil {
+		panic(err)
+	}
+
+	store, err := NewPostgresDatastore(cfg.DbConfig)
+	if err != nil {
+		panic(err)
+	}
+	if err := store.Cleanup(); err != nil {
+		panic(err)
+	}
+	return store
+}
+
+func TestPostgresDatastore(t *testing.T) {
+	//src.EnableDebugMode()
+	store := createPostgresDatastore()
+
+	datastoretest.RunSuite(t, func(t *testing.T) datastore.Datastore {
+		if err := store.Cleanup(); err != nil {
+			t.Fatal(err)
+		}
+		return store
+	})
+}
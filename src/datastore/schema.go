@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/hanzezhenalex/auth/src"
+	"github.com/hanzezhenalex/auth/src/password"
 	"strings"
 	"time"
 )
@@ -21,24 +22,62 @@ func (user User) TableName() string {
 	return src.WithDebugSuffix("user")
 }
 
+// SetPassword stores the salted hash of plain, never plain itself
+func (user *User) SetPassword(hasher *password.Hasher, plain string) error {
+	encoded, err := hasher.Hash(plain)
+	if err != nil {
+		return fmt.Errorf("fail to hash password, %w", err)
+	}
+	user.Password = encoded
+	return nil
+}
+
+// VerifyPassword checks plain against the stored hash. If the stored hash
+// is weaker than the hasher's configuration, it is replaced by a new one
+// and rehashed is true, the caller is responsible for saving the user.
+func (user *User) VerifyPassword(hasher *password.Hasher, plain string) (ok bool, rehashed bool, err error) {
+	if ok, err = hasher.Verify(user.Password, plain); err != nil || !ok {
+		return ok, false, err
+	}
+
+	if hasher.NeedsRehash(user.Password) {
+		if err := user.SetPassword(hasher, plain); err != nil {
+			return true, false, err
+		}
+		return true, true, nil
+	}
+	return true, false, nil
+}
+
+// Scopes of a role, stored one per row in role_scope
 type Scopes []string
 
+// delimiter joined the scopes when they were stored in a single column
 const delimiter = ";"
 
 func (s Scopes) MarshalJSON() ([]byte, error) {
-	result := ""
-	for _, scope := range s {
-		result += delimiter + scope
+	if s == nil {
+		return []byte("[]"), nil
 	}
-	return json.Marshal(result[1:])
+	return json.Marshal([]string(s))
 }
 
+// UnmarshalJSON accepts a list, or the legacy delimiter joined string
 func (s *Scopes) UnmarshalJSON(data []byte) error {
 	var raw string
-	if err := json.Unmarshal(data, &raw); err != nil {
+	if err := json.Unmarshal(data, &raw); err == nil {
+		if raw == "" {
+			*s = nil
+			return nil
+		}
+		*s = strings.Split(raw, delimiter)
+		return nil
+	}
+
+	var scopes []string
+	if err := json.Unmarshal(data, &scopes); err != nil {
 		return fmt.Errorf("unable to unmarshal, err=%s", err)
 	}
-	scopes := strings.Split(raw, delimiter)
 	*s = scopes
 	return nil
 }
@@ -46,9 +85,11 @@ func (s *Scopes) UnmarshalJSON(data []byte) error {
 type Role struct {
 	ID        int64     `xorm:"'id' pk autoincr"`
 	RoleName  string    `xorm:"'role_name' unique(is_delete)"`
-	Scopes    Scopes    `xorm:"'scopes'"`
+	Scopes    Scopes    `xorm:"-"`
 	CreatedBy string    `xorm:"'created_by'"`
 	CreatedAt time.Time `xorm:"created"`
+	UpdatedBy string    `xorm:"'updated_by'"`
+	UpdatedAt time.Time `xorm:"updated"`
 	DeletedAt int64     `xorm:"deleted unique(is_delete) default(0) not null"`
 
 	Auths []string `xorm:"-"`
@@ -63,6 +104,8 @@ type Authority struct {
 	AuthName  string    `xorm:"'authority_name' not null unique(is_delete)"`
 	CreatedBy string    `xorm:"'created_by'"`
 	CreatedAt time.Time `xorm:"created"`
+	UpdatedBy string    `xorm:"'updated_by'"`
+	UpdatedAt time.Time `xorm:"updated"`
 	DeletedAt int64     `xorm:"deleted unique(is_delete) default(0) not null"`
 }
 
@@ -70,6 +113,17 @@ func (auth Authority) TableName() string {
 	return src.WithDebugSuffix("authority")
 }
 
+type RoleScope struct {
+	RoleID    int64     `xorm:"'role_id' unique(is_delete)"`
+	Scope     string    `xorm:"'scope' not null unique(is_delete) index"`
+	DeletedAt int64     `xorm:"deleted unique(is_delete) default(0) not null"`
+	CreatedAt time.Time `xorm:"created"`
+}
+
+func (rs RoleScope) TableName() string {
+	return src.WithDebugSuffix("role_scope")
+}
+
 type RoleBinding struct {
 	RoleID    int64     `xorm:"'role_id' unique(is_delete)"`
 	AuthID    int64     `xorm:"'auth_id' unique(is_delete)"`
@@ -81,3 +135,73 @@ type RoleBinding struct {
 func (rb RoleBinding) TableName() string {
 	return src.WithDebugSuffix("role_binding")
 }
+
+type UserRoleBinding struct {
+	UserID    int64     `xorm:"'user_id' unique(is_delete)"`
+	RoleID    int64     `xorm:"'role_id' unique(is_delete)"`
+	RoleName  string    `xorm:"'role_name'"`
+	DeletedAt int64     `xorm:"deleted unique(is_delete) default(0) not null"`
+	CreatedAt time.Time `xorm:"created"`
+}
+
+func (urb UserRoleBinding) TableName() string {
+	return src.WithDebugSuffix("user_role_binding")
+}
+
+// Session is a login of a user. Only the hash of its token is stored, the
+// token itself is given once to the user. A revoked session is soft deleted.
+type Session struct {
+	ID        int64     `xorm:"'id' pk autoincr"`
+	UserID    int64     `xorm:"'user_id' not null index"`
+	TokenHash string    `xorm:"'token_hash' not null unique"`
+	CreatedAt time.Time `xorm:"created"`
+	// ExpiresAt and LastSeenAt are in nanoseconds like deleted_at, the
+	// session checks are exact on every database
+	ExpiresAt  int64 `xorm:"'expires_at' not null index"`
+	LastSeenAt int64 `xorm:"'last_seen_at' not null"`
+	DeletedAt  int64 `xorm:"deleted default(0) not null"`
+}
+
+func (s Session) TableName() string {
+	return src.WithDebugSuffix("session")
+}
+
+// SigningKey is a key signing the access tokens, the private key is sealed
+// with the master key of the config. A key signs until it is retired, then
+// only verifies for a grace period.
+type SigningKey struct {
+	ID         int64     `xorm:"'id' pk autoincr"`
+	KeyID      string    `xorm:"'kid' not null unique"`
+	Algorithm  string    `xorm:"'algorithm' not null"`
+	PrivateKey string    `xorm:"'private_key' text not null"`
+	CreatedAt  time.Time `xorm:"created"`
+	// ActivatedAt and RetiredAt are in nanoseconds like deleted_at,
+	// RetiredAt is 0 while the key signs
+	ActivatedAt int64 `xorm:"'activated_at' not null"`
+	RetiredAt   int64 `xorm:"'retired_at' default(0) not null"`
+	DeletedAt   int64 `xorm:"deleted default(0) not null"`
+}
+
+func (key SigningKey) TableName() string {
+	return src.WithDebugSuffix("signing_key")
+}
+
+// RefreshToken renews the access tokens of a user, once. Using it stores
+// the next token of its family, the family is the id of its first token.
+// Only the hash of a token is stored, a revoked token is soft deleted.
+type RefreshToken struct {
+	ID        int64     `xorm:"'id' pk autoincr"`
+	UserID    int64     `xorm:"'user_id' not null index"`
+	FamilyID  int64     `xorm:"'family_id' not null index"`
+	TokenHash string    `xorm:"'token_hash' not null unique"`
+	CreatedAt time.Time `xorm:"created"`
+	// ExpiresAt and UsedAt are in nanoseconds like deleted_at, UsedAt is 0
+	// until the token is rotated
+	ExpiresAt int64 `xorm:"'expires_at' not null index"`
+	UsedAt    int64 `xorm:"'used_at' default(0) not null"`
+	DeletedAt int64 `xorm:"deleted default(0) not null"`
+}
+
+func (token RefreshToken) TableName() string {
+	return src.WithDebugSuffix("refresh_token")
+}
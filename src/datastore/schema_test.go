@@ -5,12 +5,15 @@ import (
 	"encoding/json"
 	"testing"
 
+	"github.com/hanzezhenalex/auth/src"
+	"github.com/hanzezhenalex/auth/src/password"
+
 	"github.com/stretchr/testify/require"
 )
 
 func TestScopes(t *testing.T) {
 	rq := require.New(t)
-	origin := "\"" + "scope1" + delimiter + "scope2" + delimiter + "scope3" + "\"\n"
+	origin := `["scope1","scope2","scope3"]` + "\n"
 	scopes := Scopes{
 		"scope1", "scope2", "scope3",
 	}
@@ -28,4 +31,79 @@ func TestScopes(t *testing.T) {
 		rq.EqualValues(scopes, _scopes)
 	})
 
+	t.Run("Unmarshal legacy string", func(t *testing.T) {
+		legacy := "\"" + "scope1" + delimiter + "scope2" + delimiter + "scope3" + "\""
+		var _scopes Scopes
+		rq.NoError(json.Unmarshal([]byte(legacy), &_scopes))
+		rq.EqualValues(scopes, _scopes)
+	})
+
+	t.Run("scope with delimiter", func(t *testing.T) {
+		raw, err := json.Marshal(Scopes{"a;b", "c"})
+		rq.NoError(err)
+
+		var _scopes Scopes
+		rq.NoError(json.Unmarshal(raw, &_scopes))
+		rq.EqualValues(Scopes{"a;b", "c"}, _scopes)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		for _, empty := range []Scopes{nil, {}} {
+			raw, err := json.Marshal(empty)
+			rq.NoError(err)
+			rq.Equal(`[]`, string(raw))
+
+			var _scopes Scopes
+			rq.NoError(json.Unmarshal(raw, &_scopes))
+			rq.Equal(0, len(_scopes))
+		}
+
+		var _scopes Scopes
+		rq.NoError(json.Unmarshal([]byte(`""`), &_scopes))
+		rq.Equal(0, len(_scopes))
+	})
+
+}
+
+func TestUserPassword(t *testing.T) {
+	rq := require.New(t)
+
+	weakCfg := src.NewPasswordConfig()
+	weakCfg.Iterations = password.MinIterations
+	weak, err := password.NewHasher(weakCfg)
+	rq.NoError(err)
+	strongCfg := src.NewPasswordConfig()
+	strongCfg.Iterations = 2 * password.MinIterations
+	strong, err := password.NewHasher(strongCfg)
+	rq.NoError(err)
+
+	var user User
+	rq.NoError(user.SetPassword(weak, "secret"))
+	rq.NotEqual("secret", user.Password)
+
+	t.Run("wrong password", func(t *testing.T) {
+		ok, rehashed, err := user.VerifyPassword(weak, "wrong")
+		rq.NoError(err)
+		rq.False(ok)
+		rq.False(rehashed)
+	})
+
+	t.Run("same cost, no rehash", func(t *testing.T) {
+		stored := user.Password
+		ok, rehashed, err := user.VerifyPassword(weak, "secret")
+		rq.NoError(err)
+		rq.True(ok)
+		rq.False(rehashed)
+		rq.Equal(stored, user.Password)
+	})
+
+	t.Run("higher cost, rehash", func(t *testing.T) {
+		stored := user.Password
+		ok, rehashed, err := user.VerifyPassword(strong, "secret")
+		rq.NoError(err)
+		rq.True(ok)
+		rq.True(rehashed)
+		rq.NotEqual(stored, user.Password)
+		rq.False(strong.NeedsRehash(user.Password))
+	})
 }
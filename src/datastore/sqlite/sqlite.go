@@ -0,0 +1,72 @@
+package sqlite
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hanzezhenalex/auth/src"
+	"github.com/hanzezhenalex/auth/src/datastore"
+	"github.com/hanzezhenalex/auth/src/datastore/sqlstore"
+
+	"github.com/mattn/go-sqlite3"
+	"xorm.io/xorm"
+)
+
+// MemoryDatabase keeps the whole database in memory, it is gone with the store
+const MemoryDatabase = ":memory:"
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) IsDuplicated(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique ||
+			sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+	}
+	return false
+}
+
+// RowLock is not needed, sqlite allows a single writer and the store
+// holds a single connection
+func (sqliteDialect) RowLock() bool {
+	return false
+}
+
+func init() {
+	datastore.Register(src.DriverSqlite, func(cfg src.Config) (datastore.Datastore, error) {
+		store, err := NewSqliteDatastore(cfg.DbConfig)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	})
+}
+
+// NewSqliteDatastore opens the sqlite database file cfg.Database, or an
+// in-memory one for MemoryDatabase
+func NewSqliteDatastore(cfg src.DbConfig) (*sqlstore.Store, error) {
+	if cfg.Database == "" {
+		return nil, errors.New("sqlite database file is not specified")
+	}
+
+	// "path?_busy_timeout=5000&_txlock=immediate", transactions take the
+	// write lock when they begin, a deferred one upgrading from read to
+	// write fails at once with "database is locked" if another writer is
+	// active, instead of waiting for the busy timeout
+	dns := fmt.Sprintf("%s?_busy_timeout=5000&_txlock=immediate", cfg.Database)
+	engine, err := xorm.NewEngine("sqlite3", dns)
+	if err != nil {
+		return nil, fmt.Errorf("fail to open db: %w", err)
+	}
+
+	// a single connection serializes the writers, and keeps an in-memory
+	// database alive as long as the store
+	engine.SetMaxIdleConns(1)
+	engine.SetMaxOpenConns(1)
+
+	if err := engine.Ping(); err != nil {
+		return nil, fmt.Errorf("fail to ping db: %w", err)
+	}
+
+	return sqlstore.New(engine, sqliteDialect{}, cfg)
+}
@@ -0,0 +1,22 @@
+package sqlite
+
+import (
+	"testing"
+
+	"github.com/hanzezhenalex/auth/src"
+	"github.com/hanzezhenalex/auth/src/datastore"
+	"github.com/hanzezhenalex/auth/src/datastore/datastoretest"
+)
+
+func TestSqliteDatastore(t *testing.T) {
+	datastoretest.RunSuite(t, func(t *testing.T) datastore.Datastore {
+		cfg := src.NewDbConfig()
+		cfg.Database = MemoryDatabase
+
+		store, err := NewSqliteDatastore(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	})
+}
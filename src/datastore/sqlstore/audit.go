@@ -0,0 +1,69 @@
+package sqlstore
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hanzezhenalex/auth/src/datastore"
+
+	"xorm.io/xorm"
+)
+
+/*
+	Audit
+*/
+
+// audit records a mutation in the session doing it, both are committed or
+// rolled back together
+func audit(
+	ctx context.Context,
+	session *xorm.Session,
+	action, targetType string,
+	targetID int64,
+	before, after datastore.AuditDiff,
+) error {
+	event, err := datastore.NewAuditEvent(ctx, action, targetType, targetID, before, after)
+	if err != nil {
+		return err
+	}
+	if _, err := session.Insert(event); err != nil {
+		return fmt.Errorf("fail to insert audit event, %w", err)
+	}
+	return nil
+}
+
+func (store *Store) ListAuditEvents(
+	ctx context.Context,
+	opt datastore.AuditListOption,
+) ([]*datastore.AuditEvent, string, error) {
+	after, err := opt.After()
+	if err != nil {
+		return nil, "", err
+	}
+
+	session := store.engine.Context(ctx).Where("id>?", after)
+	if opt.Actor != "" {
+		session.And("actor=?", opt.Actor)
+	}
+	if !opt.Since.IsZero() {
+		session.And("occurred_at>=?", opt.Since.UnixNano())
+	}
+	if !opt.Until.IsZero() {
+		session.And("occurred_at<?", opt.Until.UnixNano())
+	}
+
+	var events []*datastore.AuditEvent
+	if err := session.
+		OrderBy("id").
+		Limit(opt.Limit() + 1).
+		Find(&events); err != nil {
+		return nil, "", fmt.Errorf("fail to list audit events, %w", err)
+	}
+
+	var next string
+	if limit := opt.Limit(); len(events) > limit {
+		events = events[:limit]
+		next = datastore.NextPageToken(events[limit-1].ID)
+	}
+	return events, next, nil
+}
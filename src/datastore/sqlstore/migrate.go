@@ -0,0 +1,341 @@
+package sqlstore
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hanzezhenalex/auth/src"
+	"github.com/hanzezhenalex/auth/src/datastore"
+
+	"xorm.io/xorm"
+)
+
+/*
+	Migrations
+
+	The schema is changed by numbered steps, each with an up and a down
+	function run in its own transaction. mysql commits DDL implicitly, so
+	a step failing there may need manual cleanup. Applied versions are
+	recorded in schema_migrations. A single row in schema_migrations_lock,
+	taken with a conditional update, keeps concurrent instances from
+	migrating at the same time. The lock expires after lockTTL, so a
+	crashed instance does not block the others forever. Each step renews
+	it first, and fails if it expired and may be held by another instance
+	already, a single step must not outlast lockTTL.
+*/
+
+const (
+	migrationLockID  = 1
+	lockTTL          = 10 * time.Minute
+	lockPollInterval = time.Second
+)
+
+var (
+	ErrorSchemaTooNew          = errors.New("database schema is newer than the known migrations")
+	ErrorUnknownSchemaVersion  = errors.New("unknown schema version")
+	ErrorIrreversibleMigration = errors.New("migration can not be reverted")
+	ErrorMigrationLockLost     = errors.New("migration lock lost")
+)
+
+type migration struct {
+	Version     int64
+	Description string
+	Up          func(session *xorm.Session) error
+	// Down is nil if the migration can not be reverted
+	Down func(session *xorm.Session) error
+}
+
+type schemaMigration struct {
+	Version     int64     `xorm:"'version' pk"`
+	Description string    `xorm:"'description'"`
+	AppliedAt   time.Time `xorm:"created"`
+}
+
+func (sm schemaMigration) TableName() string {
+	return src.WithDebugSuffix("schema_migrations")
+}
+
+type migrationLock struct {
+	ID          int64  `xorm:"'id' pk"`
+	Owner       string `xorm:"'owner'"`
+	LockedUntil int64  `xorm:"'locked_until' not null default(0)"`
+}
+
+func (ml migrationLock) TableName() string {
+	return src.WithDebugSuffix("schema_migrations_lock")
+}
+
+// Migrate brings the schema to the target version, applying the pending
+// migrations up to it or reverting the applied ones above it.
+// datastore.LatestSchemaVersion applies every known migration.
+func (store *Store) Migrate(ctx context.Context, target int64) error {
+	if err := store.engine.Context(ctx).Sync(new(schemaMigration), new(migrationLock)); err != nil {
+		return fmt.Errorf("fail to sync migration tables, %w", err)
+	}
+
+	owner, err := newLockOwner()
+	if err != nil {
+		return err
+	}
+
+	if err := store.lockMigrations(ctx, owner); err != nil {
+		return err
+	}
+	defer store.unlockMigrations(owner)
+
+	applied, err := store.appliedVersions(ctx)
+	if err != nil {
+		return err
+	}
+
+	ups, downs, err := plan(migrations, applied, target)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range ups {
+		m := m
+		if err := store.transaction(ctx, func(session *xorm.Session) error {
+			if err := renewMigrationLock(session, owner); err != nil {
+				return err
+			}
+			if err := m.Up(session); err != nil {
+				return err
+			}
+			_, err := session.Insert(&schemaMigration{Version: m.Version, Description: m.Description})
+			return err
+		}); err != nil {
+			return fmt.Errorf("fail to apply migration %d, %w", m.Version, err)
+		}
+	}
+
+	for _, m := range downs {
+		m := m
+		if err := store.transaction(ctx, func(session *xorm.Session) error {
+			if err := renewMigrationLock(session, owner); err != nil {
+				return err
+			}
+			if err := m.Down(session); err != nil {
+				return err
+			}
+			_, err := session.Delete(&schemaMigration{Version: m.Version})
+			return err
+		}); err != nil {
+			return fmt.Errorf("fail to revert migration %d, %w", m.Version, err)
+		}
+	}
+	return nil
+}
+
+// SchemaVersion returns the latest applied version, 0 for an empty database
+func (store *Store) SchemaVersion(ctx context.Context) (int64, error) {
+	applied, err := store.appliedVersions(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if len(applied) == 0 {
+		return 0, nil
+	}
+	return applied[len(applied)-1], nil
+}
+
+func (store *Store) appliedVersions(ctx context.Context) ([]int64, error) {
+	var rows []*schemaMigration
+	if err := store.engine.Context(ctx).
+		OrderBy("version").
+		Find(&rows); err != nil {
+		return nil, fmt.Errorf("fail to get applied migrations, %w", err)
+	}
+
+	versions := make([]int64, 0, len(rows))
+	for _, row := range rows {
+		versions = append(versions, row.Version)
+	}
+	return versions, nil
+}
+
+// plan returns the migrations to apply and to revert, in running order
+func plan(known []migration, applied []int64, target int64) ([]migration, []migration, error) {
+	if !sort.SliceIsSorted(known, func(i, j int) bool { return known[i].Version < known[j].Version }) {
+		return nil, nil, errors.New("migrations are not in version order")
+	}
+
+	var latest int64
+	if len(known) > 0 {
+		latest = known[len(known)-1].Version
+	}
+
+	if target == datastore.LatestSchemaVersion {
+		target = latest
+	} else if target < 0 || target > latest {
+		return nil, nil, fmt.Errorf("%w %d", ErrorUnknownSchemaVersion, target)
+	}
+
+	done := make(map[int64]bool, len(applied))
+	for _, version := range applied {
+		if version > latest {
+			return nil, nil, fmt.Errorf("%w, applied %d, known %d", ErrorSchemaTooNew, version, latest)
+		}
+		done[version] = true
+	}
+
+	var ups, downs []migration
+	for _, m := range known {
+		if m.Version <= target && !done[m.Version] {
+			ups = append(ups, m)
+		}
+	}
+	for i := len(known) - 1; i >= 0; i-- {
+		m := known[i]
+		if m.Version > target && done[m.Version] {
+			if m.Down == nil {
+				return nil, nil, fmt.Errorf("%w, version %d", ErrorIrreversibleMigration, m.Version)
+			}
+			downs = append(downs, m)
+		}
+	}
+	return ups, downs, nil
+}
+
+/*
+	Lock
+*/
+
+func newLockOwner() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("fail to generate lock owner, %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// lockMigrations waits until the lock is free or expired and takes it
+func (store *Store) lockMigrations(ctx context.Context, owner string) error {
+	if _, err := store.engine.Context(ctx).
+		Insert(&migrationLock{ID: migrationLockID}); err != nil && !store.dialect.IsDuplicated(err) {
+		return fmt.Errorf("fail to create migration lock, %w", err)
+	}
+
+	for {
+		now := time.Now()
+		affected, err := store.engine.Context(ctx).
+			Table(new(migrationLock)).
+			Where("id=? AND locked_until<?", migrationLockID, now.UnixNano()).
+			Update(map[string]interface{}{
+				"owner":        owner,
+				"locked_until": now.Add(lockTTL).UnixNano(),
+			})
+		if err != nil {
+			return fmt.Errorf("fail to take migration lock, %w", err)
+		}
+		if affected == 1 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("fail to take migration lock, %w", ctx.Err())
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// renewMigrationLock extends the lock held by owner for another lockTTL,
+// it fails with ErrorMigrationLockLost once the lock has expired
+func renewMigrationLock(session *xorm.Session, owner string) error {
+	now := time.Now()
+	affected, err := session.
+		Table(new(migrationLock)).
+		Where("id=? AND owner=? AND locked_until>=?", migrationLockID, owner, now.UnixNano()).
+		Update(map[string]interface{}{"locked_until": now.Add(lockTTL).UnixNano()})
+	if err != nil {
+		return fmt.Errorf("fail to renew migration lock, %w", err)
+	}
+	if affected != 1 {
+		return ErrorMigrationLockLost
+	}
+	return nil
+}
+
+// unlockMigrations releases the lock if it is still held by owner, it
+// expires anyway if this fails
+func (store *Store) unlockMigrations(owner string) {
+	_, _ = store.engine.
+		Table(new(migrationLock)).
+		Where("id=? AND owner=?", migrationLockID, owner).
+		Update(map[string]interface{}{"locked_until": 0})
+}
+
+/*
+	Helpers for the migration steps
+*/
+
+// createTables creates the tables, with their indexes, which do not exist.
+// Tables from before the migrations, created by engine.Sync with the same
+// structs, are adopted as they are.
+func createTables(session *xorm.Session, beans ...tableNamer) error {
+	for _, bean := range beans {
+		ok, err := session.IsTableExist(bean)
+		if err != nil {
+			return fmt.Errorf("fail to check table %s, %w", bean.TableName(), err)
+		}
+		if ok {
+			continue
+		}
+
+		if err := session.CreateTable(bean); err != nil {
+			return fmt.Errorf("fail to create table %s, %w", bean.TableName(), err)
+		}
+		if err := session.CreateUniques(bean); err != nil {
+			return fmt.Errorf("fail to create unique indexes of %s, %w", bean.TableName(), err)
+		}
+		if err := session.CreateIndexes(bean); err != nil {
+			return fmt.Errorf("fail to create indexes of %s, %w", bean.TableName(), err)
+		}
+	}
+	return nil
+}
+
+// dropTables drops the tables in reverse order
+func dropTables(session *xorm.Session, beans ...tableNamer) error {
+	for i := len(beans) - 1; i >= 0; i-- {
+		if err := session.DropTable(beans[i]); err != nil {
+			return fmt.Errorf("fail to drop table %s, %w", beans[i].TableName(), err)
+		}
+	}
+	return nil
+}
+
+// addColumns adds the columns of bean, as its struct declares them
+func addColumns(session *xorm.Session, bean tableNamer, cols ...string) error {
+	table, err := session.Engine().TableInfo(bean)
+	if err != nil {
+		return fmt.Errorf("fail to parse table %s, %w", bean.TableName(), err)
+	}
+
+	for _, name := range cols {
+		col := table.GetColumn(name)
+		if col == nil {
+			return fmt.Errorf("column %s not found in table %s", name, bean.TableName())
+		}
+		if _, err := session.Exec(session.Engine().Dialect().AddColumnSQL(bean.TableName(), col)); err != nil {
+			return fmt.Errorf("fail to add %s.%s, %w", bean.TableName(), name, err)
+		}
+	}
+	return nil
+}
+
+// dropColumns drops the columns of bean
+func dropColumns(session *xorm.Session, bean tableNamer, cols ...string) error {
+	for _, name := range cols {
+		if _, err := session.Exec("ALTER TABLE " + quote(bean) + " DROP COLUMN `" + name + "`"); err != nil {
+			return fmt.Errorf("fail to drop %s.%s, %w", bean.TableName(), name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+package sqlstore
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hanzezhenalex/auth/src"
+	"github.com/hanzezhenalex/auth/src/datastore"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/require"
+	"xorm.io/xorm"
+)
+
+type testDialect struct{}
+
+func (testDialect) IsDuplicated(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrConstraint
+	}
+	return false
+}
+
+func (testDialect) RowLock() bool {
+	return false
+}
+
+func newTestEngine(t *testing.T, path string) *xorm.Engine {
+	engine, err := xorm.NewEngine("sqlite3", path+"?_busy_timeout=5000&_txlock=immediate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = engine.Close() })
+	return engine
+}
+
+func TestPlan(t *testing.T) {
+	noop := func(*xorm.Session) error { return nil }
+	known := []migration{
+		{Version: 1, Up: noop, Down: noop},
+		{Version: 2, Up: noop, Down: noop},
+		{Version: 3, Up: noop},
+	}
+	versions := func(ms []migration) []int64 {
+		var vs []int64
+		for _, m := range ms {
+			vs = append(vs, m.Version)
+		}
+		return vs
+	}
+
+	t.Run("up to latest", func(t *testing.T) {
+		rq := require.New(t)
+		ups, downs, err := plan(known, []int64{1}, datastore.LatestSchemaVersion)
+		rq.NoError(err)
+		rq.Equal([]int64{2, 3}, versions(ups))
+		rq.Empty(downs)
+	})
+
+	t.Run("down in reverse order", func(t *testing.T) {
+		rq := require.New(t)
+		ups, downs, err := plan(known[:2], []int64{1, 2}, 0)
+		rq.NoError(err)
+		rq.Empty(ups)
+		rq.Equal([]int64{2, 1}, versions(downs))
+	})
+
+	t.Run("irreversible", func(t *testing.T) {
+		rq := require.New(t)
+		_, _, err := plan(known, []int64{1, 2, 3}, 1)
+		rq.True(errors.Is(err, ErrorIrreversibleMigration))
+	})
+
+	t.Run("unknown target", func(t *testing.T) {
+		rq := require.New(t)
+		_, _, err := plan(known, nil, 4)
+		rq.True(errors.Is(err, ErrorUnknownSchemaVersion))
+	})
+
+	t.Run("schema too new", func(t *testing.T) {
+		rq := require.New(t)
+		_, _, err := plan(known, []int64{1, 2, 3, 4}, datastore.LatestSchemaVersion)
+		rq.True(errors.Is(err, ErrorSchemaTooNew))
+	})
+
+	t.Run("unordered migrations", func(t *testing.T) {
+		rq := require.New(t)
+		_, _, err := plan([]migration{known[1], known[0]}, nil, datastore.LatestSchemaVersion)
+		rq.Error(err)
+	})
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	latest := migrations[len(migrations)-1].Version
+
+	t.Run("down and up", func(t *testing.T) {
+		rq := require.New(t)
+		engine := newTestEngine(t, filepath.Join(t.TempDir(), "auth.db"))
+		store, err := New(engine, testDialect{}, src.DbConfig{})
+		rq.NoError(err)
+
+		version, err := store.SchemaVersion(ctx)
+		rq.NoError(err)
+		rq.Equal(latest, version)
+
+		rq.NoError(store.Migrate(ctx, 0))
+		version, err = store.SchemaVersion(ctx)
+		rq.NoError(err)
+		rq.Equal(int64(0), version)
+		ok, err := engine.IsTableExist(new(datastore.User))
+		rq.NoError(err)
+		rq.False(ok)
+
+		rq.NoError(store.Migrate(ctx, datastore.LatestSchemaVersion))
+		rq.NoError(store.CreateUser(ctx, &datastore.User{Username: "migrate", Password: "p"}))
+	})
+
+	t.Run("adopt tables created by sync", func(t *testing.T) {
+		rq := require.New(t)
+		engine := newTestEngine(t, filepath.Join(t.TempDir(), "auth.db"))
+		// the tables as released before the migrations
+		rq.NoError(engine.Sync(new(v1User), new(v1Authority)))
+		_, err := engine.Insert(&v1User{Username: "existed", Password: "p"})
+		rq.NoError(err)
+
+		store, err := New(engine, testDialect{}, src.DbConfig{})
+		rq.NoError(err)
+		user, err := store.GetUserByName(ctx, "existed")
+		rq.NoError(err)
+		rq.Equal("existed", user.Username)
+	})
+
+	t.Run("concurrent instances", func(t *testing.T) {
+		rq := require.New(t)
+		path := filepath.Join(t.TempDir(), "auth.db")
+
+		var wg sync.WaitGroup
+		errs := make([]error, 4)
+		for i := range errs {
+			engine := newTestEngine(t, path)
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				_, errs[i] = New(engine, testDialect{}, src.DbConfig{})
+			}(i)
+		}
+		wg.Wait()
+		for _, err := range errs {
+			rq.NoError(err)
+		}
+
+		var applied []*schemaMigration
+		rq.NoError(newTestEngine(t, path).Find(&applied))
+		rq.Len(applied, len(migrations))
+	})
+
+	t.Run("lock", func(t *testing.T) {
+		rq := require.New(t)
+		engine := newTestEngine(t, filepath.Join(t.TempDir(), "auth.db"))
+		store, err := New(engine, testDialect{}, src.DbConfig{})
+		rq.NoError(err)
+
+		rq.NoError(store.lockMigrations(ctx, "other"))
+
+		timeout, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+		err = store.Migrate(timeout, 0)
+		rq.True(errors.Is(err, context.DeadlineExceeded))
+
+		// an expired lock is taken over
+		_, err = engine.
+			Table(new(migrationLock)).
+			Where("id=?", migrationLockID).
+			Update(map[string]interface{}{"locked_until": time.Now().Add(-time.Second).UnixNano()})
+		rq.NoError(err)
+		rq.NoError(store.Migrate(ctx, 0))
+
+		version, err := store.SchemaVersion(ctx)
+		rq.NoError(err)
+		rq.Equal(int64(0), version)
+	})
+
+	t.Run("renew lock", func(t *testing.T) {
+		rq := require.New(t)
+		engine := newTestEngine(t, filepath.Join(t.TempDir(), "auth.db"))
+		store, err := New(engine, testDialect{}, src.DbConfig{})
+		rq.NoError(err)
+
+		session := engine.NewSession()
+		defer session.Close()
+
+		rq.NoError(store.lockMigrations(ctx, "owner"))
+		rq.NoError(renewMigrationLock(session, "owner"))
+		rq.True(errors.Is(renewMigrationLock(session, "other"), ErrorMigrationLockLost))
+
+		// expired, another instance may have taken it
+		_, err = engine.
+			Table(new(migrationLock)).
+			Where("id=?", migrationLockID).
+			Update(map[string]interface{}{"locked_until": time.Now().Add(-time.Second).UnixNano()})
+		rq.NoError(err)
+		rq.True(errors.Is(renewMigrationLock(session, "owner"), ErrorMigrationLockLost))
+	})
+
+	t.Run("split role scopes", func(t *testing.T) {
+		rq := require.New(t)
+		engine := newTestEngine(t, filepath.Join(t.TempDir(), "auth.db"))
+		store, err := New(engine, testDialect{}, src.DbConfig{})
+		rq.NoError(err)
+		rq.NoError(store.Migrate(ctx, 1))
+
+		for _, row := range []struct {
+			name    string
+			scopes  interface{}
+			deleted int64
+		}{
+			{"live", `"b;a;a"`, 0},
+			{"deleted", `"c"`, 123},
+			{"empty", `""`, 0},
+			{"null", nil, 0},
+		} {
+			_, err := engine.Exec(
+				"INSERT INTO "+quote(new(v1Role))+" (role_name, scopes, deleted_at) VALUES (?, ?, ?)",
+				row.name, row.scopes, row.deleted,
+			)
+			rq.NoError(err)
+		}
+
+		rq.NoError(store.Migrate(ctx, datastore.LatestSchemaVersion))
+
+		role, err := store.GetRoleByName(ctx, "live")
+		rq.NoError(err)
+		rq.EqualValues(datastore.Scopes{"a", "b"}, role.Scopes)
+
+		role, err = store.GetRoleByName(ctx, "null")
+		rq.NoError(err)
+		rq.Empty(role.Scopes)
+
+		roles, _, err := store.ListRoles(ctx, datastore.ListOption{NamePrefix: "deleted", IncludeDeleted: true})
+		rq.NoError(err)
+		rq.Len(roles, 1)
+		rq.EqualValues(datastore.Scopes{"c"}, roles[0].Scopes)
+
+		// and back
+		rq.NoError(store.Migrate(ctx, 1))
+		results, err := engine.QueryString("SELECT role_name, scopes FROM " + quote(new(v1Role)) + " ORDER BY id")
+		rq.NoError(err)
+		var scopes []string
+		for _, row := range results {
+			scopes = append(scopes, row["scopes"])
+		}
+		rq.Equal([]string{`"a;b"`, `"c"`, `""`, `""`}, scopes)
+	})
+}
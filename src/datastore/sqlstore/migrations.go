@@ -0,0 +1,388 @@
+package sqlstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hanzezhenalex/auth/src"
+
+	"xorm.io/xorm"
+)
+
+// migrations in version order. A released migration is never edited, the
+// structs it uses are snapshots of the schema at that version, not the
+// live ones in the datastore package.
+var migrations = []migration{
+	{
+		Version:     1,
+		Description: "create user, authority, role and bindings",
+		Up: func(session *xorm.Session) error {
+			return createTables(session, v1Tables()...)
+		},
+		Down: func(session *xorm.Session) error {
+			return dropTables(session, v1Tables()...)
+		},
+	},
+	{
+		Version:     2,
+		Description: "move role scopes into role_scope",
+		Up:          splitRoleScopes,
+		Down:        joinRoleScopes,
+	},
+	{
+		Version:     3,
+		Description: "create audit_event",
+		Up: func(session *xorm.Session) error {
+			return createTables(session, new(v3AuditEvent))
+		},
+		Down: func(session *xorm.Session) error {
+			return dropTables(session, new(v3AuditEvent))
+		},
+	},
+	{
+		Version:     4,
+		Description: "add updated_by and updated_at to role and authority",
+		Up: func(session *xorm.Session) error {
+			for _, bean := range []tableNamer{new(v4Role), new(v4Authority)} {
+				if err := addColumns(session, bean, "updated_by", "updated_at"); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(session *xorm.Session) error {
+			for _, bean := range []tableNamer{new(v4Role), new(v4Authority)} {
+				if err := dropColumns(session, bean, "updated_by", "updated_at"); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version:     5,
+		Description: "create session",
+		Up: func(session *xorm.Session) error {
+			return createTables(session, new(v5Session))
+		},
+		Down: func(session *xorm.Session) error {
+			return dropTables(session, new(v5Session))
+		},
+	},
+	{
+		Version:     6,
+		Description: "create signing_key",
+		Up: func(session *xorm.Session) error {
+			return createTables(session, new(v6SigningKey))
+		},
+		Down: func(session *xorm.Session) error {
+			return dropTables(session, new(v6SigningKey))
+		},
+	},
+	{
+		Version:     7,
+		Description: "create refresh_token",
+		Up: func(session *xorm.Session) error {
+			return createTables(session, new(v7RefreshToken))
+		},
+		Down: func(session *xorm.Session) error {
+			return dropTables(session, new(v7RefreshToken))
+		},
+	},
+}
+
+/*
+	Version 1
+*/
+
+func v1Tables() []tableNamer {
+	return []tableNamer{
+		new(v1User),
+		new(v1Authority),
+		new(v1Role),
+		new(v1RoleBinding),
+		new(v1UserRoleBinding),
+	}
+}
+
+type v1User struct {
+	ID        int64     `xorm:"'id' pk autoincr"`
+	Username  string    `xorm:"'user_name' not null unique(is_delete)"`
+	Password  string    `xorm:"'password' not null"`
+	Reserve   string    `xorm:"'reserve'"`
+	CreatedAt time.Time `xorm:"created"`
+	DeletedAt int64     `xorm:"deleted unique(is_delete) default(0) not null"`
+}
+
+func (v1User) TableName() string {
+	return src.WithDebugSuffix("user")
+}
+
+type v1Authority struct {
+	ID        int64     `xorm:"'id' pk autoincr"`
+	AuthName  string    `xorm:"'authority_name' not null unique(is_delete)"`
+	CreatedBy string    `xorm:"'created_by'"`
+	CreatedAt time.Time `xorm:"created"`
+	DeletedAt int64     `xorm:"deleted unique(is_delete) default(0) not null"`
+}
+
+func (v1Authority) TableName() string {
+	return src.WithDebugSuffix("authority")
+}
+
+type v1Role struct {
+	ID        int64     `xorm:"'id' pk autoincr"`
+	RoleName  string    `xorm:"'role_name' unique(is_delete)"`
+	Scopes    []string  `xorm:"'scopes'"`
+	CreatedBy string    `xorm:"'created_by'"`
+	CreatedAt time.Time `xorm:"created"`
+	DeletedAt int64     `xorm:"deleted unique(is_delete) default(0) not null"`
+}
+
+func (v1Role) TableName() string {
+	return src.WithDebugSuffix("role")
+}
+
+type v1RoleBinding struct {
+	RoleID    int64     `xorm:"'role_id' unique(is_delete)"`
+	AuthID    int64     `xorm:"'auth_id' unique(is_delete)"`
+	AuthName  string    `xorm:"'auth_name'"`
+	DeletedAt int64     `xorm:"deleted unique(is_delete) default(0) not null"`
+	CreatedAt time.Time `xorm:"created"`
+}
+
+func (v1RoleBinding) TableName() string {
+	return src.WithDebugSuffix("role_binding")
+}
+
+type v1UserRoleBinding struct {
+	UserID    int64     `xorm:"'user_id' unique(is_delete)"`
+	RoleID    int64     `xorm:"'role_id' unique(is_delete)"`
+	RoleName  string    `xorm:"'role_name'"`
+	DeletedAt int64     `xorm:"deleted unique(is_delete) default(0) not null"`
+	CreatedAt time.Time `xorm:"created"`
+}
+
+func (v1UserRoleBinding) TableName() string {
+	return src.WithDebugSuffix("user_role_binding")
+}
+
+/*
+	Version 2
+*/
+
+type v2RoleScope struct {
+	RoleID    int64     `xorm:"'role_id' unique(is_delete)"`
+	Scope     string    `xorm:"'scope' not null unique(is_delete) index"`
+	DeletedAt int64     `xorm:"deleted unique(is_delete) default(0) not null"`
+	CreatedAt time.Time `xorm:"created"`
+}
+
+func (v2RoleScope) TableName() string {
+	return src.WithDebugSuffix("role_scope")
+}
+
+// v1ScopesDelimiter joined the scopes in role.scopes, which held them as a
+// json string
+const v1ScopesDelimiter = ";"
+
+// splitRoleScopes moves every scope in role.scopes to a row of role_scope,
+// the rows of a deleted role are deleted along with it
+func splitRoleScopes(session *xorm.Session) error {
+	if err := createTables(session, new(v2RoleScope)); err != nil {
+		return err
+	}
+
+	results, err := session.QueryString("SELECT id, scopes, deleted_at FROM " + quote(new(v1Role)))
+	if err != nil {
+		return fmt.Errorf("fail to get role scopes, %w", err)
+	}
+
+	now := time.Now()
+	for _, row := range results {
+		id, err := strconv.ParseInt(row["id"], 10, 64)
+		if err != nil {
+			return fmt.Errorf("fail to parse role id, %w", err)
+		}
+		deletedAt, err := strconv.ParseInt(row["deleted_at"], 10, 64)
+		if err != nil {
+			return fmt.Errorf("fail to parse deleted_at of role %d, %w", id, err)
+		}
+
+		var joined string
+		if raw := row["scopes"]; raw != "" && raw != "null" {
+			if err := json.Unmarshal([]byte(raw), &joined); err != nil {
+				return fmt.Errorf("fail to parse scopes of role %d, %w", id, err)
+			}
+		}
+
+		for _, scope := range src.SliceUnique(strings.Split(joined, v1ScopesDelimiter)) {
+			if scope == "" {
+				continue
+			}
+			if _, err := session.
+				Table(new(v2RoleScope)).
+				Insert(map[string]interface{}{
+					"role_id":    id,
+					"scope":      scope,
+					"deleted_at": deletedAt,
+					"created_at": now,
+				}); err != nil {
+				return fmt.Errorf("fail to insert scope of role %d, %w", id, err)
+			}
+		}
+	}
+
+	if _, err := session.Exec("ALTER TABLE " + quote(new(v1Role)) + " DROP COLUMN `scopes`"); err != nil {
+		return fmt.Errorf("fail to drop role.scopes, %w", err)
+	}
+	return nil
+}
+
+// joinRoleScopes puts the scopes of every role back to role.scopes
+func joinRoleScopes(session *xorm.Session) error {
+	if _, err := session.Exec("ALTER TABLE " + quote(new(v1Role)) + " ADD COLUMN `scopes` TEXT"); err != nil {
+		return fmt.Errorf("fail to add role.scopes, %w", err)
+	}
+
+	roles, err := session.QueryString("SELECT id, deleted_at FROM " + quote(new(v1Role)))
+	if err != nil {
+		return fmt.Errorf("fail to get roles, %w", err)
+	}
+	rows, err := session.QueryString("SELECT role_id, scope, deleted_at FROM " + quote(new(v2RoleScope)) + " ORDER BY scope")
+	if err != nil {
+		return fmt.Errorf("fail to get role scopes, %w", err)
+	}
+
+	// the scopes of a role are the rows deleted along with it
+	scopes := make(map[[2]string][]string)
+	for _, row := range rows {
+		key := [2]string{row["role_id"], row["deleted_at"]}
+		scopes[key] = append(scopes[key], row["scope"])
+	}
+
+	for _, role := range roles {
+		joined, err := json.Marshal(strings.Join(scopes[[2]string{role["id"], role["deleted_at"]}], v1ScopesDelimiter))
+		if err != nil {
+			return err
+		}
+		if _, err := session.Exec(
+			"UPDATE "+quote(new(v1Role))+" SET `scopes`=? WHERE id=?",
+			string(joined), role["id"],
+		); err != nil {
+			return fmt.Errorf("fail to update scopes of role %s, %w", role["id"], err)
+		}
+	}
+
+	return dropTables(session, new(v2RoleScope))
+}
+
+/*
+	Version 3
+*/
+
+type v3AuditEvent struct {
+	ID         int64  `xorm:"'id' pk autoincr"`
+	Actor      string `xorm:"'actor' index"`
+	Action     string `xorm:"'action' not null"`
+	TargetType string `xorm:"'target_type' not null"`
+	TargetID   int64  `xorm:"'target_id'"`
+	Before     string `xorm:"'before_value' text"`
+	After      string `xorm:"'after_value' text"`
+	OccurredAt int64  `xorm:"'occurred_at' not null index"`
+}
+
+func (v3AuditEvent) TableName() string {
+	return src.WithDebugSuffix("audit_event")
+}
+
+/*
+	Version 4
+*/
+
+type v4Role struct {
+	ID        int64     `xorm:"'id' pk autoincr"`
+	RoleName  string    `xorm:"'role_name' unique(is_delete)"`
+	CreatedBy string    `xorm:"'created_by'"`
+	CreatedAt time.Time `xorm:"created"`
+	UpdatedBy string    `xorm:"'updated_by'"`
+	UpdatedAt time.Time `xorm:"updated"`
+	DeletedAt int64     `xorm:"deleted unique(is_delete) default(0) not null"`
+}
+
+func (v4Role) TableName() string {
+	return src.WithDebugSuffix("role")
+}
+
+type v4Authority struct {
+	ID        int64     `xorm:"'id' pk autoincr"`
+	AuthName  string    `xorm:"'authority_name' not null unique(is_delete)"`
+	CreatedBy string    `xorm:"'created_by'"`
+	CreatedAt time.Time `xorm:"created"`
+	UpdatedBy string    `xorm:"'updated_by'"`
+	UpdatedAt time.Time `xorm:"updated"`
+	DeletedAt int64     `xorm:"deleted unique(is_delete) default(0) not null"`
+}
+
+func (v4Authority) TableName() string {
+	return src.WithDebugSuffix("authority")
+}
+
+/*
+	Version 5
+*/
+
+type v5Session struct {
+	ID         int64     `xorm:"'id' pk autoincr"`
+	UserID     int64     `xorm:"'user_id' not null index"`
+	TokenHash  string    `xorm:"'token_hash' not null unique"`
+	CreatedAt  time.Time `xorm:"created"`
+	ExpiresAt  int64     `xorm:"'expires_at' not null index"`
+	LastSeenAt int64     `xorm:"'last_seen_at' not null"`
+	DeletedAt  int64     `xorm:"deleted default(0) not null"`
+}
+
+func (v5Session) TableName() string {
+	return src.WithDebugSuffix("session")
+}
+
+/*
+	Version 6
+*/
+
+type v6SigningKey struct {
+	ID          int64     `xorm:"'id' pk autoincr"`
+	KeyID       string    `xorm:"'kid' not null unique"`
+	Algorithm   string    `xorm:"'algorithm' not null"`
+	PrivateKey  string    `xorm:"'private_key' text not null"`
+	CreatedAt   time.Time `xorm:"created"`
+	ActivatedAt int64     `xorm:"'activated_at' not null"`
+	RetiredAt   int64     `xorm:"'retired_at' default(0) not null"`
+	DeletedAt   int64     `xorm:"deleted default(0) not null"`
+}
+
+func (v6SigningKey) TableName() string {
+	return src.WithDebugSuffix("signing_key")
+}
+
+/*
+	Version 7
+*/
+
+type v7RefreshToken struct {
+	ID        int64     `xorm:"'id' pk autoincr"`
+	UserID    int64     `xorm:"'user_id' not null index"`
+	FamilyID  int64     `xorm:"'family_id' not null index"`
+	TokenHash string    `xorm:"'token_hash' not null unique"`
+	CreatedAt time.Time `xorm:"created"`
+	ExpiresAt int64     `xorm:"'expires_at' not null index"`
+	UsedAt    int64     `xorm:"'used_at' default(0) not null"`
+	DeletedAt int64     `xorm:"deleted default(0) not null"`
+}
+
+func (v7RefreshToken) TableName() string {
+	return src.WithDebugSuffix("refresh_token")
+}
@@ -0,0 +1,210 @@
+package sqlstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hanzezhenalex/auth/src/datastore"
+
+	"xorm.io/builder"
+	"xorm.io/xorm"
+)
+
+/*
+	Purge
+
+	Rows soft deleted before the cutoff are removed for good, children
+	before parents so no row is left referring to a removed one. Sessions
+	and refresh tokens expired before the cutoff go the same way, revoked
+	or not. Every batch of purgeBatchSize rows runs in its own transaction,
+	large tables are never locked for long. The rows with id are walked in
+	id order from the last batch on, no batch scans what was purged before.
+*/
+
+// purgeBatchSize is a variable for tests only
+var purgeBatchSize = 500
+
+// reference is a column of a child table holding the id of a parent row
+type reference struct {
+	table  tableNamer
+	column string
+	count  *int64
+}
+
+func (store *Store) Purge(ctx context.Context, olderThan time.Duration) (*datastore.PurgeReport, error) {
+	if _, err := store.actor(ctx); err != nil {
+		return nil, err
+	}
+	if olderThan < 0 {
+		olderThan = 0
+	}
+	cutoff := time.Now().Add(-olderThan).UnixNano()
+	report := &datastore.PurgeReport{}
+
+	// step 1: rows without id, deleted on their own
+	for _, step := range []struct {
+		table tableNamer
+		keys  []string
+		count *int64
+	}{
+		{new(datastore.UserRoleBinding), []string{"user_id", "role_id", "deleted_at"}, &report.UserRoleBindings},
+		{new(datastore.RoleBinding), []string{"role_id", "auth_id", "deleted_at"}, &report.RoleBindings},
+		{new(datastore.RoleScope), []string{"role_id", "scope", "deleted_at"}, &report.RoleScopes},
+	} {
+		if err := store.purgeByKeys(ctx, cutoff, step.table, step.keys, step.count); err != nil {
+			return nil, err
+		}
+	}
+
+	// step 2: rows with id, and the rows referring to them whatever their state
+	deleted := deletedBefore(cutoff)
+	for _, step := range []struct {
+		table      tableNamer
+		cond       builder.Cond
+		count      *int64
+		references []reference
+	}{
+		{new(datastore.Session), builder.Or(deleted, builder.Lte{"expires_at": cutoff}), &report.Sessions, nil},
+		{new(datastore.RefreshToken), builder.Or(deleted, builder.Lte{"expires_at": cutoff}), &report.RefreshTokens, nil},
+		{new(datastore.SigningKey), deleted, &report.SigningKeys, nil},
+		{new(datastore.User), deleted, &report.Users, []reference{
+			{new(datastore.UserRoleBinding), "user_id", &report.UserRoleBindings},
+			{new(datastore.Session), "user_id", &report.Sessions},
+			{new(datastore.RefreshToken), "user_id", &report.RefreshTokens},
+		}},
+		{new(datastore.Role), deleted, &report.Roles, []reference{
+			{new(datastore.UserRoleBinding), "role_id", &report.UserRoleBindings},
+			{new(datastore.RoleBinding), "role_id", &report.RoleBindings},
+			{new(datastore.RoleScope), "role_id", &report.RoleScopes},
+		}},
+		{new(datastore.Authority), deleted, &report.Authorities, []reference{
+			{new(datastore.RoleBinding), "auth_id", &report.RoleBindings},
+		}},
+	} {
+		if err := store.purgeByID(ctx, step.cond, step.table, step.count, step.references...); err != nil {
+			return nil, err
+		}
+	}
+
+	// step 3: audit, in a transaction of its own like every batch
+	if *report == (datastore.PurgeReport{}) {
+		return report, nil
+	}
+	if err := store.transaction(ctx, func(session *xorm.Session) error {
+		return audit(ctx, session, datastore.AuditPurge, datastore.AuditTargetDatastore, 0,
+			nil, datastore.AuditDiff{"purged": report})
+	}); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// deletedBefore matches the rows soft deleted before the cutoff
+func deletedBefore(cutoff int64) builder.Cond {
+	return builder.Gt{"deleted_at": 0}.And(builder.Lte{"deleted_at": cutoff})
+}
+
+// purgeByKeys removes the deleted rows of a table without id, each row is
+// identified by the keys of its unique index
+func (store *Store) purgeByKeys(ctx context.Context, cutoff int64, table tableNamer, keys []string, count *int64) error {
+	for {
+		var batch int
+		if err := store.transaction(ctx, func(session *xorm.Session) error {
+			rows, err := session.
+				Table(table).
+				Unscoped().
+				Cols(keys...).
+				Where(deletedBefore(cutoff)).
+				Limit(purgeBatchSize).
+				QueryString()
+			if err != nil {
+				return fmt.Errorf("fail to get deleted rows, %w", err)
+			}
+			if batch = len(rows); batch == 0 {
+				return nil
+			}
+
+			conds := make([]builder.Cond, 0, len(rows))
+			for _, row := range rows {
+				eq := builder.Eq{}
+				for _, key := range keys {
+					eq[key] = row[key]
+				}
+				conds = append(conds, eq)
+			}
+
+			n, err := session.
+				Table(table).
+				Unscoped().
+				Where(builder.Or(conds...)).
+				Delete()
+			if err != nil {
+				return fmt.Errorf("fail to delete rows, %w", err)
+			}
+			*count += n
+			return nil
+		}); err != nil {
+			return fmt.Errorf("fail to purge %s, %w", table.TableName(), err)
+		}
+
+		if batch < purgeBatchSize {
+			return nil
+		}
+	}
+}
+
+// purgeByID removes the rows of a table matching cond, with the rows
+// referring to them in the same transaction
+func (store *Store) purgeByID(ctx context.Context, cond builder.Cond, table tableNamer, count *int64, references ...reference) error {
+	var lastID int64
+	for {
+		var ids []int64
+		if err := store.transaction(ctx, func(session *xorm.Session) error {
+			if err := session.
+				Table(table).
+				Unscoped().
+				Cols("id").
+				Where(cond).
+				And(builder.Gt{"id": lastID}).
+				OrderBy("id").
+				Limit(purgeBatchSize).
+				Find(&ids); err != nil {
+				return fmt.Errorf("fail to get deleted rows, %w", err)
+			}
+			if len(ids) == 0 {
+				return nil
+			}
+
+			for _, ref := range references {
+				n, err := session.
+					Table(ref.table).
+					Unscoped().
+					In(ref.column, ids).
+					Delete()
+				if err != nil {
+					return fmt.Errorf("fail to delete rows of %s, %w", ref.table.TableName(), err)
+				}
+				*ref.count += n
+			}
+
+			n, err := session.
+				Table(table).
+				Unscoped().
+				In("id", ids).
+				Delete()
+			if err != nil {
+				return fmt.Errorf("fail to delete rows, %w", err)
+			}
+			*count += n
+			return nil
+		}); err != nil {
+			return fmt.Errorf("fail to purge %s, %w", table.TableName(), err)
+		}
+
+		if len(ids) < purgeBatchSize {
+			return nil
+		}
+		lastID = ids[len(ids)-1]
+	}
+}
@@ -0,0 +1,43 @@
+package sqlstore
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/hanzezhenalex/auth/src"
+	"github.com/hanzezhenalex/auth/src/datastore"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPurgeInBatches(t *testing.T) {
+	rq := require.New(t)
+	ctx := context.Background()
+
+	defer func(size int) { purgeBatchSize = size }(purgeBatchSize)
+	purgeBatchSize = 2
+
+	store, err := New(newTestEngine(t, filepath.Join(t.TempDir(), "auth.db")), testDialect{}, src.DbConfig{})
+	rq.NoError(err)
+
+	var scopes []string
+	for i := 0; i < 5; i++ {
+		auth := &datastore.Authority{AuthName: fmt.Sprintf("purge_auth_%d", i)}
+		rq.NoError(store.CreateAuthority(ctx, auth))
+		rq.NoError(store.DeleteAuthorityByID(ctx, auth.ID, false))
+		scopes = append(scopes, fmt.Sprintf("scope%d", i))
+	}
+
+	role := &datastore.Role{RoleName: "purge_role", Scopes: scopes}
+	rq.NoError(store.CreateRole(ctx, role))
+	rq.NoError(store.UpdateScopesByID(ctx, role.ID, datastore.UpdateRoleScopeOption{Unassign: scopes}))
+
+	report, err := store.Purge(ctx, 0)
+	rq.NoError(err)
+	rq.Equal(datastore.PurgeReport{Authorities: 5, RoleScopes: 5}, *report)
+
+	_, err = store.GetRoleByID(ctx, role.ID)
+	rq.NoError(err)
+}
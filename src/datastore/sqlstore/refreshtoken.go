@@ -0,0 +1,168 @@
+package sqlstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hanzezhenalex/auth/src/datastore"
+
+	"xorm.io/xorm"
+)
+
+/*
+	Refresh Token
+
+	A token is used at most once, the update of used_at is conditional on
+	it being 0, so of two transactions rotating the same token only one
+	stores the next token.
+*/
+
+func (store *Store) CreateRefreshToken(ctx context.Context, token *datastore.RefreshToken) error {
+	if _, err := store.actor(ctx); err != nil {
+		return err
+	}
+
+	return store.transaction(ctx, func(session *xorm.Session) error {
+		if err := store.lockUser(session, token.UserID); err != nil {
+			return err
+		}
+
+		// step 1: insert, the family is the id of its first token
+		token.FamilyID = 0
+		if _, err := session.Insert(token); err != nil {
+			return fmt.Errorf("fail to insert refresh token, %w", err)
+		}
+		if _, err := session.
+			Table(new(datastore.RefreshToken)).
+			Where("id=?", token.ID).
+			Update(map[string]interface{}{"family_id": token.ID}); err != nil {
+			return fmt.Errorf("fail to start refresh token family, %w", err)
+		}
+		token.FamilyID = token.ID
+
+		// step 2: audit
+		return audit(ctx, session, datastore.AuditCreate, datastore.AuditTargetRefreshToken, token.ID,
+			nil, datastore.AuditDiff{"user_id": token.UserID, "family_id": token.FamilyID})
+	})
+}
+
+func (store *Store) GetRefreshTokenByTokenHash(ctx context.Context, hash string) (*datastore.RefreshToken, error) {
+	var token datastore.RefreshToken
+	if err := store.getByHash(ctx, &token, hash, datastore.ErrorRefreshTokenNotExist); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (store *Store) RotateRefreshToken(
+	ctx context.Context,
+	id int64,
+	usedAt time.Time,
+	next *datastore.RefreshToken,
+) error {
+	if _, err := store.actor(ctx); err != nil {
+		return err
+	}
+
+	return store.transaction(ctx, func(session *xorm.Session) error {
+		// step 1: mark the token used, unless it is already
+		var token datastore.RefreshToken
+		if ok, err := session.
+			ID(id).
+			Get(&token); err != nil {
+			return fmt.Errorf("fail to get refresh token %d, %w", id, err)
+		} else if !ok {
+			return datastore.ErrorRefreshTokenNotExist
+		}
+		if err := store.lockUser(session, token.UserID); err != nil {
+			return err
+		}
+
+		n, err := session.
+			Table(new(datastore.RefreshToken)).
+			Where("id=? AND used_at=0", id).
+			Update(map[string]interface{}{"used_at": usedAt.UnixNano()})
+		if err != nil {
+			return fmt.Errorf("fail to use refresh token %d, %w", id, err)
+		} else if n == 0 {
+			return datastore.ErrorRefreshTokenUsed
+		}
+
+		// step 2: insert the next token of the family
+		next.UserID = token.UserID
+		next.FamilyID = token.FamilyID
+		if _, err := session.Insert(next); err != nil {
+			return fmt.Errorf("fail to insert refresh token, %w", err)
+		}
+
+		// step 3: audit
+		return audit(ctx, session, datastore.AuditRotate, datastore.AuditTargetRefreshToken, id,
+			nil, datastore.AuditDiff{"family_id": token.FamilyID, "next_id": next.ID})
+	})
+}
+
+// RevokeRefreshTokenFamily soft delete, used tokens included
+func (store *Store) RevokeRefreshTokenFamily(ctx context.Context, familyID int64) (int64, error) {
+	if _, err := store.actor(ctx); err != nil {
+		return 0, err
+	}
+
+	var n int64
+	err := store.transaction(ctx, func(session *xorm.Session) error {
+		var token datastore.RefreshToken
+		if ok, err := session.
+			Where("family_id=?", familyID).
+			Get(&token); err != nil {
+			return fmt.Errorf("fail to get refresh token family %d, %w", familyID, err)
+		} else if !ok {
+			return nil
+		}
+
+		var err error
+		if n, err = session.
+			Table(new(datastore.RefreshToken)).
+			Where("family_id=?", familyID).
+			Update(softDeleted()); err != nil {
+			return fmt.Errorf("fail to revoke refresh token family, %w", err)
+		}
+
+		return audit(ctx, session, datastore.AuditRevoke, datastore.AuditTargetRefreshToken, familyID,
+			datastore.AuditDiff{"user_id": token.UserID, "tokens": n}, nil)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// RevokeUserRefreshTokens soft delete, every family of the user
+func (store *Store) RevokeUserRefreshTokens(ctx context.Context, userID int64) (int64, error) {
+	if _, err := store.actor(ctx); err != nil {
+		return 0, err
+	}
+
+	var n int64
+	err := store.transaction(ctx, func(session *xorm.Session) error {
+		if err := store.lockUser(session, userID); err != nil {
+			return err
+		}
+
+		var err error
+		if n, err = session.
+			Table(new(datastore.RefreshToken)).
+			Where("user_id=?", userID).
+			Update(softDeleted()); err != nil {
+			return fmt.Errorf("fail to revoke refresh tokens of user %d, %w", userID, err)
+		} else if n == 0 {
+			return nil
+		}
+
+		return audit(ctx, session, datastore.AuditRevoke, datastore.AuditTargetUser, userID,
+			datastore.AuditDiff{"refresh_tokens": n}, nil)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
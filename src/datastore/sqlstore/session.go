@@ -0,0 +1,101 @@
+package sqlstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hanzezhenalex/auth/src/datastore"
+
+	"xorm.io/xorm"
+)
+
+/*
+	Session
+*/
+
+func (store *Store) CreateSession(ctx context.Context, sess *datastore.Session) error {
+	if _, err := store.actor(ctx); err != nil {
+		return err
+	}
+
+	return store.transaction(ctx, func(session *xorm.Session) error {
+		if err := store.lockUser(session, sess.UserID); err != nil {
+			return err
+		}
+
+		if _, err := session.Insert(sess); err != nil {
+			return fmt.Errorf("fail to insert session, %w", err)
+		}
+
+		return audit(ctx, session, datastore.AuditCreate, datastore.AuditTargetSession, sess.ID,
+			nil, datastore.AuditDiff{"user_id": sess.UserID})
+	})
+}
+
+func (store *Store) GetSessionByTokenHash(ctx context.Context, hash string) (*datastore.Session, error) {
+	var sess datastore.Session
+	if err := store.getByHash(ctx, &sess, hash, datastore.ErrorSessionNotExist); err != nil {
+		return nil, err
+	}
+	return &sess, nil
+}
+
+func (store *Store) TouchSession(ctx context.Context, id int64, seenAt time.Time) error {
+	n, err := store.engine.
+		Context(ctx).
+		Table(new(datastore.Session)).
+		Where("id=?", id).
+		Update(map[string]interface{}{"last_seen_at": seenAt.UnixNano()})
+	if err != nil {
+		return fmt.Errorf("fail to touch session %d, %w", id, err)
+	} else if n == 0 {
+		return datastore.ErrorSessionNotExist
+	}
+	return nil
+}
+
+// RevokeSessionByID soft delete
+func (store *Store) RevokeSessionByID(ctx context.Context, id int64) error {
+	if _, err := store.actor(ctx); err != nil {
+		return err
+	}
+
+	return store.transaction(ctx, func(session *xorm.Session) error {
+		var sess datastore.Session
+		if ok, err := session.
+			ID(id).
+			Get(&sess); err != nil {
+			return fmt.Errorf("fail to get session %d, %w", id, err)
+		} else if !ok {
+			return datastore.ErrorSessionNotExist
+		}
+
+		if _, err := session.
+			Table(new(datastore.Session)).
+			Where("id=?", id).
+			Update(softDeleted()); err != nil {
+			return fmt.Errorf("fail to revoke session, %w", err)
+		}
+
+		return audit(ctx, session, datastore.AuditDelete, datastore.AuditTargetSession, id,
+			datastore.AuditDiff{"user_id": sess.UserID}, nil)
+	})
+}
+
+// ListUserSessions returns the sessions not revoked, expired ones included
+func (store *Store) ListUserSessions(ctx context.Context, userID int64) ([]*datastore.Session, error) {
+	if _, err := store.GetUserByID(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	var sessions []*datastore.Session
+	if err := store.engine.
+		Context(ctx).
+		Where("user_id=?", userID).
+		OrderBy("id").
+		Find(&sessions); err != nil {
+		return nil, fmt.Errorf("fail to list sessions of user %d, %w", userID, err)
+	}
+	return sessions, nil
+}
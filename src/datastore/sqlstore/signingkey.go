@@ -0,0 +1,105 @@
+package sqlstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hanzezhenalex/auth/src/datastore"
+
+	"xorm.io/xorm"
+)
+
+/*
+	Signing Key
+
+	The private key is never put in the audit events, the kid is enough to
+	follow a key.
+*/
+
+func (store *Store) CreateSigningKey(ctx context.Context, key *datastore.SigningKey) error {
+	if _, err := store.actor(ctx); err != nil {
+		return err
+	}
+
+	return store.transaction(ctx, func(session *xorm.Session) error {
+		if _, err := session.Insert(key); err != nil {
+			if store.dialect.IsDuplicated(err) {
+				return datastore.ErrorSigningKeyExist
+			}
+			return fmt.Errorf("fail to insert signing key, %w", err)
+		}
+
+		return audit(ctx, session, datastore.AuditCreate, datastore.AuditTargetSigningKey, key.ID,
+			nil, datastore.AuditDiff{"kid": key.KeyID, "algorithm": key.Algorithm})
+	})
+}
+
+func (store *Store) ListSigningKeys(ctx context.Context) ([]*datastore.SigningKey, error) {
+	var keys []*datastore.SigningKey
+	if err := store.engine.
+		Context(ctx).
+		OrderBy("id").
+		Find(&keys); err != nil {
+		return nil, fmt.Errorf("fail to list signing keys, %w", err)
+	}
+	return keys, nil
+}
+
+func (store *Store) RetireSigningKey(ctx context.Context, id int64, retiredAt time.Time) error {
+	if _, err := store.actor(ctx); err != nil {
+		return err
+	}
+
+	return store.transaction(ctx, func(session *xorm.Session) error {
+		var key datastore.SigningKey
+		if ok, err := session.
+			ID(id).
+			Get(&key); err != nil {
+			return fmt.Errorf("fail to get signing key %d, %w", id, err)
+		} else if !ok {
+			return datastore.ErrorSigningKeyNotExist
+		}
+
+		n, err := session.
+			Table(new(datastore.SigningKey)).
+			Where("id=? AND retired_at=0", id).
+			Update(map[string]interface{}{"retired_at": retiredAt.UnixNano()})
+		if err != nil {
+			return fmt.Errorf("fail to retire signing key %d, %w", id, err)
+		} else if n == 0 {
+			return nil
+		}
+
+		return audit(ctx, session, datastore.AuditRetire, datastore.AuditTargetSigningKey, id,
+			nil, datastore.AuditDiff{"kid": key.KeyID})
+	})
+}
+
+// DeleteSigningKeyByID soft delete
+func (store *Store) DeleteSigningKeyByID(ctx context.Context, id int64) error {
+	if _, err := store.actor(ctx); err != nil {
+		return err
+	}
+
+	return store.transaction(ctx, func(session *xorm.Session) error {
+		var key datastore.SigningKey
+		if ok, err := session.
+			ID(id).
+			Get(&key); err != nil {
+			return fmt.Errorf("fail to get signing key %d, %w", id, err)
+		} else if !ok {
+			return datastore.ErrorSigningKeyNotExist
+		}
+
+		if _, err := session.
+			Table(new(datastore.SigningKey)).
+			Where("id=?", id).
+			Update(softDeleted()); err != nil {
+			return fmt.Errorf("fail to delete signing key, %w", err)
+		}
+
+		return audit(ctx, session, datastore.AuditDelete, datastore.AuditTargetSigningKey, id,
+			datastore.AuditDiff{"kid": key.KeyID}, nil)
+	})
+}
@@ -0,0 +1,1294 @@
+package sqlstore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hanzezhenalex/auth/src"
+	"github.com/hanzezhenalex/auth/src/datastore"
+
+	"xorm.io/builder"
+	"xorm.io/xorm"
+)
+
+// Dialect covers what differs between the databases behind Store
+type Dialect interface {
+	// IsDuplicated reports whether err violates a unique index
+	IsDuplicated(err error) bool
+	// RowLock reports whether "SELECT ... FOR UPDATE" is supported
+	RowLock() bool
+}
+
+// Store implements datastore.Datastore on top of a xorm engine
+type Store struct {
+	engine       *xorm.Engine
+	dialect      Dialect
+	requireActor bool
+}
+
+func New(engine *xorm.Engine, dialect Dialect, cfg src.DbConfig) (*Store, error) {
+	store := &Store{engine: engine, dialect: dialect, requireActor: cfg.RequireActor}
+
+	if src.IsDebugMode() {
+		store.engine.ShowSQL(true)
+	}
+
+	if err := store.onBoarding(); err != nil {
+		return nil, fmt.Errorf("fail to onboarding: %w", err)
+	}
+
+	return store, nil
+}
+
+func (store *Store) tables() []interface{} {
+	return []interface{}{
+		new(datastore.User),
+		new(datastore.Authority),
+		new(datastore.Role),
+		new(datastore.RoleScope),
+		new(datastore.RoleBinding),
+		new(datastore.UserRoleBinding),
+		new(datastore.AuditEvent),
+		new(datastore.Session),
+		new(datastore.SigningKey),
+		new(datastore.RefreshToken),
+	}
+}
+
+func (store *Store) onBoarding() error {
+	if err := store.Migrate(context.Background(), datastore.LatestSchemaVersion); err != nil {
+		return fmt.Errorf("fail to migrate tables, err=%w", err)
+	}
+	return nil
+}
+
+// Cleanup hard deletes all the data, for tests only
+func (store *Store) Cleanup() error {
+	tables := store.tables()
+
+	for _, table := range tables {
+		if _, err := store.engine.
+			Table(table).
+			Where("1=1").
+			Unscoped().
+			Delete(); err != nil {
+			name := table.(interface{ TableName() string }).TableName()
+			return fmt.Errorf("fail to delete data in table %s, err=%w", name, err)
+		}
+	}
+	return nil
+}
+
+func (store *Store) transaction(ctx context.Context, fn func(*xorm.Session) error) error {
+	session := store.engine.NewSession().Context(ctx)
+	defer func() { _ = session.Close() }()
+	rollback := func() { _ = session.Rollback() }
+
+	if err := session.Begin(); err != nil {
+		return fmt.Errorf("fail to start session: %w", err)
+	}
+
+	if err := fn(session); err != nil {
+		rollback()
+		return err
+	}
+
+	if err := session.Commit(); err != nil {
+		return fmt.Errorf("fail to commit session: %w", err)
+	}
+	return nil
+}
+
+type tableNamer interface {
+	TableName() string
+}
+
+// lockByID locks the row with id until the end of the transaction. It is
+// a no-op for databases which serialize write transactions anyway.
+func (store *Store) lockByID(session *xorm.Session, table tableNamer, id int64) error {
+	if !store.dialect.RowLock() {
+		return nil
+	}
+
+	query := fmt.Sprintf("SELECT id FROM %s WHERE id=? FOR UPDATE", store.engine.Quote(table.TableName()))
+	if _, err := session.QueryString(query, id); err != nil {
+		return fmt.Errorf("fail to lock %s %d, %w", table.TableName(), id, err)
+	}
+	return nil
+}
+
+// getByHash gets the live row of bean by its token hash, notExist if none
+func (store *Store) getByHash(ctx context.Context, bean interface{}, hash string, notExist error) error {
+	// an empty hash names no token, it must never reach the query
+	if hash == "" {
+		return notExist
+	}
+
+	if ok, err := store.engine.Context(ctx).Where("token_hash=?", hash).Get(bean); err != nil {
+		return err
+	} else if !ok {
+		return notExist
+	}
+	return nil
+}
+
+// softDeleted is the update marking rows deleted. deleted_at is set in
+// nanoseconds rather than xorm's seconds, so deleting the same name twice
+// within a second does not collide on the unique(is_delete) indexes.
+func softDeleted() map[string]interface{} {
+	return map[string]interface{}{"deleted_at": time.Now().UnixNano()}
+}
+
+// restored is the update undoing softDeleted
+func restored() map[string]interface{} {
+	return map[string]interface{}{"deleted_at": 0}
+}
+
+// updatedBy adds the actor to an update of a role or an authority, xorm
+// sets updated_at along with it
+func updatedBy(update map[string]interface{}, actor string) map[string]interface{} {
+	c := make(map[string]interface{}, len(update)+1)
+	for k, v := range update {
+		c[k] = v
+	}
+	c["updated_by"] = actor
+	return c
+}
+
+// actor returns the actor of ctx, see src.DbConfig.RequireActor
+func (store *Store) actor(ctx context.Context) (string, error) {
+	return datastore.CheckActor(ctx, store.requireActor)
+}
+
+/*
+	User
+*/
+
+func (store *Store) CreateUser(ctx context.Context, user *datastore.User) error {
+	if _, err := store.actor(ctx); err != nil {
+		return err
+	}
+
+	return store.transaction(ctx, func(session *xorm.Session) error {
+		if _, err := session.Insert(user); err != nil {
+			if store.dialect.IsDuplicated(err) {
+				return datastore.ErrorUserExist
+			}
+			return err
+		}
+
+		return audit(ctx, session, datastore.AuditCreate, datastore.AuditTargetUser, user.ID,
+			nil, datastore.AuditDiff{"user_name": user.Username})
+	})
+}
+
+// DeleteUserByID soft delete
+func (store *Store) DeleteUserByID(ctx context.Context, id int64) error {
+	if _, err := store.actor(ctx); err != nil {
+		return err
+	}
+
+	return store.transaction(ctx, func(session *xorm.Session) error {
+		// step 1: delete user-role bindings
+		if _, err := session.
+			Table(new(datastore.UserRoleBinding)).
+			Where("user_id=?", id).
+			Update(softDeleted()); err != nil {
+			return fmt.Errorf("fail to delete user role bindings, %w", err)
+		}
+
+		// step 2: revoke the sessions and the refresh tokens of the user
+		for _, table := range []tableNamer{new(datastore.Session), new(datastore.RefreshToken)} {
+			if _, err := session.
+				Table(table).
+				Where("user_id=?", id).
+				Update(softDeleted()); err != nil {
+				return fmt.Errorf("fail to revoke user %s, %w", table.TableName(), err)
+			}
+		}
+
+		// step 3: delete user
+		var user datastore.User
+		if ok, err := session.
+			ID(id).
+			Get(&user); err != nil {
+			return fmt.Errorf("fail to get user %d, %w", id, err)
+		} else if !ok {
+			return datastore.ErrorUserNotExist
+		}
+
+		if _, err := session.
+			Table(new(datastore.User)).
+			Where("id=?", id).
+			Update(softDeleted()); err != nil {
+			return fmt.Errorf("fail to delete user, %w", err)
+		}
+
+		// step 4: audit
+		return audit(ctx, session, datastore.AuditDelete, datastore.AuditTargetUser, id,
+			datastore.AuditDiff{"user_name": user.Username}, nil)
+	})
+}
+
+func (store *Store) GetUserByID(ctx context.Context, id int64) (*datastore.User, error) {
+	user := datastore.User{ID: id}
+
+	if ok, err := store.engine.Context(ctx).Get(&user); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, datastore.ErrorUserNotExist
+	}
+
+	return &user, nil
+}
+
+func (store *Store) GetUserByName(ctx context.Context, name string) (*datastore.User, error) {
+	user := datastore.User{Username: name}
+
+	if ok, err := store.engine.Context(ctx).Get(&user); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, datastore.ErrorUserNotExist
+	}
+
+	return &user, nil
+}
+
+// UpdateUser overwrites the name, password and reserve of the user
+func (store *Store) UpdateUser(ctx context.Context, user *datastore.User) error {
+	if _, err := store.actor(ctx); err != nil {
+		return err
+	}
+
+	return store.transaction(ctx, func(session *xorm.Session) error {
+		if err := store.lockByID(session, new(datastore.User), user.ID); err != nil {
+			return err
+		}
+
+		var origin datastore.User
+		if ok, err := session.
+			ID(user.ID).
+			Get(&origin); err != nil {
+			return fmt.Errorf("fail to get user %d, %w", user.ID, err)
+		} else if !ok {
+			return datastore.ErrorUserNotExist
+		}
+
+		if _, err := session.
+			ID(user.ID).
+			Cols("user_name", "password", "reserve").
+			Update(user); err != nil {
+			if store.dialect.IsDuplicated(err) {
+				return datastore.ErrorUserExist
+			}
+			return fmt.Errorf("fail to update user %d, %w", user.ID, err)
+		}
+
+		before, after := datastore.UserAuditDiff(&origin, user)
+		if len(after) == 0 {
+			return nil
+		}
+		return audit(ctx, session, datastore.AuditUpdate, datastore.AuditTargetUser, user.ID, before, after)
+	})
+}
+
+/*
+	User Role
+*/
+
+func (store *Store) lockUser(session *xorm.Session, id int64) error {
+	if err := store.lockByID(session, new(datastore.User), id); err != nil {
+		return err
+	}
+
+	var user datastore.User
+	if ok, err := session.
+		ID(id).
+		Get(&user); err != nil {
+		return fmt.Errorf("fail to get user %d, %w", id, err)
+	} else if !ok {
+		return datastore.ErrorUserNotExist
+	}
+	return nil
+}
+
+// getUserRoles returns the active roles of a user, keyed by role name
+func getUserRoles(session *xorm.Session, id int64) (map[string]int64, error) {
+	results, err := session.QueryString(getActiveUserRoles(id))
+	if err != nil {
+		return nil, fmt.Errorf("fail to get user role binding, %w", err)
+	}
+
+	roles := make(map[string]int64, len(results))
+	for _, urb := range results {
+		roleID, err := strconv.ParseInt(urb["role_id"], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("fail to parse role id, %w", err)
+		}
+		roles[urb["role_name"]] = roleID
+	}
+	return roles, nil
+}
+
+func roleNames(roles map[string]int64) []string {
+	names := make([]string, 0, len(roles))
+	for name := range roles {
+		names = append(names, name)
+	}
+	return names
+}
+
+func (store *Store) AssignRoles(ctx context.Context, userID int64, roles []string) error {
+	if _, err := store.actor(ctx); err != nil {
+		return err
+	}
+
+	return store.transaction(ctx, func(session *xorm.Session) error {
+		// step 1: lock user
+		if err := store.lockUser(session, userID); err != nil {
+			return err
+		}
+
+		// step 2: find roles not assigned yet, assigned ones are ignored
+		assigned, err := getUserRoles(session, userID)
+		if err != nil {
+			return err
+		}
+		_, toAssign := src.SliceRemove(roleNames(assigned), src.SliceUnique(roles))
+		if len(toAssign) == 0 {
+			return nil
+		}
+
+		// step 3: fetch roles and check if all of them exist
+		var found []datastore.Role
+		if err := session.In("role_name", toAssign).
+			Table(new(datastore.Role)).
+			Find(&found); err != nil {
+			return fmt.Errorf("fail to fetch roles: %w", err)
+		}
+		if len(found) != len(toAssign) {
+			return datastore.ErrorRoleNotExist
+		}
+
+		// step 4: insert user-role bindings
+		urbs := make([]datastore.UserRoleBinding, 0, len(found))
+		for _, role := range found {
+			urbs = append(urbs, datastore.UserRoleBinding{
+				UserID:   userID,
+				RoleID:   role.ID,
+				RoleName: role.RoleName,
+			})
+		}
+		if _, err := session.InsertMulti(&urbs); err != nil {
+			return fmt.Errorf("fail to insert urbs, %w", err)
+		}
+
+		// step 5: audit
+		return audit(ctx, session, datastore.AuditAssignRoles, datastore.AuditTargetUser, userID,
+			nil, datastore.AuditDiff{}.WithList("roles", toAssign))
+	})
+}
+
+func (store *Store) UnassignRoles(ctx context.Context, userID int64, roles []string) error {
+	if _, err := store.actor(ctx); err != nil {
+		return err
+	}
+
+	return store.transaction(ctx, func(session *xorm.Session) error {
+		// step 1: lock user
+		if err := store.lockUser(session, userID); err != nil {
+			return err
+		}
+
+		// step 2: all roles to unassign must be assigned
+		assigned, err := getUserRoles(session, userID)
+		if err != nil {
+			return err
+		}
+		roles = src.SliceUnique(roles)
+		_, nonExisted := src.SliceRemove(roleNames(assigned), roles)
+		if len(nonExisted) > 0 {
+			return datastore.ErrorUnassignNonExistedRoles
+		}
+		if len(roles) == 0 {
+			return nil
+		}
+
+		// step 3: delete user-role bindings
+		roleIDs := make([]int64, 0, len(roles))
+		for _, name := range roles {
+			roleIDs = append(roleIDs, assigned[name])
+		}
+		if _, err := session.
+			Table(new(datastore.UserRoleBinding)).
+			Where("user_id=?", userID).
+			In("role_id", roleIDs).
+			Update(softDeleted()); err != nil {
+			return fmt.Errorf("fail to delete user role bindings, %w", err)
+		}
+
+		// step 4: audit
+		return audit(ctx, session, datastore.AuditUnassignRoles, datastore.AuditTargetUser, userID,
+			datastore.AuditDiff{}.WithList("roles", roles), nil)
+	})
+}
+
+func (store *Store) ListUserRoles(ctx context.Context, userID int64) ([]string, error) {
+	var roles []string
+	err := store.transaction(ctx, func(session *xorm.Session) error {
+		var user datastore.User
+		if ok, err := session.
+			ID(userID).
+			Get(&user); err != nil {
+			return fmt.Errorf("fail to get user %d, %w", userID, err)
+		} else if !ok {
+			return datastore.ErrorUserNotExist
+		}
+
+		assigned, err := getUserRoles(session, userID)
+		if err != nil {
+			return err
+		}
+		roles = roleNames(assigned)
+		src.SortSliceAsc(roles)
+		return nil
+	})
+	return roles, err
+}
+
+func (store *Store) GetUserPermissions(ctx context.Context, userID int64) (*datastore.Permission, error) {
+	query, args, err := getUserPermissions(userID)
+	if err != nil {
+		return nil, fmt.Errorf("fail to build user permissions query, %w", err)
+	}
+	results, err := store.engine.Context(ctx).QueryString(append([]interface{}{query}, args...)...)
+	if err != nil {
+		return nil, fmt.Errorf("fail to get user permissions, %w", err)
+	}
+	if len(results) == 0 {
+		return nil, datastore.ErrorUserNotExist
+	}
+
+	var (
+		perm  datastore.Permission
+		roles = make(map[string]*datastore.Role)
+	)
+	for _, row := range results {
+		if row["role_id"] == "" {
+			// user without any role
+			continue
+		}
+
+		role, ok := roles[row["role_id"]]
+		if !ok {
+			role = &datastore.Role{RoleName: row["role_name"]}
+			if role.ID, err = strconv.ParseInt(row["role_id"], 10, 64); err != nil {
+				return nil, fmt.Errorf("fail to parse role id, %w", err)
+			}
+			roles[row["role_id"]] = role
+			perm.Roles = append(perm.Roles, role)
+		}
+
+		name := row["name"]
+		if name == "" {
+			// role without scope or without authority
+			continue
+		}
+		switch row["kind"] {
+		case "scope":
+			role.Scopes, _ = src.SliceAppend(role.Scopes, []string{name})
+			perm.Scopes, _ = src.SliceAppend(perm.Scopes, []string{name})
+		case "authority":
+			role.Auths, _ = src.SliceAppend(role.Auths, []string{name})
+			perm.Auths, _ = src.SliceAppend(perm.Auths, []string{name})
+		}
+	}
+
+	sort.Slice(perm.Roles, func(i, j int) bool {
+		return perm.Roles[i].RoleName < perm.Roles[j].RoleName
+	})
+	for _, role := range perm.Roles {
+		src.SortSliceAsc(role.Scopes, role.Auths)
+	}
+	src.SortSliceAsc(perm.Scopes, perm.Auths)
+	return &perm, nil
+}
+
+/*
+	Authority
+*/
+
+func (store *Store) CreateAuthority(ctx context.Context, auth *datastore.Authority) error {
+	actor, err := store.actor(ctx)
+	if err != nil {
+		return err
+	}
+
+	// the actor of ctx, if any, is trusted over the caller
+	if actor != "" {
+		auth.CreatedBy = actor
+	}
+	auth.UpdatedBy = auth.CreatedBy
+
+	return store.transaction(ctx, func(session *xorm.Session) error {
+		if _, err := session.Insert(auth); err != nil {
+			if store.dialect.IsDuplicated(err) {
+				return datastore.ErrorAuthExist
+			}
+			return err
+		}
+
+		return audit(ctx, session, datastore.AuditCreate, datastore.AuditTargetAuthority, auth.ID,
+			nil, datastore.AuditDiff{"authority_name": auth.AuthName})
+	})
+}
+
+// DeleteAuthorityByID soft delete
+func (store *Store) DeleteAuthorityByID(ctx context.Context, id int64, force bool) error {
+	actor, err := store.actor(ctx)
+	if err != nil {
+		return err
+	}
+
+	return store.transaction(ctx, func(session *xorm.Session) error {
+		if !force {
+			cnt, err := session.
+				Where("auth_id=?", id).
+				Count(new(datastore.RoleBinding))
+			if err != nil {
+				return fmt.Errorf("fail to count role bindings, %w", err)
+			}
+			if cnt != 0 {
+				return datastore.ErrorDeleteAuthWithBinding
+			}
+		}
+
+		var auth datastore.Authority
+		if ok, err := session.
+			ID(id).
+			Get(&auth); err != nil {
+			return fmt.Errorf("fail to get authority %d, %w", id, err)
+		} else if !ok {
+			return datastore.ErrorAuthNotExist
+		}
+
+		if _, err := session.
+			Table(new(datastore.Authority)).
+			Where("id=?", id).
+			Update(updatedBy(softDeleted(), actor)); err != nil {
+			return err
+		}
+
+		action := datastore.AuditDelete
+		if force {
+			action = datastore.AuditForceDelete
+		}
+		return audit(ctx, session, action, datastore.AuditTargetAuthority, id,
+			datastore.AuditDiff{"authority_name": auth.AuthName}, nil)
+	})
+}
+
+// RestoreAuthorityByID undeletes an authority, the roles still bound to it
+// get it back. Restoring a live authority does nothing.
+func (store *Store) RestoreAuthorityByID(ctx context.Context, id int64) error {
+	actor, err := store.actor(ctx)
+	if err != nil {
+		return err
+	}
+
+	return store.transaction(ctx, func(session *xorm.Session) error {
+		// step 1: lock authority
+		if err := store.lockByID(session, new(datastore.Authority), id); err != nil {
+			return err
+		}
+
+		var auth datastore.Authority
+		if ok, err := session.
+			Unscoped().
+			ID(id).
+			Get(&auth); err != nil {
+			return fmt.Errorf("fail to get authority %d, %w", id, err)
+		} else if !ok {
+			return datastore.ErrorAuthNotExist
+		}
+		if auth.DeletedAt == 0 {
+			return nil
+		}
+
+		// step 2: restore, unless a live one has taken the name
+		if _, err := session.
+			Table(new(datastore.Authority)).
+			Unscoped().
+			Where("id=?", id).
+			Update(updatedBy(restored(), actor)); err != nil {
+			if store.dialect.IsDuplicated(err) {
+				return datastore.ErrorAuthExist
+			}
+			return fmt.Errorf("fail to restore authority %d, %w", id, err)
+		}
+
+		// step 3: audit
+		return audit(ctx, session, datastore.AuditRestore, datastore.AuditTargetAuthority, id,
+			nil, datastore.AuditDiff{"authority_name": auth.AuthName})
+	})
+}
+
+func (store *Store) GetAuthorityByID(ctx context.Context, id int64) (*datastore.Authority, error) {
+	auth := datastore.Authority{ID: id}
+
+	if ok, err := store.engine.Context(ctx).Get(&auth); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, datastore.ErrorAuthNotExist
+	}
+
+	return &auth, nil
+}
+
+func (store *Store) GetAuthorityByName(ctx context.Context, name string) (*datastore.Authority, error) {
+	auth := datastore.Authority{AuthName: name}
+
+	if ok, err := store.engine.Context(ctx).Get(&auth); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, datastore.ErrorAuthNotExist
+	}
+
+	return &auth, nil
+}
+
+func (store *Store) ListAuthorities(
+	ctx context.Context,
+	opt datastore.ListOption,
+) ([]*datastore.Authority, string, error) {
+	session := store.engine.Context(ctx)
+	if err := listCond(session, opt, "authority_name"); err != nil {
+		return nil, "", err
+	}
+
+	var auths []*datastore.Authority
+	if err := session.Find(&auths); err != nil {
+		return nil, "", fmt.Errorf("fail to list authorities, %w", err)
+	}
+
+	var next string
+	if limit := opt.Limit(); len(auths) > limit {
+		auths = auths[:limit]
+		next = datastore.NextPageToken(auths[limit-1].ID)
+	}
+	return auths, next, nil
+}
+
+// listCond applies the filters of a listing to session, one more row than
+// the page size is fetched to know whether there is a next page
+func listCond(session *xorm.Session, opt datastore.ListOption, nameCol string) error {
+	after, err := opt.After()
+	if err != nil {
+		return err
+	}
+
+	session.Where("id>?", after)
+	if opt.NamePrefix != "" {
+		session.And(likePrefix(nameCol, opt.NamePrefix))
+	}
+	if opt.CreatedBy != "" {
+		session.And("created_by=?", opt.CreatedBy)
+	}
+	if opt.IncludeDeleted {
+		session.Unscoped()
+	}
+	session.OrderBy("id").Limit(opt.Limit() + 1)
+	return nil
+}
+
+/*
+	Role
+*/
+
+func (store *Store) CreateRole(ctx context.Context, role *datastore.Role) error {
+	actor, err := store.actor(ctx)
+	if err != nil {
+		return err
+	}
+
+	// the actor of ctx, if any, is trusted over the caller
+	if actor != "" {
+		role.CreatedBy = actor
+	}
+	role.UpdatedBy = role.CreatedBy
+
+	return store.transaction(ctx, func(session *xorm.Session) error {
+		// step 1: insert role
+		if _, err := session.Insert(role); err != nil {
+			if store.dialect.IsDuplicated(err) {
+				return datastore.ErrorRoleExist
+			}
+			return fmt.Errorf("fail to insert role: %w", err)
+		}
+
+		// step 2: insert scopes
+		if err := insertRoleScopes(session, role.ID, src.SliceUnique(role.Scopes)); err != nil {
+			return err
+		}
+
+		// step 3: fetch authorities
+		var auths []datastore.Authority
+		if err := session.In("authority_name", role.Auths).
+			Table(new(datastore.Authority)).
+			Find(&auths); err != nil {
+			return fmt.Errorf("fail to fetch auths: %w", err)
+		}
+
+		// step 4: check if all needed authorities exist
+		if len(auths) != len(role.Auths) {
+			return datastore.ErrorAuthNotExist
+		}
+
+		// step 5: insert role-auth relationship if needed
+		if len(auths) > 0 {
+			rbs := make([]datastore.RoleBinding, 0, len(auths))
+			for _, auth := range auths {
+				rbs = append(rbs, datastore.RoleBinding{
+					RoleID:   role.ID,
+					AuthID:   auth.ID,
+					AuthName: auth.AuthName,
+				})
+			}
+			if _, err := session.InsertMulti(&rbs); err != nil {
+				return fmt.Errorf("fail to insert rbs, %w", err)
+			}
+		}
+
+		// step 6: audit
+		after := datastore.AuditDiff{"role_name": role.RoleName}.
+			WithList("scopes", src.SliceUnique(role.Scopes)).
+			WithList("auths", role.Auths)
+		return audit(ctx, session, datastore.AuditCreate, datastore.AuditTargetRole, role.ID, nil, after)
+	})
+}
+
+func (store *Store) DeleteRoleByID(ctx context.Context, id int64) error {
+	actor, err := store.actor(ctx)
+	if err != nil {
+		return err
+	}
+
+	return store.transaction(ctx, func(session *xorm.Session) error {
+		// the role and its rows share the same deleted_at
+		deleted := softDeleted()
+
+		// step 1: delete role-auth bindings
+		if _, err := session.
+			Table(new(datastore.RoleBinding)).
+			Where("role_id=?", id).
+			Update(deleted); err != nil {
+			return fmt.Errorf("fail to delete role bindings, %w", err)
+		}
+
+		// step 2: delete user-role bindings
+		if _, err := session.
+			Table(new(datastore.UserRoleBinding)).
+			Where("role_id=?", id).
+			Update(deleted); err != nil {
+			return fmt.Errorf("fail to delete user role bindings, %w", err)
+		}
+
+		// step 3: delete scopes
+		if _, err := session.
+			Table(new(datastore.RoleScope)).
+			Where("role_id=?", id).
+			Update(deleted); err != nil {
+			return fmt.Errorf("fail to delete role scopes, %w", err)
+		}
+
+		// step 4: delete role
+		var role datastore.Role
+		if ok, err := session.
+			ID(id).
+			Get(&role); err != nil {
+			return fmt.Errorf("fail to get role %d, %w", id, err)
+		} else if !ok {
+			return datastore.ErrorRoleNotExist
+		}
+
+		if _, err := session.
+			Table(new(datastore.Role)).
+			Where("id=?", id).
+			Update(updatedBy(deleted, actor)); err != nil {
+			return fmt.Errorf("fail to delete role, %w", err)
+		}
+
+		// step 5: audit
+		return audit(ctx, session, datastore.AuditDelete, datastore.AuditTargetRole, id,
+			datastore.AuditDiff{"role_name": role.RoleName}, nil)
+	})
+}
+
+// RestoreRoleByID undeletes a role with the scopes and the authority
+// bindings deleted along with it. Users are not assigned to it again.
+// Restoring a live role does nothing.
+func (store *Store) RestoreRoleByID(ctx context.Context, id int64) error {
+	actor, err := store.actor(ctx)
+	if err != nil {
+		return err
+	}
+
+	return store.transaction(ctx, func(session *xorm.Session) error {
+		// step 1: lock role
+		if err := store.lockByID(session, new(datastore.Role), id); err != nil {
+			return err
+		}
+
+		var role datastore.Role
+		if ok, err := session.
+			Unscoped().
+			ID(id).
+			Get(&role); err != nil {
+			return fmt.Errorf("fail to get role %d, %w", id, err)
+		} else if !ok {
+			return datastore.ErrorRoleNotExist
+		}
+		if role.DeletedAt == 0 {
+			return nil
+		}
+
+		// step 2: restore role, unless a live one has taken the name
+		if _, err := session.
+			Table(new(datastore.Role)).
+			Unscoped().
+			Where("id=?", id).
+			Update(updatedBy(restored(), actor)); err != nil {
+			if store.dialect.IsDuplicated(err) {
+				return datastore.ErrorRoleExist
+			}
+			return fmt.Errorf("fail to restore role %d, %w", id, err)
+		}
+
+		// step 3: restore scopes and role-auth bindings sharing its deleted_at
+		for _, table := range []tableNamer{new(datastore.RoleScope), new(datastore.RoleBinding)} {
+			if _, err := session.
+				Table(table).
+				Unscoped().
+				Where("role_id=? AND deleted_at=?", id, role.DeletedAt).
+				Update(restored()); err != nil {
+				return fmt.Errorf("fail to restore %s of role %d, %w", table.TableName(), id, err)
+			}
+		}
+
+		// step 4: audit
+		return audit(ctx, session, datastore.AuditRestore, datastore.AuditTargetRole, id,
+			nil, datastore.AuditDiff{"role_name": role.RoleName})
+	})
+}
+
+func (store *Store) GetRoleByID(ctx context.Context, id int64) (*datastore.Role, error) {
+	var role datastore.Role
+	err := store.transaction(ctx, func(session *xorm.Session) error {
+		if ok, err := session.
+			ID(id).
+			Get(&role); err != nil {
+			return fmt.Errorf("fail to get role: %w", err)
+		} else if !ok {
+			return datastore.ErrorRoleNotExist
+		}
+
+		if err := fetchRoleScopes(session, &role); err != nil {
+			return err
+		}
+		return fetchRoleAuths(session, &role)
+	})
+	return &role, err
+}
+
+func (store *Store) GetRoleByName(ctx context.Context, name string) (*datastore.Role, error) {
+	var role datastore.Role
+	err := store.transaction(ctx, func(session *xorm.Session) error {
+		if ok, err := session.
+			Where("role_name=?", name).
+			Get(&role); err != nil {
+			return fmt.Errorf("fail to get role: %w", err)
+		} else if !ok {
+			return datastore.ErrorRoleNotExist
+		}
+
+		if err := fetchRoleScopes(session, &role); err != nil {
+			return err
+		}
+		return fetchRoleAuths(session, &role)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+func insertRoleScopes(session *xorm.Session, roleID int64, scopes []string) error {
+	if len(scopes) == 0 {
+		return nil
+	}
+
+	rows := make([]datastore.RoleScope, 0, len(scopes))
+	for _, scope := range scopes {
+		rows = append(rows, datastore.RoleScope{RoleID: roleID, Scope: scope})
+	}
+	if _, err := session.InsertMulti(&rows); err != nil {
+		return fmt.Errorf("fail to insert role scopes, %w", err)
+	}
+	return nil
+}
+
+// fetchRoleScopes fills the scopes of the roles in order. The scopes of a
+// deleted role are the ones deleted with it.
+func fetchRoleScopes(session *xorm.Session, roles ...*datastore.Role) error {
+	ids := make([]int64, 0, len(roles))
+	byID := make(map[int64]*datastore.Role, len(roles))
+	for _, role := range roles {
+		ids = append(ids, role.ID)
+		byID[role.ID] = role
+	}
+
+	var rows []*datastore.RoleScope
+	if err := session.
+		Unscoped().
+		In("role_id", ids).
+		OrderBy("scope").
+		Find(&rows); err != nil {
+		return fmt.Errorf("fail to get role scopes, %w", err)
+	}
+
+	for _, row := range rows {
+		if role, ok := byID[row.RoleID]; ok && row.DeletedAt == role.DeletedAt {
+			role.Scopes = append(role.Scopes, row.Scope)
+		}
+	}
+	return nil
+}
+
+func fetchRoleAuths(session *xorm.Session, role *datastore.Role) error {
+	results, err := session.QueryString(getActiveRoleAuthNames(role.ID))
+	if err != nil {
+		return fmt.Errorf("fail to get role binding, %w", err)
+	}
+
+	for _, rb := range results {
+		role.Auths = append(role.Auths, rb["auth_name"])
+	}
+	return nil
+}
+
+func (store *Store) ListRoles(ctx context.Context, opt datastore.ListOption) ([]*datastore.Role, string, error) {
+	var (
+		roles []*datastore.Role
+		next  string
+	)
+	err := store.transaction(ctx, func(session *xorm.Session) error {
+		if err := listCond(session, opt, "role_name"); err != nil {
+			return err
+		}
+		if err := session.Find(&roles); err != nil {
+			return fmt.Errorf("fail to list roles, %w", err)
+		}
+
+		if limit := opt.Limit(); len(roles) > limit {
+			roles = roles[:limit]
+			next = datastore.NextPageToken(roles[limit-1].ID)
+		}
+		if len(roles) == 0 {
+			return nil
+		}
+
+		return fetchRolesDetails(session, roles...)
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return roles, next, nil
+}
+
+// fetchRolesDetails fills the scopes and the sorted authorities of the roles
+func fetchRolesDetails(session *xorm.Session, roles ...*datastore.Role) error {
+	if len(roles) == 0 {
+		return nil
+	}
+
+	if err := fetchRoleScopes(session, roles...); err != nil {
+		return err
+	}
+
+	ids := make([]int64, 0, len(roles))
+	byID := make(map[int64]*datastore.Role, len(roles))
+	for _, role := range roles {
+		ids = append(ids, role.ID)
+		byID[role.ID] = role
+	}
+
+	results, err := session.QueryString(getActiveRoleAuthNames(ids...))
+	if err != nil {
+		return fmt.Errorf("fail to get role binding, %w", err)
+	}
+	for _, rb := range results {
+		roleID, err := strconv.ParseInt(rb["role_id"], 10, 64)
+		if err != nil {
+			return fmt.Errorf("fail to parse role id, %w", err)
+		}
+		if role, ok := byID[roleID]; ok {
+			role.Auths = append(role.Auths, rb["auth_name"])
+		}
+	}
+	for _, role := range roles {
+		src.SortSliceAsc(role.Auths)
+	}
+	return nil
+}
+
+// ListRolesByAuthority returns the live roles bound to a live authority
+func (store *Store) ListRolesByAuthority(ctx context.Context, authID int64) ([]*datastore.Role, error) {
+	var roles []*datastore.Role
+	err := store.transaction(ctx, func(session *xorm.Session) error {
+		// step 1: check authority
+		var auth datastore.Authority
+		if ok, err := session.
+			ID(authID).
+			Get(&auth); err != nil {
+			return fmt.Errorf("fail to get authority %d, %w", authID, err)
+		} else if !ok {
+			return datastore.ErrorAuthNotExist
+		}
+
+		// step 2: fetch bound roles
+		var err error
+		roles, err = findRolesByIDs(session, getRoleIDsByAuthority(authID))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// ListRolesByScope returns the live roles granting exactly the scope
+func (store *Store) ListRolesByScope(ctx context.Context, scope string) ([]*datastore.Role, error) {
+	var roles []*datastore.Role
+	err := store.transaction(ctx, func(session *xorm.Session) error {
+		var err error
+		roles, err = findRolesByIDs(session, getRoleIDsByScope(scope))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// findRolesByIDs returns the live roles, in id order, whose id is in the
+// role_id column of the query
+func findRolesByIDs(session *xorm.Session, query *builder.Builder) ([]*datastore.Role, error) {
+	results, err := session.QueryString(query)
+	if err != nil {
+		return nil, fmt.Errorf("fail to get role ids, %w", err)
+	}
+	if len(results) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]int64, 0, len(results))
+	for _, row := range results {
+		id, err := strconv.ParseInt(row["role_id"], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("fail to parse role id, %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	var roles []*datastore.Role
+	if err := session.
+		In("id", ids).
+		OrderBy("id").
+		Find(&roles); err != nil {
+		return nil, fmt.Errorf("fail to get roles, %w", err)
+	}
+	return roles, fetchRolesDetails(session, roles...)
+}
+
+func (store *Store) UpdateScopesByID(ctx context.Context, id int64, op datastore.UpdateRoleScopeOption) error {
+	actor, err := store.actor(ctx)
+	if err != nil {
+		return err
+	}
+
+	return store.transaction(ctx, func(session *xorm.Session) error {
+		// step 1: lock role
+		if err := store.lockByID(session, new(datastore.Role), id); err != nil {
+			return err
+		}
+
+		var role datastore.Role
+		if ok, err := session.
+			ID(id).
+			Get(&role); err != nil {
+			return fmt.Errorf("fail to get role %d, %w", id, err)
+		} else if !ok {
+			return datastore.ErrorRoleNotExist
+		}
+
+		// step 2: fetch assigned scopes
+		var rows []*datastore.RoleScope
+		if err := session.
+			Where("role_id=?", id).
+			Find(&rows); err != nil {
+			return fmt.Errorf("fail to get role scopes, %w", err)
+		}
+		assigned := make([]string, 0, len(rows))
+		for _, row := range rows {
+			assigned = append(assigned, row.Scope)
+		}
+
+		// step 3: diff
+		scopesAppend, _ := src.SliceAppend(append([]string(nil), assigned...), src.SliceUnique(op.Assign))
+		scopesRemoved, nonExisted := src.SliceRemove(scopesAppend, src.SliceUnique(op.Unassign))
+		if len(nonExisted) > 0 {
+			return datastore.ErrorUnassignNonExistedScopes
+		}
+
+		toInsert, _ := src.SliceRemove(scopesRemoved, assigned)
+		toDelete, _ := src.SliceRemove(assigned, scopesRemoved)
+
+		// step 4: insert and delete rows
+		if err := insertRoleScopes(session, id, toInsert); err != nil {
+			return err
+		}
+		if len(toDelete) > 0 {
+			if _, err := session.
+				Table(new(datastore.RoleScope)).
+				Where("role_id=?", id).
+				In("scope", toDelete).
+				Update(softDeleted()); err != nil {
+				return fmt.Errorf("fail to delete role scopes, %w", err)
+			}
+		}
+
+		// step 5: touch role and audit
+		if len(toInsert) == 0 && len(toDelete) == 0 {
+			return nil
+		}
+		if err := touchRole(session, id, actor); err != nil {
+			return err
+		}
+		return audit(ctx, session, datastore.AuditUpdateScopes, datastore.AuditTargetRole, id,
+			datastore.AuditDiff{}.WithList("scopes", toDelete),
+			datastore.AuditDiff{}.WithList("scopes", toInsert))
+	})
+}
+
+// touchRole records who updated the rows of a role
+func touchRole(session *xorm.Session, id int64, actor string) error {
+	if _, err := session.
+		Table(new(datastore.Role)).
+		Where("id=?", id).
+		Update(updatedBy(nil, actor)); err != nil {
+		return fmt.Errorf("fail to update role %d, %w", id, err)
+	}
+	return nil
+}
+
+func (store *Store) UpdateRoleAuthsByID(ctx context.Context, id int64, op datastore.UpdateRoleAuthOption) error {
+	actor, err := store.actor(ctx)
+	if err != nil {
+		return err
+	}
+
+	return store.transaction(ctx, func(session *xorm.Session) error {
+		// step 1: lock role
+		if err := store.lockByID(session, new(datastore.Role), id); err != nil {
+			return err
+		}
+
+		var role datastore.Role
+		if ok, err := session.
+			ID(id).
+			Get(&role); err != nil {
+			return fmt.Errorf("fail to get role %d, %w", id, err)
+		} else if !ok {
+			return datastore.ErrorRoleNotExist
+		}
+
+		// step 2: fetch bound authorities
+		results, err := session.QueryString(getActiveRoleAuthNames(id))
+		if err != nil {
+			return fmt.Errorf("fail to get role binding, %w", err)
+		}
+		bound := make(map[string]int64, len(results))
+		current := make([]string, 0, len(results))
+		for _, rb := range results {
+			authID, err := strconv.ParseInt(rb["auth_id"], 10, 64)
+			if err != nil {
+				return fmt.Errorf("fail to parse auth id, %w", err)
+			}
+			bound[rb["auth_name"]] = authID
+			current = append(current, rb["auth_name"])
+		}
+
+		// step 3: diff, assigning a bound authority is ignored
+		authsAppend, _ := src.SliceAppend(append([]string(nil), current...), src.SliceUnique(op.Assign))
+		authsRemoved, nonExisted := src.SliceRemove(authsAppend, src.SliceUnique(op.Unassign))
+		if len(nonExisted) > 0 {
+			return datastore.ErrorUnassignNonExistedAuths
+		}
+		_, toBind := src.SliceRemove(current, authsRemoved)
+		toUnbind, _ := src.SliceRemove(current, authsRemoved)
+
+		// step 4: bind new authorities, all of them must exist
+		if len(toBind) > 0 {
+			var auths []datastore.Authority
+			if err := session.In("authority_name", toBind).
+				Table(new(datastore.Authority)).
+				Find(&auths); err != nil {
+				return fmt.Errorf("fail to fetch auths: %w", err)
+			}
+			if len(auths) != len(toBind) {
+				return datastore.ErrorAuthNotExist
+			}
+
+			rbs := make([]datastore.RoleBinding, 0, len(auths))
+			for _, auth := range auths {
+				rbs = append(rbs, datastore.RoleBinding{
+					RoleID:   id,
+					AuthID:   auth.ID,
+					AuthName: auth.AuthName,
+				})
+			}
+			if _, err := session.InsertMulti(&rbs); err != nil {
+				return fmt.Errorf("fail to insert rbs, %w", err)
+			}
+		}
+
+		// step 5: unbind authorities
+		if len(toUnbind) > 0 {
+			authIDs := make([]int64, 0, len(toUnbind))
+			for _, name := range toUnbind {
+				authIDs = append(authIDs, bound[name])
+			}
+			if _, err := session.
+				Table(new(datastore.RoleBinding)).
+				Where("role_id=?", id).
+				In("auth_id", authIDs).
+				Update(softDeleted()); err != nil {
+				return fmt.Errorf("fail to delete role bindings, %w", err)
+			}
+		}
+
+		// step 6: touch role and audit
+		if len(toBind) == 0 && len(toUnbind) == 0 {
+			return nil
+		}
+		if err := touchRole(session, id, actor); err != nil {
+			return err
+		}
+		return audit(ctx, session, datastore.AuditUpdateAuths, datastore.AuditTargetRole, id,
+			datastore.AuditDiff{}.WithList("auths", toUnbind),
+			datastore.AuditDiff{}.WithList("auths", toBind))
+	})
+}
@@ -0,0 +1,160 @@
+package sqlstore
+
+import (
+	"strings"
+
+	"github.com/hanzezhenalex/auth/src/datastore"
+
+	"xorm.io/builder"
+)
+
+func getActiveRoleAuthNames(ids ...int64) *builder.Builder {
+	return builder.
+		Select("rbs.role_id", "rbs.auth_id", "rbs.auth_name").
+		From(
+			builder.
+				Select("role_id", "auth_id", "auth_name").
+				From(quote(new(datastore.RoleBinding))).
+				Where(builder.Eq{"deleted_at": 0}).
+				And(builder.In("role_id", ids)),
+			"rbs").
+		LeftJoin(
+			builder.
+				Select("id").
+				From(quote(new(datastore.Authority))).
+				Where(builder.Eq{"deleted_at": 0}),
+			"id=rbs.auth_id",
+			"auth").
+		Where(builder.NotNull{"auth.id"})
+}
+
+func getActiveUserRoles(id int64) *builder.Builder {
+	return builder.
+		Select("urbs.role_id", "urbs.role_name").
+		From(
+			builder.
+				Select("role_id", "role_name").
+				From(quote(new(datastore.UserRoleBinding))).
+				Where(builder.Eq{"deleted_at": 0}).
+				And(builder.Eq{"user_id": id}),
+			"urbs").
+		LeftJoin(
+			builder.
+				Select("id").
+				From(quote(new(datastore.Role))).
+				Where(builder.Eq{"deleted_at": 0}),
+			"id=urbs.role_id",
+			"role").
+		Where(builder.NotNull{"role.id"})
+}
+
+// activeUserRoles selects cols from the user joined with its active roles,
+// a user without any role is one row with null role columns
+func activeUserRoles(id int64, cols ...string) *builder.Builder {
+	return builder.
+		Select(cols...).
+		From(
+			builder.
+				Select("id").
+				From(quote(new(datastore.User))).
+				Where(builder.Eq{"deleted_at": 0}).
+				And(builder.Eq{"id": id}),
+			"u").
+		LeftJoin(
+			builder.
+				Select("user_id", "role_id").
+				From(quote(new(datastore.UserRoleBinding))).
+				Where(builder.Eq{"deleted_at": 0}),
+			"urbs.user_id=u.id",
+			"urbs").
+		LeftJoin(
+			builder.
+				Select("id", "role_name").
+				From(quote(new(datastore.Role))).
+				Where(builder.Eq{"deleted_at": 0}),
+			"role.id=urbs.role_id",
+			"role")
+}
+
+// getUserPermissions returns the scopes and the authorities of the active
+// roles of a user, one row per role and scope of kind "scope", and per role
+// and authority of kind "authority". Both kinds are selected apart then put
+// together, a join of both would return every scope of a role once per
+// authority.
+func getUserPermissions(id int64) (string, []interface{}, error) {
+	scopes := activeUserRoles(id,
+		"DISTINCT role.id AS role_id", "role.role_name", "'scope' AS kind", "rs.scope AS name").
+		LeftJoin(
+			builder.
+				Select("role_id", "scope").
+				From(quote(new(datastore.RoleScope))).
+				Where(builder.Eq{"deleted_at": 0}),
+			"rs.role_id=role.id",
+			"rs")
+
+	auths := activeUserRoles(id,
+		"DISTINCT role.id AS role_id", "role.role_name", "'authority' AS kind", "auth.authority_name AS name").
+		LeftJoin(
+			builder.
+				Select("role_id", "auth_id").
+				From(quote(new(datastore.RoleBinding))).
+				Where(builder.Eq{"deleted_at": 0}),
+			"rbs.role_id=role.id",
+			"rbs").
+		LeftJoin(
+			builder.
+				Select("id", "authority_name").
+				From(quote(new(datastore.Authority))).
+				Where(builder.Eq{"deleted_at": 0}),
+			"auth.id=rbs.auth_id",
+			"auth")
+
+	return unionAll(scopes, auths)
+}
+
+// unionAll joins the selects with UNION ALL. builder.Union wraps every
+// select in parentheses, which sqlite refuses.
+func unionAll(selects ...*builder.Builder) (string, []interface{}, error) {
+	var (
+		queries []string
+		args    []interface{}
+	)
+	for _, sel := range selects {
+		query, selArgs, err := sel.ToSQL()
+		if err != nil {
+			return "", nil, err
+		}
+		queries = append(queries, query)
+		args = append(args, selArgs...)
+	}
+	return strings.Join(queries, " UNION ALL "), args, nil
+}
+
+func getRoleIDsByAuthority(authID int64) *builder.Builder {
+	return builder.
+		Select("role_id").
+		From(quote(new(datastore.RoleBinding))).
+		Where(builder.Eq{"deleted_at": 0}).
+		And(builder.Eq{"auth_id": authID})
+}
+
+func getRoleIDsByScope(scope string) *builder.Builder {
+	return builder.
+		Select("role_id").
+		From(quote(new(datastore.RoleScope))).
+		Where(builder.Eq{"deleted_at": 0}).
+		And(builder.Eq{"scope": scope})
+}
+
+// quote quotes the table name with backticks, xorm replaces them with the
+// quote marks of the dialect before running the query. "user" is a
+// reserved word in postgres.
+func quote(table tableNamer) string {
+	return "`" + table.TableName() + "`"
+}
+
+// likePrefix matches values starting with prefix, with "!" as the escape character
+func likePrefix(col string, prefix string) builder.Cond {
+	escaped := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(prefix)
+	return builder.Expr(col+" LIKE ? ESCAPE '!'", escaped+"%")
+}
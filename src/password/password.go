@@ -0,0 +1,184 @@
+package password
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hanzezhenalex/auth/src"
+)
+
+/*
+	Hash format
+
+	$<algorithm>$<cost>$<salt>$<hash>
+
+	salt and hash are encoded with unpadded standard base64. The format
+	describes itself, so hashes produced by an older algorithm or a lower
+	cost can still be verified and upgraded later.
+*/
+
+const (
+	AlgorithmPBKDF2SHA256 = "pbkdf2-sha256"
+
+	separator = "$"
+)
+
+// Minimums of the config, a weaker one is refused by NewHasher
+const (
+	MinIterations = 10000
+	MinSaltLength = 16
+	MinKeyLength  = 32
+)
+
+var (
+	ErrorMalformedHash    = errors.New("malformed password hash")
+	ErrorUnknownAlgorithm = errors.New("unknown password hash algorithm")
+	ErrorWeakConfig       = errors.New("weak password hashing config")
+)
+
+var encoding = base64.RawStdEncoding
+
+type deriveFunc func(password, salt []byte, cost, keyLen int) []byte
+
+var algorithms = map[string]deriveFunc{
+	AlgorithmPBKDF2SHA256: pbkdf2SHA256,
+}
+
+type Hasher struct {
+	cfg src.PasswordConfig
+}
+
+func NewHasher(cfg src.PasswordConfig) (*Hasher, error) {
+	switch {
+	case cfg.Iterations < MinIterations:
+		return nil, fmt.Errorf("%w, iterations needs %d at least", ErrorWeakConfig, MinIterations)
+	case cfg.SaltLength < MinSaltLength:
+		return nil, fmt.Errorf("%w, salt_length needs %d at least", ErrorWeakConfig, MinSaltLength)
+	case cfg.KeyLength < MinKeyLength:
+		return nil, fmt.Errorf("%w, key_length needs %d at least", ErrorWeakConfig, MinKeyLength)
+	}
+	return &Hasher{cfg: cfg}, nil
+}
+
+// Hash returns the self-describing salted hash of plain
+func (h *Hasher) Hash(plain string) (string, error) {
+	salt := make([]byte, h.cfg.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("fail to generate salt, %w", err)
+	}
+
+	key := algorithms[AlgorithmPBKDF2SHA256]([]byte(plain), salt, h.cfg.Iterations, h.cfg.KeyLength)
+	return encode(AlgorithmPBKDF2SHA256, h.cfg.Iterations, salt, key), nil
+}
+
+// Verify reports whether plain matches the encoded hash
+func (h *Hasher) Verify(encoded string, plain string) (bool, error) {
+	parsed, err := decode(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	derive, ok := algorithms[parsed.algorithm]
+	if !ok {
+		return false, ErrorUnknownAlgorithm
+	}
+
+	key := derive([]byte(plain), parsed.salt, parsed.cost, len(parsed.key))
+	return subtle.ConstantTimeCompare(key, parsed.key) == 1, nil
+}
+
+// NeedsRehash reports whether the encoded hash is weaker than the configured one
+func (h *Hasher) NeedsRehash(encoded string) bool {
+	parsed, err := decode(encoded)
+	if err != nil {
+		return true
+	}
+	return parsed.algorithm != AlgorithmPBKDF2SHA256 ||
+		parsed.cost < h.cfg.Iterations ||
+		len(parsed.salt) < h.cfg.SaltLength ||
+		len(parsed.key) < h.cfg.KeyLength
+}
+
+type hash struct {
+	algorithm string
+	cost      int
+	salt      []byte
+	key       []byte
+}
+
+func encode(algorithm string, cost int, salt []byte, key []byte) string {
+	return strings.Join([]string{
+		"",
+		algorithm,
+		strconv.Itoa(cost),
+		encoding.EncodeToString(salt),
+		encoding.EncodeToString(key),
+	}, separator)
+}
+
+func decode(encoded string) (hash, error) {
+	var h hash
+
+	parts := strings.Split(encoded, separator)
+	if len(parts) != 5 || parts[0] != "" {
+		return h, ErrorMalformedHash
+	}
+
+	cost, err := strconv.Atoi(parts[2])
+	if err != nil || cost <= 0 {
+		return h, ErrorMalformedHash
+	}
+
+	salt, err := encoding.DecodeString(parts[3])
+	if err != nil {
+		return h, ErrorMalformedHash
+	}
+
+	key, err := encoding.DecodeString(parts[4])
+	if err != nil || len(key) == 0 {
+		return h, ErrorMalformedHash
+	}
+
+	h.algorithm, h.cost, h.salt, h.key = parts[1], cost, salt, key
+	return h, nil
+}
+
+// pbkdf2SHA256 implements PBKDF2 (RFC 8018) with HMAC-SHA256 as the PRF
+func pbkdf2SHA256(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLen := prf.Size()
+	numBlocks := (keyLen + hashLen - 1) / hashLen
+
+	var counter [4]byte
+	dk := make([]byte, 0, numBlocks*hashLen)
+	u := make([]byte, hashLen)
+
+	for block := 1; block <= numBlocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(counter[:], uint32(block))
+		prf.Write(counter[:])
+		dk = prf.Sum(dk)
+
+		t := dk[len(dk)-hashLen:]
+		copy(u, t)
+
+		for n := 2; n <= iterations; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for i := range u {
+				t[i] ^= u[i]
+			}
+		}
+	}
+	return dk[:keyLen]
+}
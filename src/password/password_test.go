@@ -0,0 +1,115 @@
+package password
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/hanzezhenalex/auth/src"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testConfig(iterations int) src.PasswordConfig {
+	cfg := src.NewPasswordConfig()
+	cfg.Iterations = iterations
+	return cfg
+}
+
+func newTestHasher(t *testing.T, iterations int) *Hasher {
+	hasher, err := NewHasher(testConfig(iterations))
+	require.NoError(t, err)
+	return hasher
+}
+
+func TestPBKDF2SHA256(t *testing.T) {
+	rq := require.New(t)
+
+	// test vectors from RFC 7914, section 11
+	rq.Equal(
+		"55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc"+
+			"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783",
+		hex.EncodeToString(pbkdf2SHA256([]byte("passwd"), []byte("salt"), 1, 64)),
+	)
+	rq.Equal(
+		"4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56"+
+			"a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d",
+		hex.EncodeToString(pbkdf2SHA256([]byte("Password"), []byte("NaCl"), 80000, 64)),
+	)
+}
+
+func TestHasher(t *testing.T) {
+	rq := require.New(t)
+	hasher := newTestHasher(t, MinIterations)
+
+	t.Run("hash and verify", func(t *testing.T) {
+		encoded, err := hasher.Hash("secret")
+		rq.NoError(err)
+		rq.True(strings.HasPrefix(encoded, "$"+AlgorithmPBKDF2SHA256+"$10000$"))
+
+		ok, err := hasher.Verify(encoded, "secret")
+		rq.NoError(err)
+		rq.True(ok)
+
+		ok, err = hasher.Verify(encoded, "Secret")
+		rq.NoError(err)
+		rq.False(ok)
+	})
+
+	t.Run("same password, different salt", func(t *testing.T) {
+		h1, err := hasher.Hash("secret")
+		rq.NoError(err)
+		h2, err := hasher.Hash("secret")
+		rq.NoError(err)
+		rq.NotEqual(h1, h2)
+	})
+
+	t.Run("verify with a stronger hasher", func(t *testing.T) {
+		encoded, err := hasher.Hash("secret")
+		rq.NoError(err)
+
+		stronger := newTestHasher(t, 2*MinIterations)
+		ok, err := stronger.Verify(encoded, "secret")
+		rq.NoError(err)
+		rq.True(ok)
+
+		rq.True(stronger.NeedsRehash(encoded))
+		rq.False(hasher.NeedsRehash(encoded))
+	})
+
+	t.Run("malformed hash", func(t *testing.T) {
+		for _, encoded := range []string{
+			"",
+			"secret",
+			"$pbkdf2-sha256$abc$c2FsdA$a2V5",
+			"$pbkdf2-sha256$1000$!!!$a2V5",
+			"pbkdf2-sha256$1000$c2FsdA$a2V5$",
+		} {
+			_, err := hasher.Verify(encoded, "secret")
+			rq.Equal(ErrorMalformedHash, err, encoded)
+			rq.True(hasher.NeedsRehash(encoded))
+		}
+	})
+
+	t.Run("unknown algorithm", func(t *testing.T) {
+		_, err := hasher.Verify("$md5$1$c2FsdA$a2V5", "secret")
+		rq.Equal(ErrorUnknownAlgorithm, err)
+	})
+}
+
+func TestNewHasher(t *testing.T) {
+	rq := require.New(t)
+	_, err := NewHasher(src.NewPasswordConfig())
+	rq.NoError(err)
+
+	for _, weaken := range []func(cfg *src.PasswordConfig){
+		func(cfg *src.PasswordConfig) { cfg.Iterations = MinIterations - 1 },
+		func(cfg *src.PasswordConfig) { cfg.SaltLength = 8 },
+		func(cfg *src.PasswordConfig) { cfg.KeyLength = 0 },
+	} {
+		cfg := src.NewPasswordConfig()
+		weaken(&cfg)
+		_, err := NewHasher(cfg)
+		rq.ErrorIs(err, ErrorWeakConfig)
+	}
+}
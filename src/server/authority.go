@@ -0,0 +1,115 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/hanzezhenalex/auth/src/datastore"
+)
+
+type createAuthorityRequest struct {
+	Name string `json:"name"`
+}
+
+func (s *Server) listAuthorities(w http.ResponseWriter, r *http.Request, _ params) {
+	opt, err := listOption(r.URL.Query())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	auths, next, err := s.store.ListAuthorities(r.Context(), opt)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newListResponse(auths, next, newAuthorityResource))
+}
+
+func (s *Server) createAuthority(w http.ResponseWriter, r *http.Request, _ params) {
+	var req createAuthorityRequest
+	if err := decodeBody(w, r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+	if req.Name == "" {
+		writeError(w, fmt.Errorf("%w, name is empty", errorInvalidBody))
+		return
+	}
+
+	auth := &datastore.Authority{AuthName: req.Name}
+	if err := s.store.CreateAuthority(r.Context(), auth); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, newAuthorityResource(auth))
+}
+
+func (s *Server) getAuthority(w http.ResponseWriter, r *http.Request, p params) {
+	id, err := pathID(p)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	auth, err := s.store.GetAuthorityByID(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newAuthorityResource(auth))
+}
+
+// deleteAuthority fails with 412 if roles are bound to the authority,
+// unless ?force=true
+func (s *Server) deleteAuthority(w http.ResponseWriter, r *http.Request, p params) {
+	id, err := pathID(p)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var force bool
+	if raw := r.URL.Query().Get("force"); raw != "" {
+		if force, err = strconv.ParseBool(raw); err != nil {
+			writeError(w, fmt.Errorf("%w, force %q", errorInvalidQuery, raw))
+			return
+		}
+	}
+
+	if err := s.store.DeleteAuthorityByID(r.Context(), id, force); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) restoreAuthority(w http.ResponseWriter, r *http.Request, p params) {
+	id, err := pathID(p)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := s.store.RestoreAuthorityByID(r.Context(), id); err != nil {
+		writeError(w, err)
+		return
+	}
+	s.getAuthority(w, r, p)
+}
+
+func (s *Server) listAuthorityRoles(w http.ResponseWriter, r *http.Request, p params) {
+	id, err := pathID(p)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	roles, err := s.store.ListRolesByAuthority(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newListResponse(roles, "", newRoleResource))
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/hanzezhenalex/auth/src/datastore"
+	"github.com/hanzezhenalex/auth/src/session"
+	"github.com/hanzezhenalex/auth/src/token"
+)
+
+var (
+	errorNotFound         = errors.New("not found")
+	errorMethodNotAllowed = errors.New("method not allowed")
+	errorInvalidID        = errors.New("invalid id")
+	errorInvalidQuery     = errors.New("invalid query")
+	errorInvalidBody      = errors.New("invalid body")
+	errorInternal         = errors.New("internal error")
+)
+
+// errorBody is the body of every failed request
+type errorBody struct {
+	Error string `json:"error"`
+}
+
+// statusOf maps an error to its status code, the sentinel errors of the
+// datastore included
+func statusOf(err error) int {
+	switch {
+	case errors.Is(err, datastore.ErrorAuthExist),
+		errors.Is(err, datastore.ErrorRoleExist),
+		errors.Is(err, datastore.ErrorUserExist):
+		return http.StatusConflict
+
+	case errors.Is(err, datastore.ErrorAuthNotExist),
+		errors.Is(err, datastore.ErrorRoleNotExist),
+		errors.Is(err, datastore.ErrorUserNotExist),
+		errors.Is(err, datastore.ErrorSessionNotExist),
+		errors.Is(err, errorNotFound):
+		return http.StatusNotFound
+
+	case errors.Is(err, datastore.ErrorDeleteAuthWithBinding):
+		return http.StatusPreconditionFailed
+
+	case errors.Is(err, datastore.ErrorInvalidPageToken),
+		errors.Is(err, datastore.ErrorUnassignNonExistedRoles),
+		errors.Is(err, datastore.ErrorUnassignNonExistedScopes),
+		errors.Is(err, datastore.ErrorUnassignNonExistedAuths),
+		errors.Is(err, errorInvalidID),
+		errors.Is(err, errorInvalidQuery),
+		errors.Is(err, errorInvalidBody):
+		return http.StatusBadRequest
+
+	case errors.Is(err, datastore.ErrorActorRequired),
+		errors.Is(err, session.ErrorInvalidCredentials),
+		errors.Is(err, session.ErrorInvalidSession),
+		errors.Is(err, token.ErrorInvalidRefreshToken),
+		errors.Is(err, token.ErrorRefreshTokenReused):
+		return http.StatusUnauthorized
+
+	case errors.Is(err, errorMethodNotAllowed):
+		return http.StatusMethodNotAllowed
+
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeError writes the error with its status, an unknown error is logged
+// instead of exposed
+func writeError(w http.ResponseWriter, err error) {
+	status := statusOf(err)
+	if status == http.StatusInternalServerError {
+		log.Printf("internal error: %v", err)
+		err = errorInternal
+	}
+	writeJSON(w, status, errorBody{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,20 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// jwksMaxAge is short, a verifier meets the kid of a rotation within it
+const jwksMaxAge = 300
+
+// jwks publishes the public keys verifying the tokens
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request, _ params) {
+	set, err := s.keys.JWKS(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", jwksMaxAge))
+	writeJSON(w, http.StatusOK, set)
+}
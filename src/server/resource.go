@@ -0,0 +1,134 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/hanzezhenalex/auth/src/datastore"
+)
+
+// maxBodySize bounds the body of a request
+const maxBodySize = 1 << 20
+
+/*
+	Resources
+*/
+
+type authorityResource struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedBy string    `json:"updated_by,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Deleted   bool      `json:"deleted,omitempty"`
+}
+
+func newAuthorityResource(auth *datastore.Authority) authorityResource {
+	return authorityResource{
+		ID:        auth.ID,
+		Name:      auth.AuthName,
+		CreatedBy: auth.CreatedBy,
+		CreatedAt: auth.CreatedAt,
+		UpdatedBy: auth.UpdatedBy,
+		UpdatedAt: auth.UpdatedAt,
+		Deleted:   auth.DeletedAt != 0,
+	}
+}
+
+type roleResource struct {
+	ID        int64            `json:"id"`
+	Name      string           `json:"name"`
+	Scopes    datastore.Scopes `json:"scopes"`
+	Auths     []string         `json:"auths"`
+	CreatedBy string           `json:"created_by,omitempty"`
+	CreatedAt time.Time        `json:"created_at"`
+	UpdatedBy string           `json:"updated_by,omitempty"`
+	UpdatedAt time.Time        `json:"updated_at"`
+	Deleted   bool             `json:"deleted,omitempty"`
+}
+
+func newRoleResource(role *datastore.Role) roleResource {
+	auths := role.Auths
+	if auths == nil {
+		auths = []string{}
+	}
+	return roleResource{
+		ID:        role.ID,
+		Name:      role.RoleName,
+		Scopes:    role.Scopes,
+		Auths:     auths,
+		CreatedBy: role.CreatedBy,
+		CreatedAt: role.CreatedAt,
+		UpdatedBy: role.UpdatedBy,
+		UpdatedAt: role.UpdatedAt,
+		Deleted:   role.DeletedAt != 0,
+	}
+}
+
+// listResponse is a page of a listing, NextPageToken is empty on the last one
+type listResponse[T any] struct {
+	Items         []T    `json:"items"`
+	NextPageToken string `json:"next_page_token,omitempty"`
+}
+
+func newListResponse[T any, R any](items []T, next string, convert func(T) R) listResponse[R] {
+	resp := listResponse[R]{Items: make([]R, 0, len(items)), NextPageToken: next}
+	for _, item := range items {
+		resp.Items = append(resp.Items, convert(item))
+	}
+	return resp
+}
+
+/*
+	Requests
+*/
+
+func pathID(p params) (int64, error) {
+	id, err := strconv.ParseInt(p["id"], 10, 64)
+	if err != nil || id <= 0 {
+		return 0, errorInvalidID
+	}
+	return id, nil
+}
+
+// listOption reads page_token, page_size, name_prefix, created_by and
+// include_deleted from the query
+func listOption(query url.Values) (datastore.ListOption, error) {
+	opt := datastore.ListOption{
+		PageToken:  query.Get("page_token"),
+		NamePrefix: query.Get("name_prefix"),
+		CreatedBy:  query.Get("created_by"),
+	}
+
+	if raw := query.Get("page_size"); raw != "" {
+		size, err := strconv.Atoi(raw)
+		if err != nil {
+			return opt, fmt.Errorf("%w, page_size %q", errorInvalidQuery, raw)
+		}
+		opt.PageSize = size
+	}
+
+	if raw := query.Get("include_deleted"); raw != "" {
+		include, err := strconv.ParseBool(raw)
+		if err != nil {
+			return opt, fmt.Errorf("%w, include_deleted %q", errorInvalidQuery, raw)
+		}
+		opt.IncludeDeleted = include
+	}
+	return opt, nil
+}
+
+// decodeBody decodes the json body into v, unknown fields are rejected
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("%w, %s", errorInvalidBody, err)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/hanzezhenalex/auth/src/datastore"
+)
+
+type createRoleRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes,omitempty"`
+	Auths  []string `json:"auths,omitempty"`
+}
+
+// listRoles lists the roles page by page, or with ?scope= all the live
+// roles granting the scope
+func (s *Server) listRoles(w http.ResponseWriter, r *http.Request, _ params) {
+	query := r.URL.Query()
+	if query.Has("scope") {
+		roles, err := s.store.ListRolesByScope(r.Context(), query.Get("scope"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, newListResponse(roles, "", newRoleResource))
+		return
+	}
+
+	opt, err := listOption(query)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	roles, next, err := s.store.ListRoles(r.Context(), opt)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newListResponse(roles, next, newRoleResource))
+}
+
+func (s *Server) createRole(w http.ResponseWriter, r *http.Request, _ params) {
+	var req createRoleRequest
+	if err := decodeBody(w, r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+	if req.Name == "" {
+		writeError(w, fmt.Errorf("%w, name is empty", errorInvalidBody))
+		return
+	}
+
+	role := &datastore.Role{RoleName: req.Name, Scopes: req.Scopes, Auths: req.Auths}
+	if err := s.store.CreateRole(r.Context(), role); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// read back for the sorted scopes and authorities
+	created, err := s.store.GetRoleByID(r.Context(), role.ID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, newRoleResource(created))
+}
+
+func (s *Server) getRole(w http.ResponseWriter, r *http.Request, p params) {
+	id, err := pathID(p)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	role, err := s.store.GetRoleByID(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newRoleResource(role))
+}
+
+func (s *Server) deleteRole(w http.ResponseWriter, r *http.Request, p params) {
+	id, err := pathID(p)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := s.store.DeleteRoleByID(r.Context(), id); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) restoreRole(w http.ResponseWriter, r *http.Request, p params) {
+	id, err := pathID(p)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := s.store.RestoreRoleByID(r.Context(), id); err != nil {
+		writeError(w, err)
+		return
+	}
+	s.getRole(w, r, p)
+}
+
+func (s *Server) updateRoleScopes(w http.ResponseWriter, r *http.Request, p params) {
+	id, err := pathID(p)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var op datastore.UpdateRoleScopeOption
+	if err := decodeBody(w, r, &op); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := s.store.UpdateScopesByID(r.Context(), id, op); err != nil {
+		writeError(w, err)
+		return
+	}
+	s.getRole(w, r, p)
+}
+
+func (s *Server) updateRoleAuths(w http.ResponseWriter, r *http.Request, p params) {
+	id, err := pathID(p)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var op datastore.UpdateRoleAuthOption
+	if err := decodeBody(w, r, &op); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := s.store.UpdateRoleAuthsByID(r.Context(), id, op); err != nil {
+		writeError(w, err)
+		return
+	}
+	s.getRole(w, r, p)
+}
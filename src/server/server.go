@@ -0,0 +1,284 @@
+// Package server exposes a datastore.Datastore as JSON REST APIs, served by
+// two handlers on their own addresses. The admin API is for the operators:
+//
+//	GET    /authorities                  list, see listOption for the query
+//	POST   /authorities                  create
+//	GET    /authorities/{id}
+//	DELETE /authorities/{id}             soft delete, ?force=true with bindings
+//	POST   /authorities/{id}/restore
+//	GET    /authorities/{id}/roles       roles bound to the authority
+//	GET    /roles                        list, ?scope= for the roles granting it
+//	POST   /roles                        create
+//	GET    /roles/{id}
+//	DELETE /roles/{id}
+//	POST   /roles/{id}/restore
+//	PATCH  /roles/{id}/scopes            body is datastore.UpdateRoleScopeOption
+//	PATCH  /roles/{id}/auths             body is datastore.UpdateRoleAuthOption
+//	GET    /users/{id}/roles
+//	PUT    /users/{id}/roles/{name}      assign a role
+//	DELETE /users/{id}/roles/{name}      unassign a role
+//	GET    /users/{id}/sessions          active sessions of the user
+//	DELETE /users/{id}/refresh_tokens    revoke every refresh token of the user
+//	DELETE /sessions/{id}                revoke a session
+//
+// The user API is for the users themselves:
+//
+//	POST   /login                        body is username and password, returns a token
+//	POST   /logout                       revoke the session of the bearer token
+//	POST   /token                        trade the session of the bearer token for tokens
+//	POST   /token/refresh                body is a refresh token, returns the next tokens
+//	GET    /.well-known/jwks.json        public keys of the tokens
+//
+// The token routes are served only with a key store to sign the tokens.
+//
+// On the admin API, the caller names itself in the ActorHeader, it becomes
+// the actor of the datastore calls. The header is trusted as it is, the
+// admin API is meant to sit behind a gateway which authenticates the
+// callers. The user API never reads it, a user is its own actor.
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hanzezhenalex/auth/src"
+	"github.com/hanzezhenalex/auth/src/datastore"
+	"github.com/hanzezhenalex/auth/src/password"
+	"github.com/hanzezhenalex/auth/src/session"
+	"github.com/hanzezhenalex/auth/src/token"
+	// every driver can be chosen in the config
+	_ "github.com/hanzezhenalex/auth/src/datastore/all"
+)
+
+// ActorHeader carries the id of the caller
+const ActorHeader = "X-Actor"
+
+const (
+	readHeaderTimeout = 10 * time.Second
+	shutdownTimeout   = 10 * time.Second
+)
+
+// params are the values of the {name} segments of a route
+type params map[string]string
+
+type route struct {
+	method   string
+	segments []string
+	handle   func(w http.ResponseWriter, r *http.Request, p params)
+}
+
+// Server serves the APIs of a datastore, Admin and User are their handlers
+type Server struct {
+	store    datastore.Datastore
+	sessions *session.Manager
+	keys     *token.KeyStore
+	tokens   *token.Refresher
+	admin    *router
+	user     *router
+}
+
+// New serves the APIs of store, keys and tokens may be nil when no token is
+// signed
+func New(store datastore.Datastore, sessions *session.Manager, keys *token.KeyStore, tokens *token.Refresher) *Server {
+	s := &Server{
+		store:    store,
+		sessions: sessions,
+		keys:     keys,
+		tokens:   tokens,
+		admin:    &router{trustActor: true},
+		user:     &router{},
+	}
+
+	s.admin.handle(http.MethodGet, "/authorities", s.listAuthorities)
+	s.admin.handle(http.MethodPost, "/authorities", s.createAuthority)
+	s.admin.handle(http.MethodGet, "/authorities/{id}", s.getAuthority)
+	s.admin.handle(http.MethodDelete, "/authorities/{id}", s.deleteAuthority)
+	s.admin.handle(http.MethodPost, "/authorities/{id}/restore", s.restoreAuthority)
+	s.admin.handle(http.MethodGet, "/authorities/{id}/roles", s.listAuthorityRoles)
+
+	s.admin.handle(http.MethodGet, "/roles", s.listRoles)
+	s.admin.handle(http.MethodPost, "/roles", s.createRole)
+	s.admin.handle(http.MethodGet, "/roles/{id}", s.getRole)
+	s.admin.handle(http.MethodDelete, "/roles/{id}", s.deleteRole)
+	s.admin.handle(http.MethodPost, "/roles/{id}/restore", s.restoreRole)
+	s.admin.handle(http.MethodPatch, "/roles/{id}/scopes", s.updateRoleScopes)
+	s.admin.handle(http.MethodPatch, "/roles/{id}/auths", s.updateRoleAuths)
+
+	s.admin.handle(http.MethodGet, "/users/{id}/roles", s.listUserRoles)
+	s.admin.handle(http.MethodPut, "/users/{id}/roles/{name}", s.assignUserRole)
+	s.admin.handle(http.MethodDelete, "/users/{id}/roles/{name}", s.unassignUserRole)
+	s.admin.handle(http.MethodGet, "/users/{id}/sessions", s.listUserSessions)
+	s.admin.handle(http.MethodDelete, "/users/{id}/refresh_tokens", s.revokeUserRefreshTokens)
+
+	s.admin.handle(http.MethodDelete, "/sessions/{id}", s.revokeSession)
+
+	s.user.handle(http.MethodPost, "/login", s.login)
+	s.user.handle(http.MethodPost, "/logout", s.logout)
+	if tokens != nil {
+		s.user.handle(http.MethodPost, "/token", s.issueToken)
+		s.user.handle(http.MethodPost, "/token/refresh", s.refreshToken)
+	}
+	if keys != nil {
+		s.user.handle(http.MethodGet, "/.well-known/jwks.json", s.jwks)
+	}
+	return s
+}
+
+// Admin returns the handler of the admin API
+func (s *Server) Admin() http.Handler {
+	return s.admin
+}
+
+// User returns the handler of the user API
+func (s *Server) User() http.Handler {
+	return s.user
+}
+
+// router dispatches the requests to its routes
+type router struct {
+	routes []route
+	// trustActor makes the ActorHeader the actor of the datastore calls
+	trustActor bool
+}
+
+func (rr *router) handle(method, pattern string, handle func(http.ResponseWriter, *http.Request, params)) {
+	rr.routes = append(rr.routes, route{
+		method:   method,
+		segments: splitPath(pattern),
+		handle:   handle,
+	})
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+// match returns the params of path if it matches the route
+func (rt route) match(path []string) (params, bool) {
+	if len(path) != len(rt.segments) {
+		return nil, false
+	}
+
+	p := params{}
+	for i, segment := range rt.segments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			p[segment[1:len(segment)-1]] = path[i]
+		} else if segment != path[i] {
+			return nil, false
+		}
+	}
+	return p, true
+}
+
+func (rr *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := splitPath(r.URL.Path)
+
+	var allowed []string
+	for _, rt := range rr.routes {
+		p, ok := rt.match(path)
+		if !ok {
+			continue
+		}
+		if rt.method != r.Method {
+			allowed = append(allowed, rt.method)
+			continue
+		}
+
+		if actor := r.Header.Get(ActorHeader); rr.trustActor && actor != "" {
+			r = r.WithContext(datastore.WithActor(r.Context(), actor))
+		}
+		rt.handle(w, r, p)
+		return
+	}
+
+	if len(allowed) > 0 {
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeError(w, errorMethodNotAllowed)
+		return
+	}
+	writeError(w, errorNotFound)
+}
+
+// ListenAndServe opens the datastore of cfg and serves the admin API on
+// cfg.Server.Addr and the user API on cfg.Server.UserAddr until ctx is
+// done, sessions are bounded by cfg.Session. With a master key in
+// cfg.SigningKeys, the signing keys are rotated in the background and
+// published, and the tokens of cfg.Token are served.
+func ListenAndServe(ctx context.Context, cfg src.Config) error {
+	if cfg.SigningKeys.MasterKey != "" {
+		if err := token.CheckKeyConfig(cfg.SigningKeys, cfg.Token); err != nil {
+			return err
+		}
+	}
+
+	store, err := datastore.Open(cfg)
+	if err != nil {
+		return err
+	}
+	hasher, err := password.NewHasher(cfg.PasswordHashing)
+	if err != nil {
+		return err
+	}
+	sessions := session.NewManager(store, hasher, cfg.Session)
+
+	// either server failing stops both
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		keys   *token.KeyStore
+		tokens *token.Refresher
+	)
+	if cfg.SigningKeys.MasterKey != "" {
+		if keys, err = token.NewKeyStore(store, cfg.SigningKeys, cfg.Token); err != nil {
+			return err
+		}
+		go keys.Run(ctx)
+		tokens = token.NewRefresher(store, token.NewIssuer(store, keys, cfg.Token), cfg.Token)
+	}
+
+	s := New(store, sessions, keys, tokens)
+	servers := []*http.Server{
+		{Addr: cfg.Server.Addr, Handler: s.Admin(), ReadHeaderTimeout: readHeaderTimeout},
+		{Addr: cfg.Server.UserAddr, Handler: s.User(), ReadHeaderTimeout: readHeaderTimeout},
+	}
+
+	served := make(chan error, len(servers))
+	for _, server := range servers {
+		go func(server *http.Server) {
+			err := server.ListenAndServe()
+			if errors.Is(err, http.ErrServerClosed) {
+				err = nil
+			} else {
+				err = fmt.Errorf("fail to serve on %s, %w", server.Addr, err)
+			}
+			served <- err
+			cancel()
+		}(server)
+	}
+	<-ctx.Done()
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelShutdown()
+	var shutdownErr error
+	for _, server := range servers {
+		if err := server.Shutdown(shutdownCtx); err != nil && shutdownErr == nil {
+			shutdownErr = err
+		}
+	}
+
+	for range servers {
+		if err := <-served; err != nil {
+			return err
+		}
+	}
+	return shutdownErr
+}
@@ -0,0 +1,374 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hanzezhenalex/auth/src"
+	"github.com/hanzezhenalex/auth/src/datastore"
+	"github.com/hanzezhenalex/auth/src/datastore/memory"
+	"github.com/hanzezhenalex/auth/src/password"
+	"github.com/hanzezhenalex/auth/src/session"
+	"github.com/hanzezhenalex/auth/src/token"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testClient struct {
+	t       *testing.T
+	handler http.Handler
+	// actor is sent in the ActorHeader, and token as the bearer token, if any
+	actor string
+	token string
+}
+
+// do sends the request and decodes the response body into out, if any
+func (c testClient) do(method, path string, body interface{}, out interface{}) int {
+	var reader bytes.Buffer
+	if body != nil {
+		require.NoError(c.t, json.NewEncoder(&reader).Encode(body))
+	}
+
+	req := httptest.NewRequest(method, path, &reader)
+	if c.actor != "" {
+		req.Header.Set(ActorHeader, c.actor)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", bearerPrefix+c.token)
+	}
+	rec := httptest.NewRecorder()
+	c.handler.ServeHTTP(rec, req)
+
+	if out != nil && rec.Body.Len() > 0 {
+		require.NoError(c.t, json.Unmarshal(rec.Body.Bytes(), out))
+	}
+	return rec.Code
+}
+
+func TestServer(t *testing.T) {
+	store := memory.NewMemoryDatastore()
+	hasherCfg := src.NewPasswordConfig()
+	hasherCfg.Iterations = password.MinIterations
+	hasher, err := password.NewHasher(hasherCfg)
+	require.NoError(t, err)
+	keyCfg := src.NewKeyConfig()
+	keyCfg.MasterKey = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	tokenCfg := src.NewTokenConfig()
+	tokenCfg.Issuer = "test"
+	keys, err := token.NewKeyStore(store, keyCfg, tokenCfg)
+	require.NoError(t, err)
+	tokens := token.NewRefresher(store, token.NewIssuer(store, keys, tokenCfg), tokenCfg)
+	srv := New(store, session.NewManager(store, hasher, src.NewSessionConfig()), keys, tokens)
+	c := testClient{t: t, handler: srv.Admin(), actor: "test_admin"}
+	u := testClient{t: t, handler: srv.User()}
+
+	var auth authorityResource
+	var role roleResource
+
+	t.Run("create authority", func(t *testing.T) {
+		rq := require.New(t)
+		rq.Equal(http.StatusCreated, c.do(http.MethodPost, "/authorities", createAuthorityRequest{Name: "auth1"}, &auth))
+		rq.NotZero(auth.ID)
+		rq.Equal("auth1", auth.Name)
+		rq.Equal("test_admin", auth.CreatedBy)
+
+		var body errorBody
+		rq.Equal(http.StatusConflict, c.do(http.MethodPost, "/authorities", createAuthorityRequest{Name: "auth1"}, &body))
+		rq.Equal(datastore.ErrorAuthExist.Error(), body.Error)
+
+		rq.Equal(http.StatusBadRequest, c.do(http.MethodPost, "/authorities", map[string]string{"unknown": "x"}, &body))
+		rq.Equal(http.StatusBadRequest, c.do(http.MethodPost, "/authorities", createAuthorityRequest{}, &body))
+	})
+
+	t.Run("create role", func(t *testing.T) {
+		rq := require.New(t)
+		rq.Equal(http.StatusCreated, c.do(http.MethodPost, "/roles", createRoleRequest{
+			Name:   "role1",
+			Scopes: []string{"scope2", "scope1"},
+			Auths:  []string{"auth1"},
+		}, &role))
+		rq.Equal("role1", role.Name)
+		rq.EqualValues([]string{"scope1", "scope2"}, role.Scopes)
+		rq.Equal([]string{"auth1"}, role.Auths)
+
+		var body errorBody
+		rq.Equal(http.StatusConflict, c.do(http.MethodPost, "/roles", createRoleRequest{Name: "role1"}, &body))
+		rq.Equal(datastore.ErrorRoleExist.Error(), body.Error)
+		rq.Equal(http.StatusNotFound, c.do(http.MethodPost, "/roles", createRoleRequest{
+			Name:  "role2",
+			Auths: []string{"non-existed"},
+		}, &body))
+		rq.Equal(datastore.ErrorAuthNotExist.Error(), body.Error)
+	})
+
+	t.Run("read", func(t *testing.T) {
+		rq := require.New(t)
+		var actualAuth authorityResource
+		rq.Equal(http.StatusOK, c.do(http.MethodGet, fmt.Sprintf("/authorities/%d", auth.ID), nil, &actualAuth))
+		rq.Equal(auth.Name, actualAuth.Name)
+
+		var actualRole roleResource
+		rq.Equal(http.StatusOK, c.do(http.MethodGet, fmt.Sprintf("/roles/%d", role.ID), nil, &actualRole))
+		rq.Equal(role.Scopes, actualRole.Scopes)
+
+		var body errorBody
+		rq.Equal(http.StatusNotFound, c.do(http.MethodGet, "/roles/99999", nil, &body))
+		rq.Equal(datastore.ErrorRoleNotExist.Error(), body.Error)
+		rq.Equal(http.StatusBadRequest, c.do(http.MethodGet, "/roles/abc", nil, &body))
+		rq.Equal(http.StatusNotFound, c.do(http.MethodGet, "/nothing", nil, &body))
+		rq.Equal(http.StatusMethodNotAllowed, c.do(http.MethodPut, "/roles", nil, &body))
+	})
+
+	t.Run("list", func(t *testing.T) {
+		rq := require.New(t)
+		rq.Equal(http.StatusCreated, c.do(http.MethodPost, "/authorities", createAuthorityRequest{Name: "auth2"}, nil))
+
+		var page listResponse[authorityResource]
+		rq.Equal(http.StatusOK, c.do(http.MethodGet, "/authorities?page_size=1", nil, &page))
+		rq.Len(page.Items, 1)
+		rq.Equal("auth1", page.Items[0].Name)
+		rq.NotEmpty(page.NextPageToken)
+
+		next := page.NextPageToken
+		page = listResponse[authorityResource]{}
+		rq.Equal(http.StatusOK, c.do(http.MethodGet, "/authorities?page_size=1&page_token="+next, nil, &page))
+		rq.Len(page.Items, 1)
+		rq.Equal("auth2", page.Items[0].Name)
+		rq.Empty(page.NextPageToken)
+
+		var roles listResponse[roleResource]
+		rq.Equal(http.StatusOK, c.do(http.MethodGet, "/roles?scope=scope1", nil, &roles))
+		rq.Len(roles.Items, 1)
+		rq.Equal(http.StatusOK, c.do(http.MethodGet, fmt.Sprintf("/authorities/%d/roles", auth.ID), nil, &roles))
+		rq.Len(roles.Items, 1)
+
+		var body errorBody
+		rq.Equal(http.StatusBadRequest, c.do(http.MethodGet, "/authorities?page_token=!", nil, &body))
+		rq.Equal(http.StatusBadRequest, c.do(http.MethodGet, "/roles?include_deleted=maybe", nil, &body))
+	})
+
+	t.Run("update scopes and auths", func(t *testing.T) {
+		rq := require.New(t)
+		var actual roleResource
+		rq.Equal(http.StatusOK, c.do(http.MethodPatch, fmt.Sprintf("/roles/%d/scopes", role.ID), datastore.UpdateRoleScopeOption{
+			Assign:   []string{"scope3"},
+			Unassign: []string{"scope1"},
+		}, &actual))
+		rq.EqualValues([]string{"scope2", "scope3"}, actual.Scopes)
+
+		var body errorBody
+		rq.Equal(http.StatusBadRequest, c.do(http.MethodPatch, fmt.Sprintf("/roles/%d/scopes", role.ID), datastore.UpdateRoleScopeOption{
+			Unassign: []string{"non-existed"},
+		}, &body))
+		rq.Equal(datastore.ErrorUnassignNonExistedScopes.Error(), body.Error)
+
+		rq.Equal(http.StatusOK, c.do(http.MethodPatch, fmt.Sprintf("/roles/%d/auths", role.ID), datastore.UpdateRoleAuthOption{
+			Assign: []string{"auth2"},
+		}, &actual))
+		rq.Equal([]string{"auth1", "auth2"}, actual.Auths)
+	})
+
+	t.Run("user roles", func(t *testing.T) {
+		rq := require.New(t)
+		user := &datastore.User{Username: "user1", Password: "password"}
+		rq.NoError(store.CreateUser(context.Background(), user))
+		path := fmt.Sprintf("/users/%d/roles", user.ID)
+
+		rq.Equal(http.StatusNoContent, c.do(http.MethodPut, path+"/role1", nil, nil))
+		var roles userRolesResponse
+		rq.Equal(http.StatusOK, c.do(http.MethodGet, path, nil, &roles))
+		rq.Equal([]string{"role1"}, roles.Roles)
+
+		rq.Equal(http.StatusNoContent, c.do(http.MethodDelete, path+"/role1", nil, nil))
+		rq.Equal(http.StatusOK, c.do(http.MethodGet, path, nil, &roles))
+		rq.Empty(roles.Roles)
+
+		var body errorBody
+		rq.Equal(http.StatusNotFound, c.do(http.MethodDelete, path+"/role1", nil, &body))
+		rq.Equal(http.StatusNotFound, c.do(http.MethodPut, path+"/non-existed", nil, &body))
+		rq.Equal(http.StatusNotFound, c.do(http.MethodGet, "/users/99999/roles", nil, &body))
+	})
+
+	t.Run("delete and restore", func(t *testing.T) {
+		rq := require.New(t)
+		authPath := fmt.Sprintf("/authorities/%d", auth.ID)
+
+		var body errorBody
+		rq.Equal(http.StatusPreconditionFailed, c.do(http.MethodDelete, authPath, nil, &body))
+		rq.Equal(datastore.ErrorDeleteAuthWithBinding.Error(), body.Error)
+		rq.Equal(http.StatusNoContent, c.do(http.MethodDelete, authPath+"?force=true", nil, nil))
+		rq.Equal(http.StatusNotFound, c.do(http.MethodGet, authPath, nil, &body))
+
+		var restored authorityResource
+		rq.Equal(http.StatusOK, c.do(http.MethodPost, authPath+"/restore", nil, &restored))
+		rq.Equal(auth.Name, restored.Name)
+
+		rolePath := fmt.Sprintf("/roles/%d", role.ID)
+		rq.Equal(http.StatusNoContent, c.do(http.MethodDelete, rolePath, nil, nil))
+		rq.Equal(http.StatusNotFound, c.do(http.MethodDelete, rolePath, nil, &body))
+
+		var page listResponse[roleResource]
+		rq.Equal(http.StatusOK, c.do(http.MethodGet, "/roles?include_deleted=true", nil, &page))
+		rq.Len(page.Items, 1)
+		rq.True(page.Items[0].Deleted)
+
+		var actual roleResource
+		rq.Equal(http.StatusOK, c.do(http.MethodPost, rolePath+"/restore", nil, &actual))
+		rq.False(actual.Deleted)
+	})
+
+	t.Run("jwks", func(t *testing.T) {
+		rq := require.New(t)
+		var set token.JWKSet
+		rq.Equal(http.StatusOK, u.do(http.MethodGet, "/.well-known/jwks.json", nil, &set))
+		rq.Empty(set.Keys)
+
+		record, err := keys.Rotate(context.Background())
+		rq.NoError(err)
+		rq.Equal(http.StatusOK, u.do(http.MethodGet, "/.well-known/jwks.json", nil, &set))
+		rq.Len(set.Keys, 1)
+		rq.Equal(record.KeyID, set.Keys[0].KeyID)
+		rq.Empty(set.Keys[0].N)
+		rq.NotEmpty(set.Keys[0].X)
+
+		// not served without a key store
+		without := testClient{t: t, handler: New(store, nil, nil, nil).User()}
+		rq.Equal(http.StatusNotFound, without.do(http.MethodGet, "/.well-known/jwks.json", nil, nil))
+		rq.Equal(http.StatusNotFound, without.do(http.MethodPost, "/token", nil, nil))
+	})
+
+	t.Run("login and logout", func(t *testing.T) {
+		rq := require.New(t)
+		user := &datastore.User{Username: "login_user"}
+		rq.NoError(user.SetPassword(hasher, "secret"))
+		rq.NoError(store.CreateUser(context.Background(), user))
+		sessionsPath := fmt.Sprintf("/users/%d/sessions", user.ID)
+
+		var body errorBody
+		rq.Equal(http.StatusUnauthorized, u.do(http.MethodPost, "/login", loginRequest{Username: "login_user", Password: "wrong"}, &body))
+		rq.Equal(session.ErrorInvalidCredentials.Error(), body.Error)
+		rq.Equal(http.StatusBadRequest, u.do(http.MethodPost, "/login", loginRequest{Username: "login_user"}, &body))
+
+		var first, second loginResponse
+		rq.Equal(http.StatusCreated, u.do(http.MethodPost, "/login", loginRequest{Username: "login_user", Password: "secret"}, &first))
+		rq.NotEmpty(first.Token)
+		rq.Equal(user.ID, first.Session.UserID)
+		rq.Equal(http.StatusCreated, u.do(http.MethodPost, "/login", loginRequest{Username: "login_user", Password: "secret"}, &second))
+
+		var page listResponse[sessionResource]
+		rq.Equal(http.StatusOK, c.do(http.MethodGet, sessionsPath, nil, &page))
+		rq.Len(page.Items, 2)
+
+		// logout with the token, revoke the other one as an admin
+		userClient := testClient{t: t, handler: u.handler, token: first.Token}
+		rq.Equal(http.StatusNoContent, userClient.do(http.MethodPost, "/logout", nil, nil))
+		rq.Equal(http.StatusUnauthorized, userClient.do(http.MethodPost, "/logout", nil, &body))
+		rq.Equal(http.StatusUnauthorized, u.do(http.MethodPost, "/logout", nil, &body))
+
+		sessionPath := fmt.Sprintf("/sessions/%d", second.Session.ID)
+		rq.Equal(http.StatusNoContent, c.do(http.MethodDelete, sessionPath, nil, nil))
+		rq.Equal(http.StatusNotFound, c.do(http.MethodDelete, sessionPath, nil, &body))
+
+		page = listResponse[sessionResource]{}
+		rq.Equal(http.StatusOK, c.do(http.MethodGet, sessionsPath, nil, &page))
+		rq.Empty(page.Items)
+	})
+
+	t.Run("admin and user routes apart", func(t *testing.T) {
+		rq := require.New(t)
+		rq.Equal(http.StatusNotFound, c.do(http.MethodPost, "/login", loginRequest{Username: "x", Password: "x"}, nil))
+		rq.Equal(http.StatusNotFound, c.do(http.MethodPost, "/token/refresh", refreshRequest{RefreshToken: "x"}, nil))
+		rq.Equal(http.StatusNotFound, u.do(http.MethodGet, "/authorities", nil, nil))
+		rq.Equal(http.StatusNotFound, u.do(http.MethodPut, "/users/1/roles/role1", nil, nil))
+	})
+
+	t.Run("the actor header is ignored on the user api", func(t *testing.T) {
+		rq := require.New(t)
+		ctx := context.Background()
+		user := &datastore.User{Username: "spoof_user"}
+		rq.NoError(user.SetPassword(hasher, "secret"))
+		rq.NoError(store.CreateUser(ctx, user))
+
+		spoofing := testClient{t: t, handler: u.handler, actor: "admin"}
+		var login loginResponse
+		rq.Equal(http.StatusCreated, spoofing.do(http.MethodPost, "/login", loginRequest{Username: "spoof_user", Password: "secret"}, &login))
+
+		events, _, err := store.ListAuditEvents(ctx, datastore.AuditListOption{Actor: "spoof_user"})
+		rq.NoError(err)
+		rq.Len(events, 1)
+		rq.Equal(datastore.AuditTargetSession, events[0].TargetType)
+		rq.Equal(login.Session.ID, events[0].TargetID)
+
+		events, _, err = store.ListAuditEvents(ctx, datastore.AuditListOption{Actor: "admin"})
+		rq.NoError(err)
+		rq.Empty(events)
+	})
+
+	t.Run("tokens", func(t *testing.T) {
+		rq := require.New(t)
+		user := &datastore.User{Username: "token_user"}
+		rq.NoError(user.SetPassword(hasher, "secret"))
+		rq.NoError(store.CreateUser(context.Background(), user))
+		revokePath := fmt.Sprintf("/users/%d/refresh_tokens", user.ID)
+
+		var login loginResponse
+		rq.Equal(http.StatusCreated, u.do(http.MethodPost, "/login", loginRequest{Username: "token_user", Password: "secret"}, &login))
+		userClient := testClient{t: t, handler: u.handler, token: login.Token}
+
+		var body errorBody
+		rq.Equal(http.StatusUnauthorized, u.do(http.MethodPost, "/token", nil, &body))
+
+		var first tokenResponse
+		rq.Equal(http.StatusCreated, userClient.do(http.MethodPost, "/token", nil, &first))
+		rq.Equal(tokenTypeBearer, first.TokenType)
+		rq.Equal(int64(tokenCfg.TTL), first.ExpiresIn)
+		claims, err := token.NewVerifier(keys, tokenCfg).Verify(context.Background(), first.AccessToken)
+		rq.NoError(err)
+		rq.Equal(fmt.Sprint(user.ID), claims.Subject)
+
+		// rotated on every use
+		var second tokenResponse
+		rq.Equal(http.StatusOK, u.do(http.MethodPost, "/token/refresh", refreshRequest{RefreshToken: first.RefreshToken}, &second))
+		rq.NotEqual(first.RefreshToken, second.RefreshToken)
+		rq.Equal(http.StatusBadRequest, u.do(http.MethodPost, "/token/refresh", refreshRequest{}, &body))
+
+		// a replay revokes the family
+		rq.Equal(http.StatusUnauthorized, u.do(http.MethodPost, "/token/refresh", refreshRequest{RefreshToken: first.RefreshToken}, &body))
+		rq.Equal(token.ErrorRefreshTokenReused.Error(), body.Error)
+		rq.Equal(http.StatusUnauthorized, u.do(http.MethodPost, "/token/refresh", refreshRequest{RefreshToken: second.RefreshToken}, &body))
+		rq.Equal(token.ErrorInvalidRefreshToken.Error(), body.Error)
+
+		// revoked by an admin
+		var third tokenResponse
+		rq.Equal(http.StatusCreated, userClient.do(http.MethodPost, "/token", nil, &third))
+		var revoked revokeResponse
+		rq.Equal(http.StatusOK, c.do(http.MethodDelete, revokePath, nil, &revoked))
+		rq.Equal(int64(1), revoked.Revoked)
+		rq.Equal(http.StatusUnauthorized, u.do(http.MethodPost, "/token/refresh", refreshRequest{RefreshToken: third.RefreshToken}, &body))
+		rq.Equal(http.StatusNotFound, c.do(http.MethodDelete, "/users/99999/refresh_tokens", nil, &body))
+	})
+}
+
+func TestStatusOf(t *testing.T) {
+	rq := require.New(t)
+	for err, status := range map[error]int{
+		datastore.ErrorAuthExist:                            http.StatusConflict,
+		datastore.ErrorRoleExist:                            http.StatusConflict,
+		datastore.ErrorUserNotExist:                         http.StatusNotFound,
+		datastore.ErrorDeleteAuthWithBinding:                http.StatusPreconditionFailed,
+		datastore.ErrorActorRequired:                        http.StatusUnauthorized,
+		datastore.ErrorSessionNotExist:                      http.StatusNotFound,
+		session.ErrorInvalidSession:                         http.StatusUnauthorized,
+		token.ErrorRefreshTokenReused:                       http.StatusUnauthorized,
+		fmt.Errorf("wrapped, %w", datastore.ErrorAuthExist): http.StatusConflict,
+		fmt.Errorf("fail to commit session"):                http.StatusInternalServerError,
+	} {
+		rq.Equal(status, statusOf(err), err.Error())
+	}
+}
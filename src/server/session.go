@@ -0,0 +1,113 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hanzezhenalex/auth/src/datastore"
+	"github.com/hanzezhenalex/auth/src/session"
+)
+
+const bearerPrefix = "Bearer "
+
+type loginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type loginResponse struct {
+	Token   string          `json:"token"`
+	Session sessionResource `json:"session"`
+}
+
+type sessionResource struct {
+	ID         int64     `json:"id"`
+	UserID     int64     `json:"user_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+}
+
+func newSessionResource(sess *datastore.Session) sessionResource {
+	return sessionResource{
+		ID:         sess.ID,
+		UserID:     sess.UserID,
+		CreatedAt:  sess.CreatedAt,
+		ExpiresAt:  time.Unix(0, sess.ExpiresAt),
+		LastSeenAt: time.Unix(0, sess.LastSeenAt),
+	}
+}
+
+// bearerToken returns the token of the Authorization header
+func bearerToken(r *http.Request) (string, error) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, bearerPrefix) {
+		return "", session.ErrorInvalidSession
+	}
+	return strings.TrimPrefix(header, bearerPrefix), nil
+}
+
+func (s *Server) login(w http.ResponseWriter, r *http.Request, _ params) {
+	var req loginRequest
+	if err := decodeBody(w, r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+	if req.Username == "" || req.Password == "" {
+		writeError(w, fmt.Errorf("%w, username or password is empty", errorInvalidBody))
+		return
+	}
+
+	token, sess, err := s.sessions.Login(r.Context(), req.Username, req.Password)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, loginResponse{Token: token, Session: newSessionResource(sess)})
+}
+
+// logout revokes the session of the bearer token
+func (s *Server) logout(w http.ResponseWriter, r *http.Request, _ params) {
+	token, err := bearerToken(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := s.sessions.RevokeSession(r.Context(), token); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) listUserSessions(w http.ResponseWriter, r *http.Request, p params) {
+	id, err := pathID(p)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	sessions, err := s.sessions.ListUserSessions(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newListResponse(sessions, "", newSessionResource))
+}
+
+func (s *Server) revokeSession(w http.ResponseWriter, r *http.Request, p params) {
+	id, err := pathID(p)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := s.store.RevokeSessionByID(r.Context(), id); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
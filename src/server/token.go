@@ -0,0 +1,91 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/hanzezhenalex/auth/src/token"
+)
+
+const tokenTypeBearer = "Bearer"
+
+// tokenResponse follows the access token response of RFC 6749 5.1
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func newTokenResponse(pair *token.Pair) tokenResponse {
+	return tokenResponse{
+		AccessToken:  pair.AccessToken,
+		TokenType:    tokenTypeBearer,
+		ExpiresIn:    pair.Claims.ExpiresAt - pair.Claims.IssuedAt,
+		RefreshToken: pair.RefreshToken,
+	}
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type revokeResponse struct {
+	Revoked int64 `json:"revoked"`
+}
+
+// issueToken trades the session of the bearer token for a token pair
+func (s *Server) issueToken(w http.ResponseWriter, r *http.Request, _ params) {
+	plain, err := bearerToken(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	sess, err := s.sessions.ValidateSession(r.Context(), plain)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	pair, err := s.tokens.Issue(r.Context(), sess.UserID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, newTokenResponse(pair))
+}
+
+// refreshToken trades a refresh token for the next pair
+func (s *Server) refreshToken(w http.ResponseWriter, r *http.Request, _ params) {
+	var req refreshRequest
+	if err := decodeBody(w, r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+	if req.RefreshToken == "" {
+		writeError(w, fmt.Errorf("%w, refresh_token is empty", errorInvalidBody))
+		return
+	}
+
+	pair, err := s.tokens.Refresh(r.Context(), req.RefreshToken)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newTokenResponse(pair))
+}
+
+func (s *Server) revokeUserRefreshTokens(w http.ResponseWriter, r *http.Request, p params) {
+	id, err := pathID(p)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	n, err := s.store.RevokeUserRefreshTokens(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, revokeResponse{Revoked: n})
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/hanzezhenalex/auth/src/datastore"
+)
+
+type userRolesResponse struct {
+	Roles []string `json:"roles"`
+}
+
+func (s *Server) listUserRoles(w http.ResponseWriter, r *http.Request, p params) {
+	id, err := pathID(p)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	roles, err := s.store.ListUserRoles(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if roles == nil {
+		roles = []string{}
+	}
+	writeJSON(w, http.StatusOK, userRolesResponse{Roles: roles})
+}
+
+// assignUserRole is idempotent, assigning an assigned role does nothing
+func (s *Server) assignUserRole(w http.ResponseWriter, r *http.Request, p params) {
+	id, err := pathID(p)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := s.store.AssignRoles(r.Context(), id, []string{p["name"]}); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) unassignUserRole(w http.ResponseWriter, r *http.Request, p params) {
+	id, err := pathID(p)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := s.store.UnassignRoles(r.Context(), id, []string{p["name"]}); err != nil {
+		// the binding is the resource here, it is not found
+		if errors.Is(err, datastore.ErrorUnassignNonExistedRoles) {
+			err = fmt.Errorf("%w, role %s is not assigned", errorNotFound, p["name"])
+		}
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
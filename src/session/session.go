@@ -0,0 +1,193 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hanzezhenalex/auth/src"
+	"github.com/hanzezhenalex/auth/src/datastore"
+	"github.com/hanzezhenalex/auth/src/password"
+)
+
+/*
+	Tokens
+
+	A token is tokenLength bytes from crypto/rand, encoded with unpadded
+	url-safe base64. It is opaque, and only its sha256 is stored, so the
+	session table cannot be replayed if it leaks.
+*/
+
+const tokenLength = 32
+
+// maxTouchInterval bounds how stale last_seen_at may be, a session used
+// within the interval is not written again
+const maxTouchInterval = time.Minute
+
+var (
+	ErrorInvalidCredentials = errors.New("invalid username or password")
+	ErrorInvalidSession     = errors.New("invalid session")
+)
+
+// Manager logs users in and checks their sessions
+type Manager struct {
+	store       datastore.Datastore
+	hasher      *password.Hasher
+	ttl         time.Duration
+	idleTimeout time.Duration
+	now         func() time.Time
+}
+
+func NewManager(store datastore.Datastore, hasher *password.Hasher, cfg src.SessionConfig) *Manager {
+	return &Manager{
+		store:       store,
+		hasher:      hasher,
+		ttl:         time.Duration(cfg.TTL) * time.Second,
+		idleTimeout: time.Duration(cfg.IdleTimeout) * time.Second,
+		now:         time.Now,
+	}
+}
+
+func newToken() (string, error) {
+	raw := make([]byte, tokenLength)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("fail to generate token, %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// HashToken returns what is stored for token
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Login verifies the password of the named user and opens a session. The
+// token is returned only here. Unless ctx names an actor, the user is the
+// actor of its own login.
+func (m *Manager) Login(ctx context.Context, username, plain string) (string, *datastore.Session, error) {
+	if datastore.ActorFromContext(ctx) == "" {
+		ctx = datastore.WithActor(ctx, username)
+	}
+
+	user, err := datastore.AuthenticateUser(ctx, m.store, m.hasher, username, plain)
+	switch {
+	case errors.Is(err, datastore.ErrorUserNotExist):
+		// hash anyway, an unknown name takes as long as a wrong password
+		_, _ = m.hasher.Hash(plain)
+		return "", nil, ErrorInvalidCredentials
+	case errors.Is(err, datastore.ErrorPasswordMismatch):
+		return "", nil, ErrorInvalidCredentials
+	case err != nil:
+		return "", nil, err
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := m.now()
+	sess := &datastore.Session{
+		UserID:     user.ID,
+		TokenHash:  HashToken(token),
+		ExpiresAt:  now.Add(m.ttl).UnixNano(),
+		LastSeenAt: now.UnixNano(),
+	}
+	if err := m.store.CreateSession(ctx, sess); err != nil {
+		return "", nil, fmt.Errorf("fail to create session, %w", err)
+	}
+	return token, sess, nil
+}
+
+// active reports whether the session is neither expired nor idle for too long
+func (m *Manager) active(sess *datastore.Session, now time.Time) bool {
+	return now.UnixNano() < sess.ExpiresAt &&
+		now.Sub(time.Unix(0, sess.LastSeenAt)) < m.idleTimeout
+}
+
+// touchInterval is how long a session is used before last_seen_at is
+// moved, short enough for the idle timeout to stay accurate
+func (m *Manager) touchInterval() time.Duration {
+	if interval := m.idleTimeout / 10; interval < maxTouchInterval {
+		return interval
+	}
+	return maxTouchInterval
+}
+
+// ValidateSession returns the active session of token, and keeps it from
+// going idle
+func (m *Manager) ValidateSession(ctx context.Context, token string) (*datastore.Session, error) {
+	sess, err := m.store.GetSessionByTokenHash(ctx, HashToken(token))
+	if errors.Is(err, datastore.ErrorSessionNotExist) {
+		return nil, ErrorInvalidSession
+	} else if err != nil {
+		return nil, err
+	}
+
+	now := m.now()
+	if !m.active(sess, now) {
+		return nil, ErrorInvalidSession
+	}
+
+	if now.Sub(time.Unix(0, sess.LastSeenAt)) >= m.touchInterval() {
+		if err := m.store.TouchSession(ctx, sess.ID, now); err != nil {
+			if errors.Is(err, datastore.ErrorSessionNotExist) {
+				// revoked in between
+				return nil, ErrorInvalidSession
+			}
+			return nil, err
+		}
+		sess.LastSeenAt = now.UnixNano()
+	}
+	return sess, nil
+}
+
+// RevokeSession ends the session of token, a logout. Unless ctx names an
+// actor, the owner of the session is the actor.
+func (m *Manager) RevokeSession(ctx context.Context, token string) error {
+	sess, err := m.store.GetSessionByTokenHash(ctx, HashToken(token))
+	if errors.Is(err, datastore.ErrorSessionNotExist) {
+		return ErrorInvalidSession
+	} else if err != nil {
+		return err
+	}
+
+	if datastore.ActorFromContext(ctx) == "" {
+		user, err := m.store.GetUserByID(ctx, sess.UserID)
+		if err != nil {
+			return err
+		}
+		ctx = datastore.WithActor(ctx, user.Username)
+	}
+
+	if err := m.store.RevokeSessionByID(ctx, sess.ID); err != nil {
+		if errors.Is(err, datastore.ErrorSessionNotExist) {
+			return ErrorInvalidSession
+		}
+		return err
+	}
+	return nil
+}
+
+// ListUserSessions returns the active sessions of a user
+func (m *Manager) ListUserSessions(ctx context.Context, userID int64) ([]*datastore.Session, error) {
+	sessions, err := m.store.ListUserSessions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := m.now()
+	active := sessions[:0]
+	for _, sess := range sessions {
+		if m.active(sess, now) {
+			active = append(active, sess)
+		}
+	}
+	return active, nil
+}
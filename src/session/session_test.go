@@ -0,0 +1,195 @@
+package session
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hanzezhenalex/auth/src"
+	"github.com/hanzezhenalex/auth/src/datastore"
+	"github.com/hanzezhenalex/auth/src/datastore/memory"
+	"github.com/hanzezhenalex/auth/src/password"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestManager(t *testing.T) (*Manager, datastore.Datastore, *datastore.User) {
+	store := memory.NewMemoryDatastore()
+	hasherCfg := src.NewPasswordConfig()
+	hasherCfg.Iterations = password.MinIterations
+	hasher, err := password.NewHasher(hasherCfg)
+	require.NoError(t, err)
+
+	user := &datastore.User{Username: "user1"}
+	require.NoError(t, user.SetPassword(hasher, "secret"))
+	require.NoError(t, store.CreateUser(context.Background(), user))
+
+	m := NewManager(store, hasher, src.SessionConfig{TTL: 3600, IdleTimeout: 600})
+	return m, store, user
+}
+
+// clock is a manual time source for Manager.now
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) now() time.Time {
+	return c.t
+}
+
+func TestLogin(t *testing.T) {
+	ctx := context.Background()
+	m, store, user := newTestManager(t)
+
+	t.Run("wrong password", func(t *testing.T) {
+		rq := require.New(t)
+		_, _, err := m.Login(ctx, user.Username, "wrong")
+		rq.ErrorIs(err, ErrorInvalidCredentials)
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		rq := require.New(t)
+		_, _, err := m.Login(ctx, "nobody", "secret")
+		rq.ErrorIs(err, ErrorInvalidCredentials)
+	})
+
+	t.Run("stores the hash only", func(t *testing.T) {
+		rq := require.New(t)
+		token, sess, err := m.Login(ctx, user.Username, "secret")
+		rq.NoError(err)
+		rq.NotEmpty(token)
+		rq.Equal(user.ID, sess.UserID)
+
+		_, err = store.GetSessionByTokenHash(ctx, token)
+		rq.ErrorIs(err, datastore.ErrorSessionNotExist)
+		stored, err := store.GetSessionByTokenHash(ctx, HashToken(token))
+		rq.NoError(err)
+		rq.Equal(sess.ID, stored.ID)
+
+		other, _, err := m.Login(ctx, user.Username, "secret")
+		rq.NoError(err)
+		rq.NotEqual(token, other)
+	})
+
+	t.Run("the user is the actor", func(t *testing.T) {
+		rq := require.New(t)
+		_, sess, err := m.Login(ctx, user.Username, "secret")
+		rq.NoError(err)
+
+		events, _, err := store.ListAuditEvents(ctx, datastore.AuditListOption{Actor: user.Username})
+		rq.NoError(err)
+		rq.NotEmpty(events)
+		last := events[len(events)-1]
+		rq.Equal(datastore.AuditTargetSession, last.TargetType)
+		rq.Equal(sess.ID, last.TargetID)
+	})
+}
+
+func TestValidateSession(t *testing.T) {
+	ctx := context.Background()
+	m, store, user := newTestManager(t)
+	c := &clock{t: time.Now()}
+	m.now = c.now
+
+	t.Run("valid", func(t *testing.T) {
+		rq := require.New(t)
+		token, sess, err := m.Login(ctx, user.Username, "secret")
+		rq.NoError(err)
+
+		actual, err := m.ValidateSession(ctx, token)
+		rq.NoError(err)
+		rq.Equal(sess.ID, actual.ID)
+
+		_, err = m.ValidateSession(ctx, "unknown")
+		rq.ErrorIs(err, ErrorInvalidSession)
+		_, err = m.ValidateSession(ctx, "")
+		rq.ErrorIs(err, ErrorInvalidSession)
+	})
+
+	t.Run("idle timeout", func(t *testing.T) {
+		rq := require.New(t)
+		token, _, err := m.Login(ctx, user.Username, "secret")
+		rq.NoError(err)
+
+		// used every 5 minutes, it stays active
+		for i := 0; i < 3; i++ {
+			c.t = c.t.Add(5 * time.Minute)
+			_, err = m.ValidateSession(ctx, token)
+			rq.NoError(err)
+		}
+
+		c.t = c.t.Add(10 * time.Minute)
+		_, err = m.ValidateSession(ctx, token)
+		rq.ErrorIs(err, ErrorInvalidSession)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		rq := require.New(t)
+		token, _, err := m.Login(ctx, user.Username, "secret")
+		rq.NoError(err)
+
+		// active all along, but the ttl is an hour
+		for i := 0; i < 11; i++ {
+			c.t = c.t.Add(5 * time.Minute)
+			_, err = m.ValidateSession(ctx, token)
+			rq.NoError(err)
+		}
+		c.t = c.t.Add(5 * time.Minute)
+		_, err = m.ValidateSession(ctx, token)
+		rq.ErrorIs(err, ErrorInvalidSession)
+	})
+
+	t.Run("user deleted", func(t *testing.T) {
+		rq := require.New(t)
+		other := &datastore.User{Username: "user2"}
+		rq.NoError(other.SetPassword(m.hasher, "secret"))
+		rq.NoError(store.CreateUser(ctx, other))
+
+		token, _, err := m.Login(ctx, other.Username, "secret")
+		rq.NoError(err)
+		rq.NoError(store.DeleteUserByID(ctx, other.ID))
+
+		_, err = m.ValidateSession(ctx, token)
+		rq.ErrorIs(err, ErrorInvalidSession)
+	})
+}
+
+func TestRevokeAndList(t *testing.T) {
+	ctx := context.Background()
+	m, _, user := newTestManager(t)
+	c := &clock{t: time.Now()}
+	m.now = c.now
+
+	first, _, err := m.Login(ctx, user.Username, "secret")
+	require.NoError(t, err)
+	c.t = c.t.Add(time.Minute)
+	second, sess, err := m.Login(ctx, user.Username, "secret")
+	require.NoError(t, err)
+
+	t.Run("revoke", func(t *testing.T) {
+		rq := require.New(t)
+		rq.NoError(m.RevokeSession(ctx, first))
+		_, err := m.ValidateSession(ctx, first)
+		rq.ErrorIs(err, ErrorInvalidSession)
+		rq.ErrorIs(m.RevokeSession(ctx, first), ErrorInvalidSession)
+
+		_, err = m.ValidateSession(ctx, second)
+		rq.NoError(err)
+	})
+
+	t.Run("list active", func(t *testing.T) {
+		rq := require.New(t)
+		sessions, err := m.ListUserSessions(ctx, user.ID)
+		rq.NoError(err)
+		rq.Len(sessions, 1)
+		rq.Equal(sess.ID, sessions[0].ID)
+
+		c.t = c.t.Add(time.Hour)
+		sessions, err = m.ListUserSessions(ctx, user.ID)
+		rq.NoError(err)
+		rq.Empty(sessions)
+
+		_, err = m.ListUserSessions(ctx, 99999)
+		rq.ErrorIs(err, datastore.ErrorUserNotExist)
+	})
+}
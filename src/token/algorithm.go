@@ -0,0 +1,220 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+/*
+	Algorithms
+
+	HS256 is HMAC with sha256, the secret is shared by the issuer and the
+	verifiers. RS256 and ES256 sign with a private key and verify with the
+	public one, the verifiers hold no secret. ES256 signatures are r and s
+	of 32 bytes each, as JWS wants, not the ASN.1 of crypto/ecdsa.
+*/
+
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmES256 = "ES256"
+)
+
+const (
+	// minHMACKeyLength is the hash size, RFC 7518 3.2
+	minHMACKeyLength = sha256.Size
+	minRSAKeyBits    = 2048
+	es256ByteLength  = 32
+)
+
+var ErrorWeakKey = errors.New("weak key")
+
+// Signer signs the tokens of an algorithm
+type Signer interface {
+	Algorithm() string
+	Sign(signingInput []byte) ([]byte, error)
+}
+
+// VerifyKey checks the signatures of an algorithm
+type VerifyKey interface {
+	Algorithm() string
+	Verify(signingInput []byte, signature []byte) error
+}
+
+// keyIdentified is a signer naming its key
+type keyIdentified interface {
+	KeyID() string
+}
+
+type keyedSigner struct {
+	Signer
+	kid string
+}
+
+// WithKeyID names the key of signer, Sign puts the kid in the header
+func WithKeyID(signer Signer, kid string) Signer {
+	return keyedSigner{Signer: signer, kid: kid}
+}
+
+func (signer keyedSigner) KeyID() string {
+	return signer.kid
+}
+
+func digest(signingInput []byte) []byte {
+	sum := sha256.Sum256(signingInput)
+	return sum[:]
+}
+
+/*
+	HS256
+*/
+
+// HMACKey both signs and verifies
+type HMACKey struct {
+	secret []byte
+}
+
+func NewHS256(secret []byte) (*HMACKey, error) {
+	if len(secret) < minHMACKeyLength {
+		return nil, fmt.Errorf("%w, HS256 secret needs %d bytes at least", ErrorWeakKey, minHMACKeyLength)
+	}
+	return &HMACKey{secret: append([]byte(nil), secret...)}, nil
+}
+
+func (key *HMACKey) Algorithm() string {
+	return AlgorithmHS256
+}
+
+func (key *HMACKey) Sign(signingInput []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, key.secret)
+	mac.Write(signingInput)
+	return mac.Sum(nil), nil
+}
+
+func (key *HMACKey) Verify(signingInput []byte, signature []byte) error {
+	expected, _ := key.Sign(signingInput)
+	if !hmac.Equal(expected, signature) {
+		return ErrorInvalidSignature
+	}
+	return nil
+}
+
+/*
+	RS256
+*/
+
+type RSASigner struct {
+	key *rsa.PrivateKey
+}
+
+func NewRS256Signer(key *rsa.PrivateKey) (*RSASigner, error) {
+	if key.N.BitLen() < minRSAKeyBits {
+		return nil, fmt.Errorf("%w, RS256 key needs %d bits at least", ErrorWeakKey, minRSAKeyBits)
+	}
+	return &RSASigner{key: key}, nil
+}
+
+func (signer *RSASigner) Algorithm() string {
+	return AlgorithmRS256
+}
+
+func (signer *RSASigner) Sign(signingInput []byte) ([]byte, error) {
+	signature, err := rsa.SignPKCS1v15(rand.Reader, signer.key, crypto.SHA256, digest(signingInput))
+	if err != nil {
+		return nil, fmt.Errorf("fail to sign, %w", err)
+	}
+	return signature, nil
+}
+
+// Public returns the key verifying the signatures
+func (signer *RSASigner) Public() *RSAVerifyKey {
+	return &RSAVerifyKey{key: &signer.key.PublicKey}
+}
+
+type RSAVerifyKey struct {
+	key *rsa.PublicKey
+}
+
+func NewRS256VerifyKey(key *rsa.PublicKey) *RSAVerifyKey {
+	return &RSAVerifyKey{key: key}
+}
+
+func (key *RSAVerifyKey) Algorithm() string {
+	return AlgorithmRS256
+}
+
+func (key *RSAVerifyKey) Verify(signingInput []byte, signature []byte) error {
+	if err := rsa.VerifyPKCS1v15(key.key, crypto.SHA256, digest(signingInput), signature); err != nil {
+		return ErrorInvalidSignature
+	}
+	return nil
+}
+
+/*
+	ES256
+*/
+
+type ECDSASigner struct {
+	key *ecdsa.PrivateKey
+}
+
+func NewES256Signer(key *ecdsa.PrivateKey) (*ECDSASigner, error) {
+	if key.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("%w, ES256 key must be on P-256", ErrorWeakKey)
+	}
+	return &ECDSASigner{key: key}, nil
+}
+
+func (signer *ECDSASigner) Algorithm() string {
+	return AlgorithmES256
+}
+
+func (signer *ECDSASigner) Sign(signingInput []byte) ([]byte, error) {
+	r, s, err := ecdsa.Sign(rand.Reader, signer.key, digest(signingInput))
+	if err != nil {
+		return nil, fmt.Errorf("fail to sign, %w", err)
+	}
+
+	signature := make([]byte, 2*es256ByteLength)
+	r.FillBytes(signature[:es256ByteLength])
+	s.FillBytes(signature[es256ByteLength:])
+	return signature, nil
+}
+
+// Public returns the key verifying the signatures
+func (signer *ECDSASigner) Public() *ECDSAVerifyKey {
+	return &ECDSAVerifyKey{key: &signer.key.PublicKey}
+}
+
+type ECDSAVerifyKey struct {
+	key *ecdsa.PublicKey
+}
+
+func NewES256VerifyKey(key *ecdsa.PublicKey) *ECDSAVerifyKey {
+	return &ECDSAVerifyKey{key: key}
+}
+
+func (key *ECDSAVerifyKey) Algorithm() string {
+	return AlgorithmES256
+}
+
+func (key *ECDSAVerifyKey) Verify(signingInput []byte, signature []byte) error {
+	if len(signature) != 2*es256ByteLength {
+		return ErrorInvalidSignature
+	}
+
+	r := new(big.Int).SetBytes(signature[:es256ByteLength])
+	s := new(big.Int).SetBytes(signature[es256ByteLength:])
+	if !ecdsa.Verify(key.key, digest(signingInput), r, s) {
+		return ErrorInvalidSignature
+	}
+	return nil
+}
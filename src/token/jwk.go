@@ -0,0 +1,131 @@
+package token
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+/*
+	JWK
+
+	The public keys, RFC 7517, as served on /.well-known/jwks.json. Only
+	RSA and P-256 keys are published, an HS256 secret never is.
+*/
+
+const (
+	keyTypeRSA   = "RSA"
+	keyTypeEC    = "EC"
+	curveP256    = "P-256"
+	keyUseSigned = "sig"
+)
+
+var ErrorUnsupportedKey = errors.New("unsupported key")
+
+// JWK is the public part of a signing key
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg"`
+
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// EC
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	Y     string `json:"y,omitempty"`
+}
+
+// JWKSet is a KeySet, a service verifies the tokens with the set fetched
+// from the issuer
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewJWK publishes key under kid
+func NewJWK(kid string, key VerifyKey) (JWK, error) {
+	jwk := JWK{KeyID: kid, Use: keyUseSigned, Algorithm: key.Algorithm()}
+
+	switch k := key.(type) {
+	case *RSAVerifyKey:
+		jwk.KeyType = keyTypeRSA
+		jwk.N = encoding.EncodeToString(k.key.N.Bytes())
+		jwk.E = encoding.EncodeToString(big.NewInt(int64(k.key.E)).Bytes())
+	case *ECDSAVerifyKey:
+		jwk.KeyType = keyTypeEC
+		jwk.Curve = curveP256
+		x := make([]byte, es256ByteLength)
+		y := make([]byte, es256ByteLength)
+		k.key.X.FillBytes(x)
+		k.key.Y.FillBytes(y)
+		jwk.X = encoding.EncodeToString(x)
+		jwk.Y = encoding.EncodeToString(y)
+	default:
+		return JWK{}, fmt.Errorf("%w, %s", ErrorUnsupportedKey, key.Algorithm())
+	}
+	return jwk, nil
+}
+
+// VerifyKey decodes the public key
+func (jwk JWK) VerifyKey() (VerifyKey, error) {
+	switch {
+	case jwk.KeyType == keyTypeRSA && jwk.Algorithm == AlgorithmRS256:
+		n, err := decodeInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() < 2 || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("%w, exponent of %s", ErrorUnsupportedKey, jwk.KeyID)
+		}
+		if n.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("%w, RS256 key needs %d bits at least", ErrorWeakKey, minRSAKeyBits)
+		}
+		return NewRS256VerifyKey(&rsa.PublicKey{N: n, E: int(e.Int64())}), nil
+
+	case jwk.KeyType == keyTypeEC && jwk.Algorithm == AlgorithmES256 && jwk.Curve == curveP256:
+		x, err := decodeInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		curve := elliptic.P256()
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("%w, point of %s is not on the curve", ErrorUnsupportedKey, jwk.KeyID)
+		}
+		return NewES256VerifyKey(&ecdsa.PublicKey{Curve: curve, X: x, Y: y}), nil
+
+	default:
+		return nil, fmt.Errorf("%w, %s %s", ErrorUnsupportedKey, jwk.KeyType, jwk.Algorithm)
+	}
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	raw, err := encoding.DecodeString(s)
+	if err != nil || len(raw) == 0 {
+		return nil, fmt.Errorf("%w, malformed member", ErrorUnsupportedKey)
+	}
+	return new(big.Int).SetBytes(raw), nil
+}
+
+func (set *JWKSet) VerifyKey(_ context.Context, kid string) (VerifyKey, error) {
+	for _, jwk := range set.Keys {
+		if jwk.KeyID == kid {
+			return jwk.VerifyKey()
+		}
+	}
+	return nil, fmt.Errorf("%w, %q", ErrorUnknownKey, kid)
+}
@@ -0,0 +1,451 @@
+package token
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/hanzezhenalex/auth/src"
+	"github.com/hanzezhenalex/auth/src/datastore"
+)
+
+/*
+	Key store
+
+	The signing keys live in the datastore, their private keys in PKCS #8
+	sealed with AES-256-GCM under the master key. The kid is the additional
+	data, a sealed key moved to another row does not open.
+
+	The newest key not retired signs. Rotating adds a key and retires the
+	older ones, a retired key still verifies for the grace period, then it
+	is deleted. Every instance sharing the datastore sees the same keys.
+	Keys only retire those before them, two instances rotating at once
+	leave the newest of their keys signing, and the other to verify with
+	until the next rotation.
+*/
+
+const (
+	masterKeyLength = 32
+	kidLength       = 8
+
+	// keyStoreActor is the actor of the rotations, unless ctx names one
+	keyStoreActor = "keystore"
+
+	maintainInterval = time.Minute
+	// reloadInterval bounds the reloads caused by unknown kids
+	reloadInterval = 10 * time.Second
+)
+
+var (
+	ErrorInvalidMasterKey = errors.New("invalid master key")
+	ErrorInvalidKeyConfig = errors.New("invalid signing key config")
+	ErrorNoSigningKey     = errors.New("no active signing key")
+)
+
+type storedKey struct {
+	record *datastore.SigningKey
+	signer Signer
+	key    VerifyKey
+}
+
+// KeyStore is a SignerSource and a KeySet over the signing keys of the
+// datastore. The keys are cached, opened once.
+type KeyStore struct {
+	store     datastore.Datastore
+	aead      cipher.AEAD
+	algorithm string
+	rotation  time.Duration
+	grace     time.Duration
+	now       func() time.Time
+
+	mu       sync.RWMutex
+	keys     []*storedKey
+	loadedAt time.Time
+}
+
+// CheckKeyConfig refuses a rotation period which is not positive, and a
+// grace period shorter than the tokens of tokenCfg may live, their ttl plus
+// the leeway. A retired key must verify every token it signed.
+func CheckKeyConfig(cfg src.KeyConfig, tokenCfg src.TokenConfig) error {
+	if cfg.RotationPeriod <= 0 {
+		return fmt.Errorf("%w, rotation_period must be positive", ErrorInvalidKeyConfig)
+	}
+	if least := tokenCfg.TTL + tokenCfg.Leeway; cfg.GracePeriod < least {
+		return fmt.Errorf("%w, grace_period needs %d at least, the token ttl plus leeway", ErrorInvalidKeyConfig, least)
+	}
+	return nil
+}
+
+// NewKeyStore opens the keys of store, which sign the tokens of tokenCfg
+func NewKeyStore(store datastore.Datastore, cfg src.KeyConfig, tokenCfg src.TokenConfig) (*KeyStore, error) {
+	masterKey, err := base64.StdEncoding.DecodeString(cfg.MasterKey)
+	if err != nil || len(masterKey) != masterKeyLength {
+		return nil, fmt.Errorf("%w, it must be the base64 of %d bytes", ErrorInvalidMasterKey, masterKeyLength)
+	}
+	block, err := aes.NewCipher(masterKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w, %s", ErrorInvalidMasterKey, err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("%w, %s", ErrorInvalidMasterKey, err)
+	}
+
+	if cfg.Algorithm != AlgorithmES256 && cfg.Algorithm != AlgorithmRS256 {
+		return nil, fmt.Errorf("%w, %q", ErrorUnsupportedKey, cfg.Algorithm)
+	}
+	if err := CheckKeyConfig(cfg, tokenCfg); err != nil {
+		return nil, err
+	}
+
+	return &KeyStore{
+		store:     store,
+		aead:      aead,
+		algorithm: cfg.Algorithm,
+		rotation:  time.Duration(cfg.RotationPeriod) * time.Second,
+		grace:     time.Duration(cfg.GracePeriod) * time.Second,
+		now:       time.Now,
+	}, nil
+}
+
+func withKeyStoreActor(ctx context.Context) context.Context {
+	if datastore.ActorFromContext(ctx) == "" {
+		return datastore.WithActor(ctx, keyStoreActor)
+	}
+	return ctx
+}
+
+/*
+	Sealing
+*/
+
+func (ks *KeyStore) seal(kid string, plain []byte) (string, error) {
+	nonce := make([]byte, ks.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("fail to generate nonce, %w", err)
+	}
+	sealed := ks.aead.Seal(nonce, nonce, plain, []byte(kid))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (ks *KeyStore) open(kid string, sealed string) ([]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(raw) < ks.aead.NonceSize() {
+		return nil, fmt.Errorf("malformed sealed key %s", kid)
+	}
+	nonce, ciphertext := raw[:ks.aead.NonceSize()], raw[ks.aead.NonceSize():]
+	plain, err := ks.aead.Open(nil, nonce, ciphertext, []byte(kid))
+	if err != nil {
+		return nil, fmt.Errorf("fail to open key %s, wrong master key? %w", kid, err)
+	}
+	return plain, nil
+}
+
+func generateKey(algorithm string) ([]byte, error) {
+	var (
+		key interface{}
+		err error
+	)
+	switch algorithm {
+	case AlgorithmES256:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgorithmRS256:
+		key, err = rsa.GenerateKey(rand.Reader, minRSAKeyBits)
+	default:
+		return nil, fmt.Errorf("%w, %q", ErrorUnsupportedKey, algorithm)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fail to generate key, %w", err)
+	}
+	return x509.MarshalPKCS8PrivateKey(key)
+}
+
+// parseKey returns the signer, named by kid, and the verify key of a
+// PKCS #8 private key
+func parseKey(kid string, algorithm string, der []byte) (Signer, VerifyKey, error) {
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, nil, fmt.Errorf("fail to parse key %s, %w", kid, err)
+	}
+
+	switch key := parsed.(type) {
+	case *ecdsa.PrivateKey:
+		if algorithm == AlgorithmES256 {
+			signer, err := NewES256Signer(key)
+			if err != nil {
+				return nil, nil, err
+			}
+			return WithKeyID(signer, kid), signer.Public(), nil
+		}
+	case *rsa.PrivateKey:
+		if algorithm == AlgorithmRS256 {
+			signer, err := NewRS256Signer(key)
+			if err != nil {
+				return nil, nil, err
+			}
+			return WithKeyID(signer, kid), signer.Public(), nil
+		}
+	}
+	return nil, nil, fmt.Errorf("%w, key %s is not %s", ErrorUnsupportedKey, kid, algorithm)
+}
+
+/*
+	Cache
+*/
+
+// load reads the keys from the datastore, the keys already opened are kept
+func (ks *KeyStore) load(ctx context.Context) error {
+	records, err := ks.store.ListSigningKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	ks.mu.RLock()
+	opened := make(map[string]*storedKey, len(ks.keys))
+	for _, key := range ks.keys {
+		opened[key.record.KeyID] = key
+	}
+	ks.mu.RUnlock()
+
+	keys := make([]*storedKey, 0, len(records))
+	for _, record := range records {
+		if key, ok := opened[record.KeyID]; ok {
+			keys = append(keys, &storedKey{record: record, signer: key.signer, key: key.key})
+			continue
+		}
+
+		der, err := ks.open(record.KeyID, record.PrivateKey)
+		if err != nil {
+			return err
+		}
+		signer, key, err := parseKey(record.KeyID, record.Algorithm, der)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, &storedKey{record: record, signer: signer, key: key})
+	}
+
+	ks.mu.Lock()
+	ks.keys = keys
+	ks.loadedAt = ks.now()
+	ks.mu.Unlock()
+	return nil
+}
+
+// verifies reports whether the key verifies tokens at now
+func (ks *KeyStore) verifies(key *storedKey, now time.Time) bool {
+	return key.record.RetiredAt == 0 || now.Before(time.Unix(0, key.record.RetiredAt).Add(ks.grace))
+}
+
+// signing returns the newest key not retired
+func (ks *KeyStore) signing() *storedKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	for i := len(ks.keys) - 1; i >= 0; i-- {
+		if ks.keys[i].record.RetiredAt == 0 {
+			return ks.keys[i]
+		}
+	}
+	return nil
+}
+
+func (ks *KeyStore) lookup(kid string) *storedKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	now := ks.now()
+	for _, key := range ks.keys {
+		if key.record.KeyID == kid && ks.verifies(key, now) {
+			return key
+		}
+	}
+	return nil
+}
+
+/*
+	Keys
+*/
+
+// Signer returns the signer of the newest key, its kid goes to the header
+func (ks *KeyStore) Signer(ctx context.Context) (Signer, error) {
+	key := ks.signing()
+	if key == nil {
+		if err := ks.load(ctx); err != nil {
+			return nil, err
+		}
+		if key = ks.signing(); key == nil {
+			return nil, ErrorNoSigningKey
+		}
+	}
+	return key.signer, nil
+}
+
+// VerifyKey returns the key of kid, active or within its grace period. An
+// unknown kid reloads the keys, it may come from another instance.
+func (ks *KeyStore) VerifyKey(ctx context.Context, kid string) (VerifyKey, error) {
+	if key := ks.lookup(kid); key != nil {
+		return key.key, nil
+	}
+
+	ks.mu.RLock()
+	stale := ks.now().Sub(ks.loadedAt) >= reloadInterval
+	ks.mu.RUnlock()
+	if stale {
+		if err := ks.load(ctx); err != nil {
+			return nil, err
+		}
+		if key := ks.lookup(kid); key != nil {
+			return key.key, nil
+		}
+	}
+	return nil, fmt.Errorf("%w, %q", ErrorUnknownKey, kid)
+}
+
+// JWKS returns the public keys verifying tokens now
+func (ks *KeyStore) JWKS(ctx context.Context) (*JWKSet, error) {
+	ks.mu.RLock()
+	loaded := !ks.loadedAt.IsZero()
+	ks.mu.RUnlock()
+	if !loaded {
+		if err := ks.load(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	now := ks.now()
+	set := &JWKSet{Keys: []JWK{}}
+	for _, key := range ks.keys {
+		if !ks.verifies(key, now) {
+			continue
+		}
+		jwk, err := NewJWK(key.record.KeyID, key.key)
+		if err != nil {
+			return nil, err
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set, nil
+}
+
+/*
+	Rotation
+*/
+
+// Rotate adds a key, which signs from now on, and retires the older ones
+func (ks *KeyStore) Rotate(ctx context.Context) (*datastore.SigningKey, error) {
+	ctx = withKeyStoreActor(ctx)
+
+	der, err := generateKey(ks.algorithm)
+	if err != nil {
+		return nil, err
+	}
+	rawKID := make([]byte, kidLength)
+	if _, err := rand.Read(rawKID); err != nil {
+		return nil, fmt.Errorf("fail to generate kid, %w", err)
+	}
+	kid := hex.EncodeToString(rawKID)
+	sealed, err := ks.seal(kid, der)
+	if err != nil {
+		return nil, err
+	}
+
+	now := ks.now()
+	record := &datastore.SigningKey{
+		KeyID:       kid,
+		Algorithm:   ks.algorithm,
+		PrivateKey:  sealed,
+		ActivatedAt: now.UnixNano(),
+	}
+	if err := ks.store.CreateSigningKey(ctx, record); err != nil {
+		return nil, fmt.Errorf("fail to create signing key, %w", err)
+	}
+
+	records, err := ks.store.ListSigningKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, other := range records {
+		// a newer key comes from a concurrent rotation, it must keep signing
+		if other.ID < record.ID && other.RetiredAt == 0 {
+			if err := ks.store.RetireSigningKey(ctx, other.ID, now); err != nil {
+				return nil, fmt.Errorf("fail to retire signing key %s, %w", other.KeyID, err)
+			}
+		}
+	}
+
+	if err := ks.load(ctx); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// Maintain rotates the keys once the newest one is older than the rotation
+// period, and deletes the keys past their grace period
+func (ks *KeyStore) Maintain(ctx context.Context) error {
+	ctx = withKeyStoreActor(ctx)
+	if err := ks.load(ctx); err != nil {
+		return err
+	}
+
+	now := ks.now()
+	if key := ks.signing(); key == nil || !now.Before(time.Unix(0, key.record.ActivatedAt).Add(ks.rotation)) {
+		if _, err := ks.Rotate(ctx); err != nil {
+			return err
+		}
+	}
+
+	ks.mu.RLock()
+	var expired []*storedKey
+	for _, key := range ks.keys {
+		if !ks.verifies(key, now) {
+			expired = append(expired, key)
+		}
+	}
+	ks.mu.RUnlock()
+
+	for _, key := range expired {
+		if err := ks.store.DeleteSigningKeyByID(ctx, key.record.ID); err != nil &&
+			!errors.Is(err, datastore.ErrorSigningKeyNotExist) {
+			return fmt.Errorf("fail to delete signing key %s, %w", key.record.KeyID, err)
+		}
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+	return ks.load(ctx)
+}
+
+// Run maintains the keys right away, then every maintainInterval until
+// ctx is done
+func (ks *KeyStore) Run(ctx context.Context) {
+	ticker := time.NewTicker(maintainInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := ks.Maintain(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("fail to maintain signing keys: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
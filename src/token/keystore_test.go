@@ -0,0 +1,293 @@
+package token
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hanzezhenalex/auth/src"
+	"github.com/hanzezhenalex/auth/src/datastore"
+	"github.com/hanzezhenalex/auth/src/datastore/memory"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newMasterKey(t *testing.T) string {
+	raw := make([]byte, masterKeyLength)
+	_, err := rand.Read(raw)
+	require.NoError(t, err)
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+func newKeyStore(t *testing.T, store datastore.Datastore, cfg src.KeyConfig, now *time.Time) *KeyStore {
+	ks, err := NewKeyStore(store, cfg, src.NewTokenConfig())
+	require.NoError(t, err)
+	ks.now = func() time.Time { return *now }
+	return ks
+}
+
+// mint signs a token of the signer of ks, valid for two days
+func mint(t *testing.T, ks *KeyStore, now time.Time) string {
+	signer, err := ks.Signer(context.Background())
+	require.NoError(t, err)
+	token, err := Sign(signer, &Claims{Issuer: "test", ExpiresAt: now.Add(48 * time.Hour).Unix()})
+	require.NoError(t, err)
+	return token
+}
+
+// hookedDatastore runs afterCreateKey once a signing key is created
+type hookedDatastore struct {
+	datastore.Datastore
+	afterCreateKey func()
+}
+
+func (store *hookedDatastore) CreateSigningKey(ctx context.Context, key *datastore.SigningKey) error {
+	if err := store.Datastore.CreateSigningKey(ctx, key); err != nil {
+		return err
+	}
+	if store.afterCreateKey != nil {
+		store.afterCreateKey()
+	}
+	return nil
+}
+
+func TestJWK(t *testing.T) {
+	pairs := newKeyPairs(t)
+
+	for _, alg := range []string{AlgorithmRS256, AlgorithmES256} {
+		pair := pairs[alg]
+		t.Run(alg, func(t *testing.T) {
+			rq := require.New(t)
+			jwk, err := NewJWK("kid1", pair.key)
+			rq.NoError(err)
+
+			raw, err := json.Marshal(&JWKSet{Keys: []JWK{jwk}})
+			rq.NoError(err)
+			var set JWKSet
+			rq.NoError(json.Unmarshal(raw, &set))
+
+			token, err := Sign(WithKeyID(pair.signer, "kid1"), &Claims{Issuer: "test", ExpiresAt: time.Now().Add(time.Minute).Unix()})
+			rq.NoError(err)
+			_, err = NewVerifier(&set, src.TokenConfig{Issuer: "test"}).Verify(context.Background(), token)
+			rq.NoError(err)
+
+			_, err = set.VerifyKey(context.Background(), "kid2")
+			rq.ErrorIs(err, ErrorUnknownKey)
+		})
+	}
+
+	t.Run("secrets are never published", func(t *testing.T) {
+		_, err := NewJWK("kid1", pairs[AlgorithmHS256].key)
+		require.ErrorIs(t, err, ErrorUnsupportedKey)
+	})
+
+	t.Run("point off the curve", func(t *testing.T) {
+		rq := require.New(t)
+		jwk, err := NewJWK("kid1", pairs[AlgorithmES256].key)
+		rq.NoError(err)
+		jwk.Y = jwk.X
+		_, err = jwk.VerifyKey()
+		rq.ErrorIs(err, ErrorUnsupportedKey)
+	})
+}
+
+func TestCheckKeyConfig(t *testing.T) {
+	rq := require.New(t)
+	tokenCfg := src.NewTokenConfig()
+	rq.NoError(CheckKeyConfig(src.NewKeyConfig(), tokenCfg))
+
+	for _, weaken := range []func(cfg *src.KeyConfig){
+		func(cfg *src.KeyConfig) { cfg.RotationPeriod = 0 },
+		func(cfg *src.KeyConfig) { cfg.RotationPeriod = -1 },
+		func(cfg *src.KeyConfig) { cfg.GracePeriod = 0 },
+		func(cfg *src.KeyConfig) { cfg.GracePeriod = tokenCfg.TTL + tokenCfg.Leeway - 1 },
+	} {
+		cfg := src.NewKeyConfig()
+		weaken(&cfg)
+		rq.ErrorIs(CheckKeyConfig(cfg, tokenCfg), ErrorInvalidKeyConfig)
+	}
+
+	// just long enough
+	cfg := src.NewKeyConfig()
+	cfg.GracePeriod = tokenCfg.TTL + tokenCfg.Leeway
+	rq.NoError(CheckKeyConfig(cfg, tokenCfg))
+}
+
+func TestKeyStore(t *testing.T) {
+	ctx := context.Background()
+	tokenCfg := src.TokenConfig{Issuer: "test"}
+	keyCfg := src.KeyConfig{
+		MasterKey:      newMasterKey(t),
+		Algorithm:      AlgorithmES256,
+		RotationPeriod: int((24 * time.Hour).Seconds()),
+		GracePeriod:    int(time.Hour.Seconds()),
+	}
+
+	t.Run("invalid config", func(t *testing.T) {
+		rq := require.New(t)
+		store := memory.NewMemoryDatastore()
+
+		_, err := NewKeyStore(store, src.KeyConfig{MasterKey: "short", Algorithm: AlgorithmES256}, tokenCfg)
+		rq.ErrorIs(err, ErrorInvalidMasterKey)
+		_, err = NewKeyStore(store, src.KeyConfig{MasterKey: keyCfg.MasterKey, Algorithm: AlgorithmHS256}, tokenCfg)
+		rq.ErrorIs(err, ErrorUnsupportedKey)
+		_, err = NewKeyStore(store, src.KeyConfig{MasterKey: keyCfg.MasterKey, Algorithm: AlgorithmES256}, tokenCfg)
+		rq.ErrorIs(err, ErrorInvalidKeyConfig)
+	})
+
+	t.Run("no key", func(t *testing.T) {
+		rq := require.New(t)
+		now := time.Now()
+		ks := newKeyStore(t, memory.NewMemoryDatastore(), keyCfg, &now)
+
+		_, err := ks.Signer(ctx)
+		rq.ErrorIs(err, ErrorNoSigningKey)
+		set, err := ks.JWKS(ctx)
+		rq.NoError(err)
+		rq.Empty(set.Keys)
+	})
+
+	t.Run("private keys are sealed", func(t *testing.T) {
+		rq := require.New(t)
+		store := memory.NewMemoryDatastore()
+		now := time.Now()
+		ks := newKeyStore(t, store, keyCfg, &now)
+
+		record, err := ks.Rotate(ctx)
+		rq.NoError(err)
+		der, err := ks.open(record.KeyID, record.PrivateKey)
+		rq.NoError(err)
+		rq.NotContains(record.PrivateKey, base64.StdEncoding.EncodeToString(der))
+
+		// another kid does not open it
+		_, err = ks.open("other", record.PrivateKey)
+		rq.Error(err)
+
+		// nor another master key
+		cfg := keyCfg
+		cfg.MasterKey = newMasterKey(t)
+		_, err = newKeyStore(t, store, cfg, &now).Signer(ctx)
+		rq.Error(err)
+
+		events, _, err := store.ListAuditEvents(ctx, datastore.AuditListOption{Actor: keyStoreActor})
+		rq.NoError(err)
+		rq.NotEmpty(events)
+		for _, event := range events {
+			rq.Equal(datastore.AuditTargetSigningKey, event.TargetType)
+			rq.NotContains(event.After, record.PrivateKey)
+		}
+	})
+
+	t.Run("rotation", func(t *testing.T) {
+		rq := require.New(t)
+		store := memory.NewMemoryDatastore()
+		now := time.Now()
+		ks := newKeyStore(t, store, keyCfg, &now)
+		// another instance sharing the datastore
+		peer := newKeyStore(t, store, keyCfg, &now)
+
+		rq.NoError(ks.Maintain(ctx))
+		first, err := ks.Signer(ctx)
+		rq.NoError(err)
+		token1 := mint(t, ks, now)
+
+		// not due yet
+		now = now.Add(23 * time.Hour)
+		rq.NoError(ks.Maintain(ctx))
+		signer, err := ks.Signer(ctx)
+		rq.NoError(err)
+		rq.Equal(first, signer)
+
+		now = now.Add(time.Hour)
+		rq.NoError(ks.Maintain(ctx))
+		second, err := ks.Signer(ctx)
+		rq.NoError(err)
+		rq.NotEqual(first.(keyIdentified).KeyID(), second.(keyIdentified).KeyID())
+		token2 := mint(t, ks, now)
+
+		// both verify within the grace period, the peer learns the new kid
+		verifier := NewVerifier(peer, tokenCfg)
+		verifier.now = func() time.Time { return now }
+		for _, token := range []string{token1, token2} {
+			_, err = verifier.Verify(ctx, token)
+			rq.NoError(err)
+		}
+		set, err := ks.JWKS(ctx)
+		rq.NoError(err)
+		rq.Len(set.Keys, 2)
+
+		// past it, the retired key is deleted
+		now = now.Add(time.Hour)
+		rq.NoError(ks.Maintain(ctx))
+		keys, err := store.ListSigningKeys(ctx)
+		rq.NoError(err)
+		rq.Len(keys, 1)
+		rq.Equal(second.(keyIdentified).KeyID(), keys[0].KeyID)
+
+		set, err = ks.JWKS(ctx)
+		rq.NoError(err)
+		rq.Len(set.Keys, 1)
+		_, err = NewVerifier(set, tokenCfg).Verify(ctx, token1)
+		rq.ErrorIs(err, ErrorUnknownKey)
+		_, err = NewVerifier(ks, tokenCfg).Verify(ctx, token1)
+		rq.ErrorIs(err, ErrorUnknownKey)
+	})
+
+	t.Run("concurrent rotations", func(t *testing.T) {
+		rq := require.New(t)
+		store := memory.NewMemoryDatastore()
+		now := time.Now()
+		hooked := &hookedDatastore{Datastore: store}
+		ks := newKeyStore(t, hooked, keyCfg, &now)
+		peer := newKeyStore(t, store, keyCfg, &now)
+
+		// the peer rotates between the key of ks created and the others retired
+		var newer *datastore.SigningKey
+		hooked.afterCreateKey = func() {
+			hooked.afterCreateKey = nil
+			var err error
+			newer, err = peer.Rotate(ctx)
+			rq.NoError(err)
+		}
+		older, err := ks.Rotate(ctx)
+		rq.NoError(err)
+		rq.NotNil(newer)
+		rq.Less(older.ID, newer.ID)
+
+		keys, err := store.ListSigningKeys(ctx)
+		rq.NoError(err)
+		rq.Len(keys, 2)
+		for _, key := range keys {
+			if key.ID == newer.ID {
+				rq.Zero(key.RetiredAt)
+			} else {
+				rq.NotZero(key.RetiredAt)
+			}
+		}
+
+		for _, instance := range []*KeyStore{ks, peer} {
+			rq.NoError(instance.load(ctx))
+			signer, err := instance.Signer(ctx)
+			rq.NoError(err)
+			rq.Equal(newer.KeyID, signer.(keyIdentified).KeyID())
+		}
+	})
+
+	t.Run("kid in the header", func(t *testing.T) {
+		rq := require.New(t)
+		now := time.Now()
+		ks := newKeyStore(t, memory.NewMemoryDatastore(), keyCfg, &now)
+		record, err := ks.Rotate(ctx)
+		rq.NoError(err)
+
+		token := mint(t, ks, now)
+		var h header
+		rq.NoError(decodePart(strings.Split(token, ".")[0], &h))
+		rq.Equal(record.KeyID, h.KeyID)
+		rq.Equal(AlgorithmES256, h.Algorithm)
+	})
+}
@@ -0,0 +1,176 @@
+package token
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hanzezhenalex/auth/src"
+	"github.com/hanzezhenalex/auth/src/datastore"
+)
+
+/*
+	Refresh tokens
+
+	A refresh token is opaque, refreshTokenLength bytes from crypto/rand,
+	and only its sha256 is stored. Every use returns an access token and
+	the next refresh token of the family, the used one is dead. A used
+	token coming back means one of its holders stole it, so its whole
+	family is revoked and both have to log in again. The access tokens
+	minted already stay valid until they expire.
+*/
+
+const refreshTokenLength = 32
+
+var (
+	ErrorInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrorRefreshTokenReused  = errors.New("refresh token reused")
+)
+
+// Pair is what a client holds after a login or a refresh
+type Pair struct {
+	AccessToken  string
+	Claims       *Claims
+	RefreshToken string
+	Refresh      *datastore.RefreshToken
+}
+
+// Refresher hands out the refresh tokens, and renews the access tokens of
+// an Issuer with them
+type Refresher struct {
+	store  datastore.Datastore
+	issuer *Issuer
+	ttl    time.Duration
+	now    func() time.Time
+}
+
+func NewRefresher(store datastore.Datastore, issuer *Issuer, cfg src.TokenConfig) *Refresher {
+	return &Refresher{
+		store:  store,
+		issuer: issuer,
+		ttl:    time.Duration(cfg.RefreshTTL) * time.Second,
+		now:    time.Now,
+	}
+}
+
+func newRefreshToken() (string, error) {
+	raw := make([]byte, refreshTokenLength)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("fail to generate refresh token, %w", err)
+	}
+	return encoding.EncodeToString(raw), nil
+}
+
+// HashRefreshToken returns what is stored for token
+func HashRefreshToken(token string) string {
+	return hex.EncodeToString(digest([]byte(token)))
+}
+
+// withUserActor makes the user the actor, unless ctx names one
+func (r *Refresher) withUserActor(ctx context.Context, userID int64) (context.Context, error) {
+	if datastore.ActorFromContext(ctx) != "" {
+		return ctx, nil
+	}
+	user, err := r.store.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return datastore.WithActor(ctx, user.Username), nil
+}
+
+// Issue mints an access token for the user and starts a family of refresh
+// tokens, once the user has logged in. Unless ctx names an actor, the user
+// is the actor.
+func (r *Refresher) Issue(ctx context.Context, userID int64) (*Pair, error) {
+	ctx, err := r.withUserActor(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	access, claims, err := r.issuer.Issue(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	refresh := &datastore.RefreshToken{
+		UserID:    userID,
+		TokenHash: HashRefreshToken(plain),
+		ExpiresAt: r.now().Add(r.ttl).UnixNano(),
+	}
+	if err := r.store.CreateRefreshToken(ctx, refresh); err != nil {
+		return nil, fmt.Errorf("fail to create refresh token, %w", err)
+	}
+	return &Pair{AccessToken: access, Claims: claims, RefreshToken: plain, Refresh: refresh}, nil
+}
+
+// Refresh trades a refresh token for a new pair. Replaying a used token is
+// ErrorRefreshTokenReused, and revokes its family. Unless ctx names an
+// actor, the owner of the token is the actor.
+func (r *Refresher) Refresh(ctx context.Context, token string) (*Pair, error) {
+	// step 1: find the token, alive
+	stored, err := r.store.GetRefreshTokenByTokenHash(ctx, HashRefreshToken(token))
+	if errors.Is(err, datastore.ErrorRefreshTokenNotExist) {
+		return nil, ErrorInvalidRefreshToken
+	} else if err != nil {
+		return nil, err
+	}
+
+	now := r.now()
+	if now.UnixNano() >= stored.ExpiresAt {
+		return nil, ErrorInvalidRefreshToken
+	}
+
+	ctx, err = r.withUserActor(ctx, stored.UserID)
+	if errors.Is(err, datastore.ErrorUserNotExist) {
+		return nil, ErrorInvalidRefreshToken
+	} else if err != nil {
+		return nil, err
+	}
+
+	// step 2: a token used already is a replay
+	if stored.UsedAt != 0 {
+		return nil, r.revokeFamily(ctx, stored)
+	}
+
+	// step 3: mint the access token first, a failure leaves the token usable
+	access, claims, err := r.issuer.Issue(ctx, stored.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	// step 4: rotate, a concurrent use of the same token is a replay too
+	plain, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	next := &datastore.RefreshToken{
+		TokenHash: HashRefreshToken(plain),
+		ExpiresAt: now.Add(r.ttl).UnixNano(),
+	}
+	switch err := r.store.RotateRefreshToken(ctx, stored.ID, now, next); {
+	case errors.Is(err, datastore.ErrorRefreshTokenUsed):
+		return nil, r.revokeFamily(ctx, stored)
+	case errors.Is(err, datastore.ErrorRefreshTokenNotExist), errors.Is(err, datastore.ErrorUserNotExist):
+		// revoked in between
+		return nil, ErrorInvalidRefreshToken
+	case err != nil:
+		return nil, fmt.Errorf("fail to rotate refresh token, %w", err)
+	}
+	return &Pair{AccessToken: access, Claims: claims, RefreshToken: plain, Refresh: next}, nil
+}
+
+// revokeFamily revokes the family of a replayed token, and returns the
+// error of the replay
+func (r *Refresher) revokeFamily(ctx context.Context, replayed *datastore.RefreshToken) error {
+	if _, err := r.store.RevokeRefreshTokenFamily(ctx, replayed.FamilyID); err != nil {
+		return fmt.Errorf("fail to revoke refresh token family %d, %w", replayed.FamilyID, err)
+	}
+	return ErrorRefreshTokenReused
+}
@@ -0,0 +1,116 @@
+package token
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hanzezhenalex/auth/src"
+	"github.com/hanzezhenalex/auth/src/datastore"
+	"github.com/hanzezhenalex/auth/src/datastore/memory"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefresher(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewMemoryDatastore()
+	user := &datastore.User{Username: "user1", Password: "pwd"}
+	require.NoError(t, store.CreateUser(ctx, user))
+
+	key := newKeyPairs(t)[AlgorithmES256]
+	cfg := src.TokenConfig{Issuer: "auth", TTL: 900, RefreshTTL: 3600}
+	now := time.Now()
+	r := NewRefresher(store, NewIssuer(store, StaticSigner(key.signer), cfg), cfg)
+	r.now = func() time.Time { return now }
+	verifier := NewVerifier(StaticKey(key.key), cfg)
+
+	t.Run("rotated on every use", func(t *testing.T) {
+		rq := require.New(t)
+		pair, err := r.Issue(ctx, user.ID)
+		rq.NoError(err)
+		_, err = verifier.Verify(ctx, pair.AccessToken)
+		rq.NoError(err)
+		rq.NotEqual(pair.RefreshToken, pair.Refresh.TokenHash)
+
+		next, err := r.Refresh(ctx, pair.RefreshToken)
+		rq.NoError(err)
+		rq.NotEqual(pair.RefreshToken, next.RefreshToken)
+		rq.Equal(pair.Refresh.FamilyID, next.Refresh.FamilyID)
+		claims, err := verifier.Verify(ctx, next.AccessToken)
+		rq.NoError(err)
+		rq.Equal(claims, next.Claims)
+
+		last, err := r.Refresh(ctx, next.RefreshToken)
+		rq.NoError(err)
+		rq.Equal(pair.Refresh.FamilyID, last.Refresh.FamilyID)
+
+		events, _, err := store.ListAuditEvents(ctx, datastore.AuditListOption{Actor: user.Username})
+		rq.NoError(err)
+		rq.NotEmpty(events)
+	})
+
+	t.Run("reuse revokes the family", func(t *testing.T) {
+		rq := require.New(t)
+		pair, err := r.Issue(ctx, user.ID)
+		rq.NoError(err)
+		other, err := r.Issue(ctx, user.ID)
+		rq.NoError(err)
+		next, err := r.Refresh(ctx, pair.RefreshToken)
+		rq.NoError(err)
+
+		_, err = r.Refresh(ctx, pair.RefreshToken)
+		rq.ErrorIs(err, ErrorRefreshTokenReused)
+		// the thief and the owner are both logged out
+		_, err = r.Refresh(ctx, next.RefreshToken)
+		rq.ErrorIs(err, ErrorInvalidRefreshToken)
+		_, err = r.Refresh(ctx, pair.RefreshToken)
+		rq.ErrorIs(err, ErrorInvalidRefreshToken)
+
+		// other families are left alone
+		_, err = r.Refresh(ctx, other.RefreshToken)
+		rq.NoError(err)
+	})
+
+	t.Run("invalid tokens", func(t *testing.T) {
+		rq := require.New(t)
+		_, err := r.Refresh(ctx, "unknown")
+		rq.ErrorIs(err, ErrorInvalidRefreshToken)
+		_, err = r.Refresh(ctx, "")
+		rq.ErrorIs(err, ErrorInvalidRefreshToken)
+
+		pair, err := r.Issue(ctx, user.ID)
+		rq.NoError(err)
+		later := NewRefresher(store, r.issuer, cfg)
+		later.now = func() time.Time { return now.Add(time.Hour) }
+		_, err = later.Refresh(ctx, pair.RefreshToken)
+		rq.ErrorIs(err, ErrorInvalidRefreshToken)
+
+		_, err = r.Issue(ctx, 99999)
+		rq.ErrorIs(err, datastore.ErrorUserNotExist)
+	})
+
+	t.Run("revoked with the user", func(t *testing.T) {
+		rq := require.New(t)
+		owner := &datastore.User{Username: "user2", Password: "pwd"}
+		rq.NoError(store.CreateUser(ctx, owner))
+		first, err := r.Issue(ctx, owner.ID)
+		rq.NoError(err)
+		second, err := r.Issue(ctx, owner.ID)
+		rq.NoError(err)
+
+		n, err := store.RevokeUserRefreshTokens(datastore.WithActor(ctx, "admin"), owner.ID)
+		rq.NoError(err)
+		rq.Equal(int64(2), n)
+		for _, pair := range []*Pair{first, second} {
+			_, err = r.Refresh(ctx, pair.RefreshToken)
+			rq.ErrorIs(err, ErrorInvalidRefreshToken)
+		}
+
+		third, err := r.Issue(ctx, owner.ID)
+		rq.NoError(err)
+		rq.NoError(store.DeleteUserByID(datastore.WithActor(ctx, "admin"), owner.ID))
+		_, err = r.Refresh(ctx, third.RefreshToken)
+		rq.ErrorIs(err, ErrorInvalidRefreshToken)
+	})
+}
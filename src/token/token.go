@@ -0,0 +1,367 @@
+// Package token mints and verifies JWT access tokens. A token carries the
+// effective scopes and authorities of its subject, so a service holding a
+// verifier checks permissions without calling the datastore.
+package token
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hanzezhenalex/auth/src"
+	"github.com/hanzezhenalex/auth/src/authz"
+	"github.com/hanzezhenalex/auth/src/datastore"
+)
+
+const (
+	typeJWT = "JWT"
+
+	jtiLength = 16
+)
+
+var encoding = base64.RawURLEncoding
+
+var (
+	ErrorMalformedToken     = errors.New("malformed token")
+	ErrorAlgorithmMismatch  = errors.New("unexpected token algorithm")
+	ErrorInvalidSignature   = errors.New("invalid token signature")
+	ErrorTokenExpired       = errors.New("token expired")
+	ErrorTokenNotYetValid   = errors.New("token not yet valid")
+	ErrorInvalidIssuer      = errors.New("invalid token issuer")
+	ErrorInvalidAudience    = errors.New("invalid token audience")
+	ErrorMissingTokenExpiry = errors.New("token without expiry")
+	ErrorUnknownKey         = errors.New("unknown signing key")
+)
+
+/*
+	Claims
+*/
+
+// Audience is a single string or a list in json, RFC 7519 4.1.3
+type Audience []string
+
+func (aud Audience) MarshalJSON() ([]byte, error) {
+	if len(aud) == 1 {
+		return json.Marshal(aud[0])
+	}
+	return json.Marshal([]string(aud))
+}
+
+func (aud *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*aud = Audience{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("unable to unmarshal audience, err=%s", err)
+	}
+	*aud = list
+	return nil
+}
+
+// Claims of an access token, times are in seconds since epoch. Scopes and
+// Auths are the merged permission of the subject when the token was minted.
+type Claims struct {
+	Issuer    string   `json:"iss,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	ID        string   `json:"jti,omitempty"`
+
+	Scopes []string `json:"scopes,omitempty"`
+	Auths  []string `json:"auths,omitempty"`
+}
+
+// UserID returns the subject as a user id
+func (c *Claims) UserID() (int64, error) {
+	id, err := strconv.ParseInt(c.Subject, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w, subject %q", ErrorMalformedToken, c.Subject)
+	}
+	return id, nil
+}
+
+// Can answers whether the scopes of the token grant scope, with the
+// wildcards of authz.Match
+func (c *Claims) Can(scope string) bool {
+	if scope == "" {
+		return false
+	}
+	for _, granted := range c.Scopes {
+		if authz.Match(granted, scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAuth answers whether the token carries the authority
+func (c *Claims) HasAuth(name string) bool {
+	for _, auth := range c.Auths {
+		if auth == name {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+	Encoding
+
+	header.claims.signature, each part in unpadded url-safe base64
+*/
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+}
+
+// Sign encodes claims into a signed token, with the kid of a signer from
+// WithKeyID in the header
+func Sign(signer Signer, claims *Claims) (string, error) {
+	h := header{Algorithm: signer.Algorithm(), Type: typeJWT}
+	if keyed, ok := signer.(keyIdentified); ok {
+		h.KeyID = keyed.KeyID()
+	}
+
+	rawHeader, err := json.Marshal(h)
+	if err != nil {
+		return "", err
+	}
+	rawClaims, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("fail to encode claims, %w", err)
+	}
+
+	signingInput := encoding.EncodeToString(rawHeader) + "." + encoding.EncodeToString(rawClaims)
+	signature, err := signer.Sign([]byte(signingInput))
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + encoding.EncodeToString(signature), nil
+}
+
+// parse checks the signature of token with the key named by its kid and
+// returns its claims, the claims themselves are not validated
+func parse(ctx context.Context, keys KeySet, token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrorMalformedToken
+	}
+
+	var h header
+	if err := decodePart(parts[0], &h); err != nil {
+		return nil, err
+	}
+	key, err := keys.VerifyKey(ctx, h.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	// the algorithm is the key's, never the one the token asks for
+	if h.Algorithm != key.Algorithm() {
+		return nil, fmt.Errorf("%w, %q", ErrorAlgorithmMismatch, h.Algorithm)
+	}
+
+	signature, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrorMalformedToken
+	}
+	if err := key.Verify([]byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := decodePart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
+
+func decodePart(part string, v interface{}) error {
+	raw, err := encoding.DecodeString(part)
+	if err != nil {
+		return ErrorMalformedToken
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("%w, %s", ErrorMalformedToken, err)
+	}
+	return nil
+}
+
+func newJTI() (string, error) {
+	raw := make([]byte, jtiLength)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("fail to generate token id, %w", err)
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+/*
+	Keys
+
+	The issuer asks a SignerSource for the signer of every token, and the
+	verifier asks a KeySet for the key named by the kid of every token, so
+	both follow the rotation of a KeyStore.
+*/
+
+// SignerSource returns the signer of the next token
+type SignerSource interface {
+	Signer(ctx context.Context) (Signer, error)
+}
+
+// KeySet returns the key verifying the tokens of a kid, an unknown kid is
+// ErrorUnknownKey
+type KeySet interface {
+	VerifyKey(ctx context.Context, kid string) (VerifyKey, error)
+}
+
+type staticSigner struct {
+	signer Signer
+}
+
+// StaticSigner signs every token with signer
+func StaticSigner(signer Signer) SignerSource {
+	return staticSigner{signer: signer}
+}
+
+func (s staticSigner) Signer(context.Context) (Signer, error) {
+	return s.signer, nil
+}
+
+type staticKey struct {
+	key VerifyKey
+}
+
+// StaticKey verifies every token with key, whatever its kid
+func StaticKey(key VerifyKey) KeySet {
+	return staticKey{key: key}
+}
+
+func (s staticKey) VerifyKey(context.Context, string) (VerifyKey, error) {
+	return s.key, nil
+}
+
+/*
+	Issuer
+*/
+
+// Issuer mints the access tokens of users
+type Issuer struct {
+	store   datastore.Datastore
+	signers SignerSource
+	cfg     src.TokenConfig
+	now     func() time.Time
+}
+
+func NewIssuer(store datastore.Datastore, signers SignerSource, cfg src.TokenConfig) *Issuer {
+	return &Issuer{store: store, signers: signers, cfg: cfg, now: time.Now}
+}
+
+// Issue mints a token for the user, with the scopes and authorities of
+// its active roles
+func (i *Issuer) Issue(ctx context.Context, userID int64) (string, *Claims, error) {
+	perm, err := i.store.GetUserPermissions(ctx, userID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	jti, err := newJTI()
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := i.now()
+	claims := &Claims{
+		Issuer:    i.cfg.Issuer,
+		Subject:   strconv.FormatInt(userID, 10),
+		Audience:  i.cfg.Audience,
+		ExpiresAt: now.Add(time.Duration(i.cfg.TTL) * time.Second).Unix(),
+		NotBefore: now.Unix(),
+		IssuedAt:  now.Unix(),
+		ID:        jti,
+		Scopes:    perm.Scopes,
+		Auths:     perm.Auths,
+	}
+
+	signer, err := i.signers.Signer(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	token, err := Sign(signer, claims)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, claims, nil
+}
+
+/*
+	Verifier
+*/
+
+// Verifier checks the tokens of an issuer. The issuer must be the one of
+// the config, and, when the config names audiences, the token must be
+// meant for one of them.
+type Verifier struct {
+	keys KeySet
+	cfg  src.TokenConfig
+	now  func() time.Time
+}
+
+func NewVerifier(keys KeySet, cfg src.TokenConfig) *Verifier {
+	return &Verifier{keys: keys, cfg: cfg, now: time.Now}
+}
+
+// Verify returns the claims of a token with a valid signature, within its
+// validity period give or take the leeway
+func (v *Verifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	claims, err := parse(ctx, v.keys, token)
+	if err != nil {
+		return nil, err
+	}
+
+	now := v.now().Unix()
+	leeway := int64(v.cfg.Leeway)
+	switch {
+	case claims.ExpiresAt == 0:
+		return nil, ErrorMissingTokenExpiry
+	case now >= claims.ExpiresAt+leeway:
+		return nil, ErrorTokenExpired
+	case claims.NotBefore != 0 && now < claims.NotBefore-leeway,
+		claims.IssuedAt != 0 && now < claims.IssuedAt-leeway:
+		return nil, ErrorTokenNotYetValid
+	}
+
+	if claims.Issuer != v.cfg.Issuer {
+		return nil, fmt.Errorf("%w, %q", ErrorInvalidIssuer, claims.Issuer)
+	}
+	if len(v.cfg.Audience) > 0 && !intersect(claims.Audience, v.cfg.Audience) {
+		return nil, ErrorInvalidAudience
+	}
+	return claims, nil
+}
+
+func intersect(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
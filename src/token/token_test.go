@@ -0,0 +1,239 @@
+package token
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hanzezhenalex/auth/src"
+	"github.com/hanzezhenalex/auth/src/datastore"
+	"github.com/hanzezhenalex/auth/src/datastore/memory"
+
+	"github.com/stretchr/testify/require"
+)
+
+type keyPair struct {
+	signer Signer
+	key    VerifyKey
+}
+
+func newKeyPairs(t *testing.T) map[string]keyPair {
+	rq := require.New(t)
+
+	secret := make([]byte, minHMACKeyLength)
+	_, err := rand.Read(secret)
+	rq.NoError(err)
+	hmacKey, err := NewHS256(secret)
+	rq.NoError(err)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, minRSAKeyBits)
+	rq.NoError(err)
+	rsaSigner, err := NewRS256Signer(rsaKey)
+	rq.NoError(err)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rq.NoError(err)
+	ecSigner, err := NewES256Signer(ecKey)
+	rq.NoError(err)
+
+	return map[string]keyPair{
+		AlgorithmHS256: {hmacKey, hmacKey},
+		AlgorithmRS256: {rsaSigner, rsaSigner.Public()},
+		AlgorithmES256: {ecSigner, ecSigner.Public()},
+	}
+}
+
+func TestAlgorithms(t *testing.T) {
+	ctx := context.Background()
+	pairs := newKeyPairs(t)
+	cfg := src.TokenConfig{Issuer: "test", Leeway: 0}
+	now := time.Now()
+	claims := &Claims{
+		Issuer:    "test",
+		Subject:   "1",
+		ExpiresAt: now.Add(time.Minute).Unix(),
+		Scopes:    []string{"orders:read"},
+	}
+
+	for alg, pair := range pairs {
+		alg, pair := alg, pair
+		t.Run(alg, func(t *testing.T) {
+			rq := require.New(t)
+			token, err := Sign(pair.signer, claims)
+			rq.NoError(err)
+
+			actual, err := NewVerifier(StaticKey(pair.key), cfg).Verify(ctx, token)
+			rq.NoError(err)
+			rq.Equal(claims, actual)
+
+			// tampered claims
+			parts := strings.Split(token, ".")
+			forged, _ := json.Marshal(&Claims{Issuer: "test", Subject: "2", ExpiresAt: claims.ExpiresAt})
+			_, err = NewVerifier(StaticKey(pair.key), cfg).Verify(ctx, parts[0]+"."+encoding.EncodeToString(forged)+"."+parts[2])
+			rq.ErrorIs(err, ErrorInvalidSignature)
+
+			// signed by another key of the same algorithm
+			other := newKeyPairs(t)[alg]
+			_, err = NewVerifier(StaticKey(other.key), cfg).Verify(ctx, token)
+			rq.ErrorIs(err, ErrorInvalidSignature)
+
+			_, err = NewVerifier(StaticKey(pair.key), cfg).Verify(ctx, parts[0]+"."+parts[1])
+			rq.ErrorIs(err, ErrorMalformedToken)
+		})
+	}
+
+	t.Run("algorithm is the key's", func(t *testing.T) {
+		rq := require.New(t)
+		// an unsigned token
+		rawHeader, _ := json.Marshal(header{Algorithm: "none"})
+		rawClaims, _ := json.Marshal(claims)
+		unsigned := encoding.EncodeToString(rawHeader) + "." + encoding.EncodeToString(rawClaims) + "."
+		_, err := NewVerifier(StaticKey(pairs[AlgorithmHS256].key), cfg).Verify(ctx, unsigned)
+		rq.ErrorIs(err, ErrorAlgorithmMismatch)
+
+		// signed by an RS256 key, verified by an ES256 one
+		token, err := Sign(pairs[AlgorithmRS256].signer, claims)
+		rq.NoError(err)
+		_, err = NewVerifier(StaticKey(pairs[AlgorithmES256].key), cfg).Verify(ctx, token)
+		rq.ErrorIs(err, ErrorAlgorithmMismatch)
+	})
+
+	t.Run("weak keys", func(t *testing.T) {
+		rq := require.New(t)
+		_, err := NewHS256([]byte("short"))
+		rq.ErrorIs(err, ErrorWeakKey)
+
+		ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		rq.NoError(err)
+		_, err = NewES256Signer(ecKey)
+		rq.ErrorIs(err, ErrorWeakKey)
+	})
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	key := newKeyPairs(t)[AlgorithmHS256]
+	cfg := src.TokenConfig{Issuer: "test", Audience: []string{"orders", "payments"}, Leeway: 30}
+	now := time.Now()
+	verify := func(claims *Claims, at time.Time) (*Claims, error) {
+		token, err := Sign(key.signer, claims)
+		require.NoError(t, err)
+		v := NewVerifier(StaticKey(key.key), cfg)
+		v.now = func() time.Time { return at }
+		return v.Verify(ctx, token)
+	}
+	valid := func() *Claims {
+		return &Claims{
+			Issuer:    "test",
+			Audience:  Audience{"orders"},
+			ExpiresAt: now.Add(time.Minute).Unix(),
+			NotBefore: now.Unix(),
+			IssuedAt:  now.Unix(),
+		}
+	}
+
+	t.Run("expiry with leeway", func(t *testing.T) {
+		rq := require.New(t)
+		_, err := verify(valid(), now.Add(time.Minute+20*time.Second))
+		rq.NoError(err)
+		_, err = verify(valid(), now.Add(time.Minute+30*time.Second))
+		rq.ErrorIs(err, ErrorTokenExpired)
+
+		claims := valid()
+		claims.ExpiresAt = 0
+		_, err = verify(claims, now)
+		rq.ErrorIs(err, ErrorMissingTokenExpiry)
+	})
+
+	t.Run("not before with leeway", func(t *testing.T) {
+		rq := require.New(t)
+		_, err := verify(valid(), now.Add(-20*time.Second))
+		rq.NoError(err)
+		_, err = verify(valid(), now.Add(-31*time.Second))
+		rq.ErrorIs(err, ErrorTokenNotYetValid)
+	})
+
+	t.Run("issuer", func(t *testing.T) {
+		rq := require.New(t)
+		claims := valid()
+		claims.Issuer = "other"
+		_, err := verify(claims, now)
+		rq.ErrorIs(err, ErrorInvalidIssuer)
+	})
+
+	t.Run("audience", func(t *testing.T) {
+		rq := require.New(t)
+		claims := valid()
+		claims.Audience = Audience{"billing", "payments"}
+		_, err := verify(claims, now)
+		rq.NoError(err)
+
+		claims.Audience = Audience{"billing"}
+		_, err = verify(claims, now)
+		rq.ErrorIs(err, ErrorInvalidAudience)
+
+		claims.Audience = nil
+		_, err = verify(claims, now)
+		rq.ErrorIs(err, ErrorInvalidAudience)
+	})
+
+	t.Run("audience in json", func(t *testing.T) {
+		rq := require.New(t)
+		raw, err := json.Marshal(Audience{"orders"})
+		rq.NoError(err)
+		rq.Equal(`"orders"`, string(raw))
+
+		var aud Audience
+		rq.NoError(json.Unmarshal([]byte(`["orders","payments"]`), &aud))
+		rq.Equal(Audience{"orders", "payments"}, aud)
+		rq.NoError(json.Unmarshal([]byte(`"orders"`), &aud))
+		rq.Equal(Audience{"orders"}, aud)
+	})
+}
+
+func TestIssue(t *testing.T) {
+	rq := require.New(t)
+	ctx := context.Background()
+	store := memory.NewMemoryDatastore()
+
+	rq.NoError(store.CreateAuthority(ctx, &datastore.Authority{AuthName: "auth1"}))
+	rq.NoError(store.CreateRole(ctx, &datastore.Role{
+		RoleName: "reader",
+		Scopes:   []string{"orders:read", "payments:*"},
+		Auths:    []string{"auth1"},
+	}))
+	rq.NoError(store.CreateRole(ctx, &datastore.Role{RoleName: "writer", Scopes: []string{"orders:write"}}))
+	user := &datastore.User{Username: "user1", Password: "pwd"}
+	rq.NoError(store.CreateUser(ctx, user))
+	rq.NoError(store.AssignRoles(ctx, user.ID, []string{"reader", "writer"}))
+
+	key := newKeyPairs(t)[AlgorithmES256]
+	cfg := src.TokenConfig{Issuer: "auth", Audience: []string{"orders"}, TTL: 900, Leeway: 60}
+	token, claims, err := NewIssuer(store, StaticSigner(key.signer), cfg).Issue(ctx, user.ID)
+	rq.NoError(err)
+	rq.Equal(claims.IssuedAt+900, claims.ExpiresAt)
+	rq.NotEmpty(claims.ID)
+
+	actual, err := NewVerifier(StaticKey(key.key), cfg).Verify(ctx, token)
+	rq.NoError(err)
+	rq.Equal([]string{"orders:read", "orders:write", "payments:*"}, actual.Scopes)
+	rq.Equal([]string{"auth1"}, actual.Auths)
+	id, err := actual.UserID()
+	rq.NoError(err)
+	rq.Equal(user.ID, id)
+
+	rq.True(actual.Can("orders:write"))
+	rq.True(actual.Can("payments:refund"))
+	rq.False(actual.Can("users:read"))
+	rq.True(actual.HasAuth("auth1"))
+	rq.False(actual.HasAuth("auth2"))
+
+	_, _, err = NewIssuer(store, StaticSigner(key.signer), cfg).Issue(ctx, 99999)
+	rq.ErrorIs(err, datastore.ErrorUserNotExist)
+}
@@ -7,6 +7,10 @@ import (
 )
 
 // GenerateSecureRandomString 生成指定长度的安全随机字符串
+//
+// The result is cut to length characters, it carries fewer random bits
+// than it reads and must not be used for credentials, login tokens come
+// from the session package.
 func GenerateSecureRandomString(length int) (string, error) {
 	randomBytes := make([]byte, length)
 	_, err := rand.Read(randomBytes)
@@ -29,6 +33,22 @@ func SortSliceAsc(slices ...[]string) {
 	}
 }
 
+// SliceUnique returns the sorted distinct items of s
+func SliceUnique(s []string) []string {
+	result := make([]string, 0, len(s))
+	result = append(result, s...)
+	SortSliceAsc(result)
+
+	n := 0
+	for i := range result {
+		if i == 0 || result[i] != result[n-1] {
+			result[n] = result[i]
+			n++
+		}
+	}
+	return result[:n]
+}
+
 func sliceOnDiffItems(
 	master []string,
 	participate []string,
@@ -38,34 +58,40 @@ func sliceOnDiffItems(
 ) {
 	SortSliceAsc(master, participate)
 
-	i, j := -1, 0
+	i, j := 0, 0
 
-	for ; j < len(participate); j++ {
-		for i = i + 1; i < len(master) && master[i] < participate[j]; i++ {
+	for i < len(master) && j < len(participate) {
+		switch {
+		case master[i] < participate[j]:
 			if onMasterUnique != nil {
 				onMasterUnique(i)
 			}
-		}
-
-		if i >= len(master) {
-			onParticipateUnique(j)
-			continue
-		} else if master[i] == participate[j] {
-			if onSameItem != nil {
-				onSameItem(i, j)
-			}
-		} else {
+			i++
+		case master[i] > participate[j]:
 			if onParticipateUnique != nil {
 				onParticipateUnique(j)
 			}
+			j++
+		default:
+			if onSameItem != nil {
+				onSameItem(i, j)
+			}
+			i++
+			j++
 		}
 	}
 
 	if onMasterUnique != nil {
-		for i = i + 1; i < len(master); i++ {
+		for ; i < len(master); i++ {
 			onMasterUnique(i)
 		}
 	}
+
+	if onParticipateUnique != nil {
+		for ; j < len(participate); j++ {
+			onParticipateUnique(j)
+		}
+	}
 }
 
 func SliceAppend(origin []string, add []string) ([]string, []string) {
@@ -57,4 +57,47 @@ func TestSliceRemove(t *testing.T) {
 		rq.EqualValues([]string{"1", "3", "5", "7", "8"}, _sort(s3))
 		rq.EqualValues([]string{"9"}, nonExisted)
 	})
+
+	t.Run("remove items less than the remaining ones", func(t *testing.T) {
+		s1 := []string{"1", "3"}
+		s2 := []string{"0", "3"}
+
+		s3, nonExisted := SliceRemove(s1, s2)
+		rq.EqualValues([]string{"1"}, _sort(s3))
+		rq.EqualValues([]string{"0"}, nonExisted)
+	})
+}
+
+func TestSliceOnDiffItems(t *testing.T) {
+	rq := require.New(t)
+
+	var same, masterUnique, participateUnique []string
+	master := []string{"1", "3", "5"}
+	participate := []string{"0", "3", "4", "6"}
+	sliceOnDiffItems(master, participate,
+		func(i int, _ int) {
+			same = append(same, master[i])
+		},
+		func(i int) {
+			masterUnique = append(masterUnique, master[i])
+		},
+		func(j int) {
+			participateUnique = append(participateUnique, participate[j])
+		},
+	)
+
+	// every item is visited once, a master item greater than a participate
+	// one included
+	rq.Equal([]string{"3"}, same)
+	rq.Equal([]string{"1", "5"}, masterUnique)
+	rq.Equal([]string{"0", "4", "6"}, participateUnique)
+}
+
+func TestSliceUnique(t *testing.T) {
+	rq := require.New(t)
+
+	s := []string{"3", "1", "3", "2", "1"}
+	rq.EqualValues([]string{"1", "2", "3"}, SliceUnique(s))
+	rq.EqualValues([]string{"3", "1", "3", "2", "1"}, s)
+	rq.Equal(0, len(SliceUnique(nil)))
 }